@@ -13,6 +13,7 @@ import (
 	"github.com/kata-containers/runtime/pkg/katautils"
 	vc "github.com/kata-containers/runtime/virtcontainers"
 	vcAnnot "github.com/kata-containers/runtime/virtcontainers/pkg/annotations"
+	"github.com/kata-containers/runtime/virtcontainers/pkg/compatoci"
 	"github.com/kata-containers/runtime/virtcontainers/pkg/oci"
 	"github.com/sirupsen/logrus"
 	"github.com/urfave/cli"
@@ -83,6 +84,18 @@ func start(ctx context.Context, containerID string) (vc.VCSandbox, error) {
 		return nil, err
 	}
 
+	bundlePath := status.Annotations[vcAnnot.BundlePathKey]
+	hooks, err := katautils.BundleHooks(bundlePath)
+	if err != nil {
+		return nil, err
+	}
+
+	// Run start-container OCI hooks, in the network namespace of the
+	// sandbox as the post-start ones.
+	if err := startContainerHooks(ctx, hooks, sandboxID, containerID, bundlePath); err != nil {
+		return nil, err
+	}
+
 	var sandbox vc.VCSandbox
 
 	if containerType.IsSandbox() {
@@ -111,3 +124,21 @@ func start(ctx context.Context, containerID string) (vc.VCSandbox, error) {
 
 	return sandbox, nil
 }
+
+// startContainerHooks runs the start-container OCI hooks in the network
+// namespace of the sandbox, which has to be fetched to be known.
+func startContainerHooks(ctx context.Context, hooks compatoci.Hooks, sandboxID, containerID, bundlePath string) error {
+	if len(hooks.StartContainer) == 0 {
+		return nil
+	}
+
+	sandbox, err := vci.FetchSandbox(ctx, sandboxID)
+	if err != nil {
+		return err
+	}
+	defer sandbox.Release()
+
+	return katautils.EnterNetNS(sandbox.GetNetNs(), func() error {
+		return katautils.StartContainerHooks(ctx, hooks, containerID, bundlePath)
+	})
+}
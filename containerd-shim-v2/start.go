@@ -25,6 +25,19 @@ func startContainer(ctx context.Context, s *service, c *container) error {
 		return err
 	}
 
+	hooks, err := katautils.BundleHooks(c.bundle)
+	if err != nil {
+		return err
+	}
+
+	// Run start-container OCI hooks.
+	err = katautils.EnterNetNS(s.sandbox.GetNetNs(), func() error {
+		return katautils.StartContainerHooks(ctx, hooks, c.id, c.bundle)
+	})
+	if err != nil {
+		return err
+	}
+
 	if c.cType.IsSandbox() {
 		err := s.sandbox.Start()
 		if err != nil {
@@ -44,7 +57,7 @@ func startContainer(ctx context.Context, s *service, c *container) error {
 	}
 
 	// Run post-start OCI hooks.
-	err := katautils.EnterNetNS(s.sandbox.GetNetNs(), func() error {
+	err = katautils.EnterNetNS(s.sandbox.GetNetNs(), func() error {
 		return katautils.PostStartHooks(ctx, *c.spec, s.sandbox.ID(), c.bundle)
 	})
 	if err != nil {
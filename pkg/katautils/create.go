@@ -143,9 +143,17 @@ func CreateSandbox(ctx context.Context, vci vc.VC, ociSpec specs.Spec, runtimeCo
 		}
 	}()
 
-	// Run pre-start OCI hooks.
+	hooks, err := BundleHooks(bundlePath)
+	if err != nil {
+		return nil, vc.Process{}, err
+	}
+
+	// Run pre-start, create-runtime and create-container OCI hooks.
 	err = EnterNetNS(sandboxConfig.NetworkConfig.NetNSPath, func() error {
-		return PreStartHooks(ctx, ociSpec, containerID, bundlePath)
+		if err := PreStartHooks(ctx, ociSpec, containerID, bundlePath); err != nil {
+			return err
+		}
+		return CreateRuntimeHooks(ctx, hooks, containerID, bundlePath)
 	})
 	if err != nil {
 		return nil, vc.Process{}, err
@@ -225,9 +233,17 @@ func CreateContainer(ctx context.Context, vci vc.VC, sandbox vc.VCSandbox, ociSp
 		}
 	}
 
-	// Run pre-start OCI hooks.
+	hooks, err := BundleHooks(bundlePath)
+	if err != nil {
+		return vc.Process{}, err
+	}
+
+	// Run pre-start, create-runtime and create-container OCI hooks.
 	err = EnterNetNS(sandbox.GetNetNs(), func() error {
-		return PreStartHooks(ctx, ociSpec, containerID, bundlePath)
+		if err := PreStartHooks(ctx, ociSpec, containerID, bundlePath); err != nil {
+			return err
+		}
+		return CreateRuntimeHooks(ctx, hooks, containerID, bundlePath)
 	})
 	if err != nil {
 		return vc.Process{}, err
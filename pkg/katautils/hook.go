@@ -11,11 +11,13 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"os"
 	"os/exec"
 	"strings"
 	"syscall"
 	"time"
 
+	"github.com/kata-containers/runtime/virtcontainers/pkg/compatoci"
 	"github.com/opencontainers/runtime-spec/specs-go"
 	"github.com/opentracing/opentracing-go/log"
 	"github.com/sirupsen/logrus"
@@ -36,6 +38,11 @@ func runHook(ctx context.Context, hook specs.Hook, cid, bundlePath string) error
 		log.String("hook-name", hook.Path),
 		log.String("hook-args", strings.Join(hook.Args, " ")))
 
+	// As with runc, a nil or zero timeout means the hook is not bounded.
+	if hook.Timeout != nil && *hook.Timeout < 0 {
+		return fmt.Errorf("Invalid timeout %d for hook %s", *hook.Timeout, hook.Path)
+	}
+
 	state := specs.State{
 		Pid:    syscall.Gettid(),
 		Bundle: bundlePath,
@@ -61,7 +68,7 @@ func runHook(ctx context.Context, hook specs.Hook, cid, bundlePath string) error
 		return err
 	}
 
-	if hook.Timeout == nil {
+	if hook.Timeout == nil || *hook.Timeout == 0 {
 		if err := cmd.Wait(); err != nil {
 			return fmt.Errorf("%s: stdout: %s, stderr: %s", err, stdout.String(), stderr.String())
 		}
@@ -82,7 +89,7 @@ func runHook(ctx context.Context, hook specs.Hook, cid, bundlePath string) error
 				return err
 			}
 
-			return fmt.Errorf("Hook timeout")
+			return fmt.Errorf("Hook %s timed out after %d seconds", hook.Path, *hook.Timeout)
 		}
 	}
 
@@ -138,3 +145,29 @@ func PostStopHooks(ctx context.Context, spec specs.Spec, cid, bundlePath string)
 
 	return runHooks(ctx, spec.Hooks.Poststop, cid, bundlePath, "post-stop")
 }
+
+// BundleHooks returns the hooks of every phase from the config.json file of
+// the bundle. A bundle without config.json has no hooks.
+func BundleHooks(bundlePath string) (compatoci.Hooks, error) {
+	hooks, err := compatoci.ParseConfigJSONHooks(bundlePath)
+	if os.IsNotExist(err) {
+		return compatoci.Hooks{}, nil
+	}
+
+	return hooks, err
+}
+
+// CreateRuntimeHooks run the createRuntime hooks, then the createContainer
+// ones, right after the pre-start hooks
+func CreateRuntimeHooks(ctx context.Context, hooks compatoci.Hooks, cid, bundlePath string) error {
+	if err := runHooks(ctx, hooks.CreateRuntime, cid, bundlePath, "create-runtime"); err != nil {
+		return err
+	}
+
+	return runHooks(ctx, hooks.CreateContainer, cid, bundlePath, "create-container")
+}
+
+// StartContainerHooks run the startContainer hooks just before start container
+func StartContainerHooks(ctx context.Context, hooks compatoci.Hooks, cid, bundlePath string) error {
+	return runHooks(ctx, hooks.StartContainer, cid, bundlePath, "start-container")
+}
@@ -8,10 +8,13 @@ package katautils
 
 import (
 	"context"
+	"io/ioutil"
 	"os"
+	"path/filepath"
 	"testing"
 
 	ktu "github.com/kata-containers/runtime/pkg/katatestutils"
+	"github.com/kata-containers/runtime/virtcontainers/pkg/compatoci"
 	. "github.com/kata-containers/runtime/virtcontainers/pkg/mock"
 	"github.com/opencontainers/runtime-spec/specs-go"
 	"github.com/stretchr/testify/assert"
@@ -85,6 +88,15 @@ func TestRunHook(t *testing.T) {
 	assert.Error(err)
 }
 
+func TestRunHookNegativeTimeout(t *testing.T) {
+	assert := assert.New(t)
+
+	hook := createHook(-1)
+	err := runHook(context.Background(), hook, testSandboxID, testBundlePath)
+	assert.Error(err)
+	assert.Contains(err.Error(), "Invalid timeout")
+}
+
 func TestPreStartHooks(t *testing.T) {
 	if tc.NotValid(ktu.NeedRoot()) {
 		t.Skip(ktu.TestDisabledNeedRoot)
@@ -210,3 +222,77 @@ func TestPostStopHooks(t *testing.T) {
 	err = PostStopHooks(ctx, spec, testSandboxID, testBundlePath)
 	assert.Error(err)
 }
+
+func TestBundleHooks(t *testing.T) {
+	assert := assert.New(t)
+
+	bundlePath, err := ioutil.TempDir("", "katautils-hooks")
+	assert.NoError(err)
+	defer os.RemoveAll(bundlePath)
+
+	// no config.json, no hooks
+	hooks, err := BundleHooks(bundlePath)
+	assert.NoError(err)
+	assert.Equal(compatoci.Hooks{}, hooks)
+
+	configPath := filepath.Join(bundlePath, "config.json")
+	err = ioutil.WriteFile(configPath, []byte(`{"hooks": {"createRuntime": [{"path": "/usr/bin/setup"}]}}`), 0644)
+	assert.NoError(err)
+
+	hooks, err = BundleHooks(bundlePath)
+	assert.NoError(err)
+	assert.Equal([]specs.Hook{{Path: "/usr/bin/setup"}}, hooks.CreateRuntime)
+
+	err = ioutil.WriteFile(configPath, []byte("{"), 0644)
+	assert.NoError(err)
+
+	_, err = BundleHooks(bundlePath)
+	assert.Error(err)
+}
+
+func TestCreateRuntimeHooks(t *testing.T) {
+	if tc.NotValid(ktu.NeedRoot()) {
+		t.Skip(ktu.TestDisabledNeedRoot)
+	}
+
+	assert := assert.New(t)
+
+	ctx := context.Background()
+
+	// no hooks
+	err := CreateRuntimeHooks(ctx, compatoci.Hooks{}, testSandboxID, testBundlePath)
+	assert.NoError(err)
+
+	hooks := compatoci.Hooks{
+		CreateRuntime:   []specs.Hook{createHook(0)},
+		CreateContainer: []specs.Hook{createHook(0)},
+	}
+	err = CreateRuntimeHooks(ctx, hooks, testSandboxID, testBundlePath)
+	assert.NoError(err)
+
+	// Failure due to wrong createContainer hook
+	hooks.CreateContainer = []specs.Hook{createWrongHook()}
+	err = CreateRuntimeHooks(ctx, hooks, testSandboxID, testBundlePath)
+	assert.Error(err)
+}
+
+func TestStartContainerHooks(t *testing.T) {
+	if tc.NotValid(ktu.NeedRoot()) {
+		t.Skip(ktu.TestDisabledNeedRoot)
+	}
+
+	assert := assert.New(t)
+
+	ctx := context.Background()
+
+	hooks := compatoci.Hooks{
+		StartContainer: []specs.Hook{createHook(0)},
+	}
+	err := StartContainerHooks(ctx, hooks, testSandboxID, testBundlePath)
+	assert.NoError(err)
+
+	// Failure due to wrong hook
+	hooks.StartContainer = []specs.Hook{createWrongHook()}
+	err = StartContainerHooks(ctx, hooks, testSandboxID, testBundlePath)
+	assert.Error(err)
+}
@@ -74,6 +74,9 @@ func (a *acrn) kernelParameters() string {
 	// params are added here, they will take priority over the defaults.
 	params = append(params, a.config.KernelParams...)
 
+	// the init arguments must come last
+	params = appendInitArgs(params, a.config.InitArgs)
+
 	paramsStr := SerializeParams(params, "=")
 
 	return strings.Join(paramsStr, " ")
@@ -14,6 +14,7 @@ import (
 	"strings"
 
 	"github.com/containerd/cgroups"
+	"github.com/kata-containers/runtime/virtcontainers/utils"
 	specs "github.com/opencontainers/runtime-spec/specs-go"
 )
 
@@ -172,6 +173,19 @@ func validCPUResources(cpuSpec *specs.LinuxCPU) *specs.LinuxCPU {
 	return &cpu
 }
 
+// containerCgroupPath returns the host cgroup path of a container, from the
+// cgroups path of its OCI spec.
+func containerCgroupPath(cgroupsPath string) (string, error) {
+	return renameCgroupPath(utils.ValidCgroupPath(cgroupsPath))
+}
+
+// sandboxCgroupPath returns the host cgroup path of a sandbox, next to the
+// cgroup of its sandbox container, from the cgroups path of the sandbox
+// container OCI spec.
+func sandboxCgroupPath(cgroupsPath, sandboxID string) string {
+	return filepath.Join(filepath.Dir(utils.ValidCgroupPath(cgroupsPath)), cgroupKataPrefix+"_"+sandboxID)
+}
+
 func renameCgroupPath(path string) (string, error) {
 	if path == "" {
 		return "", fmt.Errorf("Cgroup path is empty")
@@ -84,6 +84,11 @@ type ContainerStatus struct {
 	// for example to add additional status values required
 	// to support particular specifications.
 	Annotations map[string]string
+
+	// ExitCode is the exit code of the container process once it has
+	// been waited on, a process killed by a signal having the 128+signal
+	// code. It is nil while the exit code is unknown.
+	ExitCode *int32
 }
 
 // ThrottlingData gather the date related to container cpu throttling.
@@ -250,6 +255,24 @@ type ContainerConfig struct {
 	// Resources container resources
 	Resources specs.LinuxResources
 
+	// UnifiedResources holds the cgroup v2 unified resources of the
+	// container, as interface files names and values, e.g. "memory.max".
+	// They are translated into the cgroup v1 resources sent to the agent.
+	UnifiedResources map[string]string
+
+	// PermissiveDevices is set when the device cgroup rules of the
+	// container allow access to every device. This gives the container
+	// access to all the devices of the guest, including the ones hotplugged
+	// for other containers of the sandbox, and must only be used for
+	// privileged containers.
+	PermissiveDevices bool
+
+	// CPUAffinity holds the sorted list of the host CPUs requested for
+	// the container, empty when none is. The vCPUs are shared by all the
+	// containers of the sandbox, so they are pinned to the CPUs of all of
+	// them through the cpuset of the sandbox cgroup.
+	CPUAffinity []int
+
 	// Raw OCI specification, it won't be saved to disk.
 	Spec *specs.Spec `json:"_"`
 }
@@ -267,6 +290,79 @@ func (c *ContainerConfig) valid() bool {
 	return true
 }
 
+// HasWritableRootfs tells if the container can genuinely write to its rootfs.
+// This is not the case when the rootfs is read-only, even if writable mounts
+// exist since writes to them don't reach the rootfs, or when a mount shadows
+// the whole rootfs.
+func (c ContainerConfig) HasWritableRootfs() bool {
+	if c.ReadonlyRootfs {
+		return false
+	}
+
+	for _, m := range c.Mounts {
+		if filepath.Clean(m.Destination) == "/" {
+			return false
+		}
+	}
+
+	return true
+}
+
+// validationErrors runs every validation of the container configuration and
+// returns all the problems found. Each error names the container and the
+// field it relates to.
+func (c *ContainerConfig) validationErrors() []error {
+	var errs []error
+
+	name := fmt.Sprintf("container %q", c.ID)
+	if c.ID == "" {
+		errs = append(errs, fmt.Errorf("container: ID cannot be empty"))
+	}
+
+	errs = appendValidationErrors(errs, name, ValidateMountDestinations(c.Mounts))
+
+	for i, d := range c.DeviceInfos {
+		if d.ContainerPath == "" {
+			errs = append(errs, fmt.Errorf("%s: device %d: path cannot be empty", name, i))
+		}
+
+		switch d.DevType {
+		case "c", "b", "u", "p":
+		default:
+			errs = append(errs, fmt.Errorf("%s: device %d: unexpected device type %q", name, i, d.DevType))
+		}
+	}
+
+	if caps := c.Cmd.Capabilities; caps != nil {
+		sets := map[string][]string{
+			"bounding":    caps.Bounding,
+			"effective":   caps.Effective,
+			"inheritable": caps.Inheritable,
+			"permitted":   caps.Permitted,
+			"ambient":     caps.Ambient,
+		}
+
+		for _, set := range []string{"bounding", "effective", "inheritable", "permitted", "ambient"} {
+			for _, capability := range sets[set] {
+				if !types.IsKnownCapability(capability) {
+					errs = append(errs, fmt.Errorf("%s: %s capabilities: unknown capability %q", name, set, capability))
+				}
+			}
+		}
+	}
+
+	if mem := c.Resources.Memory; mem != nil && mem.Limit != nil && *mem.Limit < -1 {
+		errs = append(errs, fmt.Errorf("%s: memory limit %d is invalid", name, *mem.Limit))
+	}
+
+	if cpu := c.Resources.CPU; cpu != nil && cpu.Quota != nil && *cpu.Quota > 0 &&
+		(cpu.Period == nil || *cpu.Period == 0) {
+		errs = append(errs, fmt.Errorf("%s: CPU quota %d requires a CPU period", name, *cpu.Quota))
+	}
+
+	return errs
+}
+
 // SystemMountsInfo describes additional information for system mounts that the agent
 // needs to handle
 type SystemMountsInfo struct {
@@ -591,7 +687,7 @@ func (c *Container) mountSharedDirMounts(hostSharedDir, guestSharedDir string) (
 
 		// Check if mount is readonly, let the agent handle the readonly mount
 		// within the VM.
-		readonly := false
+		readonly := m.ReadOnly
 		for _, flag := range m.Options {
 			if flag == "ro" {
 				readonly = true
@@ -1144,7 +1240,18 @@ func (c *Container) wait(processID string) (int32, error) {
 			"impossible to wait")
 	}
 
-	return c.sandbox.agent.waitProcess(c, processID)
+	exitCode, err := c.sandbox.agent.waitProcess(c, processID)
+	if err != nil {
+		return 0, err
+	}
+
+	// The exit code of the container process is kept with the container
+	// state, which is saved when the container is stopped.
+	if processID == c.process.Token {
+		c.state.ExitCode = &exitCode
+	}
+
+	return exitCode, nil
 }
 
 func (c *Container) kill(signal syscall.Signal, all bool) error {
@@ -1474,8 +1581,7 @@ func (c *Container) cgroupsCreate() (err error) {
 		resources.CPU = validCPUResources(spec.Linux.Resources.CPU)
 	}
 
-	cgroupPath := utils.ValidCgroupPath(spec.Linux.CgroupsPath)
-	c.state.CgroupPath, err = renameCgroupPath(cgroupPath)
+	c.state.CgroupPath, err = containerCgroupPath(spec.Linux.CgroupsPath)
 	if err != nil {
 		return err
 	}
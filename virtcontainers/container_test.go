@@ -500,6 +500,89 @@ func TestContainerWaitErrorState(t *testing.T) {
 	assert.Error(err)
 }
 
+func TestContainerWaitExitCode(t *testing.T) {
+	assert := assert.New(t)
+	c := &Container{
+		sandbox: &Sandbox{
+			agent: &noopAgent{},
+		},
+		process: Process{
+			Token: "containerProcess",
+		},
+		state: types.ContainerState{
+			State: types.StateRunning,
+		},
+	}
+
+	// exec'd processes don't set the container exit code
+	_, err := c.wait("execProcess")
+	assert.NoError(err)
+	assert.Nil(c.state.ExitCode)
+
+	_, err = c.wait(c.process.Token)
+	assert.NoError(err)
+	assert.NotNil(c.state.ExitCode)
+	assert.Equal(int32(0), *c.state.ExitCode)
+}
+
+// copyFileAgent records the files copied to the guest.
+type copyFileAgent struct {
+	noopAgent
+	copied []string
+}
+
+func (a *copyFileAgent) copyFile(src, dst string) error {
+	a.copied = append(a.copied, src)
+	return nil
+}
+
+// noFsSharingHypervisor doesn't support sharing the host file system, so
+// that the shared files are copied to the guest.
+type noFsSharingHypervisor struct {
+	mockHypervisor
+}
+
+func (h *noFsSharingHypervisor) capabilities() types.Capabilities {
+	var caps types.Capabilities
+	caps.SetFsSharingUnsupported()
+	return caps
+}
+
+func TestContainerShareFilesSubPath(t *testing.T) {
+	assert := assert.New(t)
+
+	volume, err := ioutil.TempDir("", "volume")
+	assert.NoError(err)
+	defer os.RemoveAll(volume)
+
+	source := filepath.Join(volume, "config", "app.conf")
+	assert.NoError(os.MkdirAll(filepath.Dir(source), 0755))
+	assert.NoError(ioutil.WriteFile(source, []byte("conf"), 0644))
+	assert.NoError(ioutil.WriteFile(filepath.Join(volume, "secret"), []byte("secret"), 0644))
+
+	agent := &copyFileAgent{}
+	c := &Container{
+		id: "100",
+		sandbox: &Sandbox{
+			agent:      agent,
+			hypervisor: &noFsSharingHypervisor{},
+		},
+	}
+
+	m := Mount{
+		Source:      source,
+		Destination: "/etc/app.conf",
+		Type:        "bind",
+		SubPath:     "config/app.conf",
+	}
+
+	// only the subpath is shared, not the whole volume
+	_, ignore, err := c.shareFiles(m, 0, "", "")
+	assert.NoError(err)
+	assert.False(ignore)
+	assert.Equal([]string{source}, agent.copied)
+}
+
 func TestKillContainerErrorState(t *testing.T) {
 	assert := assert.New(t)
 	c := &Container{
@@ -570,3 +653,28 @@ func TestProcessIOStream(t *testing.T) {
 	_, _, _, err = c.ioStream(processID)
 	assert.Error(err)
 }
+
+func TestContainerConfigHasWritableRootfs(t *testing.T) {
+	assert := assert.New(t)
+
+	c := ContainerConfig{
+		Mounts: []Mount{
+			{Source: "/host/data", Destination: "/data", Type: "bind", Options: []string{"rbind", "rw"}},
+		},
+	}
+	assert.True(c.HasWritableRootfs())
+
+	// writable mounts don't make a read-only rootfs writable
+	c.ReadonlyRootfs = true
+	assert.False(c.HasWritableRootfs())
+
+	c.Mounts = nil
+	assert.False(c.HasWritableRootfs())
+
+	// a mount shadowing the whole rootfs
+	c.ReadonlyRootfs = false
+	c.Mounts = []Mount{
+		{Source: "/host/root", Destination: "/", Type: "bind", Options: []string{"rbind", "rw"}},
+	}
+	assert.False(c.HasWritableRootfs())
+}
@@ -16,7 +16,6 @@ import (
 	"github.com/kata-containers/runtime/virtcontainers/factory/direct"
 	"github.com/kata-containers/runtime/virtcontainers/factory/grpccache"
 	"github.com/kata-containers/runtime/virtcontainers/factory/template"
-	"github.com/kata-containers/runtime/virtcontainers/utils"
 	opentracing "github.com/opentracing/opentracing-go"
 	"github.com/sirupsen/logrus"
 )
@@ -105,35 +104,8 @@ func (f *factory) log() *logrus.Entry {
 	return factoryLogger.WithField("subsystem", "factory")
 }
 
-func resetHypervisorConfig(config *vc.VMConfig) {
-	config.HypervisorConfig.NumVCPUs = 0
-	config.HypervisorConfig.MemorySize = 0
-	config.HypervisorConfig.BootToBeTemplate = false
-	config.HypervisorConfig.BootFromTemplate = false
-	config.HypervisorConfig.MemoryPath = ""
-	config.HypervisorConfig.DevicesStatePath = ""
-	config.ProxyConfig = vc.ProxyConfig{}
-}
-
-// It's important that baseConfig and newConfig are passed by value!
 func checkVMConfig(config1, config2 vc.VMConfig) error {
-	if config1.HypervisorType != config2.HypervisorType {
-		return fmt.Errorf("hypervisor type does not match: %s vs. %s", config1.HypervisorType, config2.HypervisorType)
-	}
-
-	if config1.AgentType != config2.AgentType {
-		return fmt.Errorf("agent type does not match: %s vs. %s", config1.AgentType, config2.AgentType)
-	}
-
-	// check hypervisor config details
-	resetHypervisorConfig(&config1)
-	resetHypervisorConfig(&config2)
-
-	if !utils.DeepCompare(config1, config2) {
-		return fmt.Errorf("hypervisor config does not match, base: %+v. new: %+v", config1, config2)
-	}
-
-	return nil
+	return config1.CheckCompatible(config2)
 }
 
 func (f *factory) checkConfig(config vc.VMConfig) error {
@@ -478,6 +478,17 @@ func (fc *firecracker) fcJailResource(src, dst string) (string, error) {
 	return absPath, nil
 }
 
+// kernelParameters returns the guest kernel command line, the config
+// provided parameters coming before the firecracker ones, and the init
+// arguments last.
+func (fc *firecracker) kernelParameters() string {
+	params := append([]Param{}, fc.config.KernelParams...)
+	params = append(params, fcKernelParams...)
+	params = appendInitArgs(params, fc.config.InitArgs)
+
+	return strings.Join(SerializeParams(params, "="), " ")
+}
+
 func (fc *firecracker) fcSetBootSource(path, params string) error {
 	span, _ := fc.trace("fcSetBootSource")
 	defer span.Finish()
@@ -599,10 +610,7 @@ func (fc *firecracker) startSandbox(timeout int) error {
 		return err
 	}
 
-	kernelParams := append(fc.config.KernelParams, fcKernelParams...)
-	strParams := SerializeParams(kernelParams, "=")
-	formattedParams := strings.Join(strParams, " ")
-	fc.fcSetBootSource(kernelPath, formattedParams)
+	fc.fcSetBootSource(kernelPath, fc.kernelParameters())
 
 	image, err := fc.config.InitrdAssetPath()
 	if err != nil {
@@ -0,0 +1,31 @@
+// Copyright (c) 2026 Kata Contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+
+package virtcontainers
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFCKernelParameters(t *testing.T) {
+	assert := assert.New(t)
+
+	fcParams := strings.Join(SerializeParams(fcKernelParams, "="), " ")
+
+	fc := &firecracker{
+		config: HypervisorConfig{
+			KernelParams: []Param{{Key: "foo", Value: "bar"}},
+		},
+	}
+	assert.Equal("foo=bar "+fcParams, fc.kernelParameters())
+
+	// the init arguments come after the firecracker parameters
+	fc.config.InitArgs = []string{"--log-level", "debug"}
+	assert.Equal("foo=bar "+fcParams+" -- --log-level debug", fc.kernelParameters())
+	assert.Equal([]Param{{Key: "foo", Value: "bar"}}, fc.config.KernelParams)
+}
@@ -201,6 +201,11 @@ type HypervisorConfig struct {
 	// KernelParams are additional guest kernel parameters.
 	KernelParams []Param
 
+	// InitArgs are the arguments passed to the guest init. They are
+	// appended by the hypervisor at the very end of the kernel command
+	// line, after the "--" separator.
+	InitArgs []string
+
 	// HypervisorParams are additional hypervisor parameters.
 	HypervisorParams []Param
 
@@ -263,6 +268,10 @@ type HypervisorConfig struct {
 	// VirtioFSExtraArgs passes options to virtiofsd daemon
 	VirtioFSExtraArgs []string
 
+	// VirtioFSQueueSize is the size of the virtio-fs request queues, the
+	// hypervisor default being used when 0. Only QEMU applies it.
+	VirtioFSQueueSize uint32
+
 	// customAssets is a map of assets.
 	// Each value in that map takes precedence over the configured assets.
 	// For example, if there is a value for the "kernel" key in this map,
@@ -280,6 +289,12 @@ type HypervisorConfig struct {
 	// Denotes whether flush requests for the device are ignored.
 	BlockDeviceCacheNoflush bool
 
+	// BlockDeviceCacheMode is the cache mode requested for block devices:
+	// "writeback", "writethrough" or "none". It is translated into the
+	// BlockDeviceCache* options, QEMU also turning the device write cache
+	// off for "writethrough". Only QEMU applies it.
+	BlockDeviceCacheMode string
+
 	// DisableBlockDeviceUse disallows a block device from being used.
 	DisableBlockDeviceUse bool
 
@@ -297,6 +312,13 @@ type HypervisorConfig struct {
 	// HugePages specifies if the memory should be pre-allocated from huge pages
 	HugePages bool
 
+	// HugepageNUMANode is the host NUMA node the huge pages backing the
+	// guest memory are allocated from, when HugePages is set. The memory
+	// nodes of the sandbox cgroup are restricted to it, which only applies
+	// to the hypervisor with SandboxCgroupOnly, and a nil value lets the
+	// host pick the node.
+	HugepageNUMANode *uint32
+
 	// File based memory backend root directory
 	FileBackedMemRootDir string
 
@@ -328,6 +350,28 @@ type HypervisorConfig struct {
 	// DisableVhostNet is used to indicate if host supports vhost_net
 	DisableVhostNet bool
 
+	// EnableKdump specifies if the guest kernel should reserve memory for
+	// a crash kernel and capture crash dumps through kdump.
+	EnableKdump bool
+
+	// EnableNestedVirt specifies if the host virtualization extensions
+	// should be exposed to the guest.
+	EnableNestedVirt bool
+
+	// EnableWatchdog specifies if a watchdog device resetting the guest
+	// when it hangs should be added to the VM.
+	EnableWatchdog bool
+
+	// WatchdogTimeout is the number of seconds after which the watchdog
+	// resets an unresponsive guest. A zero value keeps the guest driver
+	// default.
+	WatchdogTimeout uint32
+
+	// ConfidentialGuest specifies if the guest runs with a confidential
+	// computing technology, such as AMD SEV or Intel TDX, protecting its
+	// memory from the host.
+	ConfidentialGuest bool
+
 	// GuestHookPath is the path within the VM that will be used for 'drop-in' hooks
 	GuestHookPath string
 
@@ -552,6 +596,27 @@ func SerializeParams(params []Param, delim string) []string {
 	return parameters
 }
 
+// initArgsSeparator separates the kernel parameters from the arguments
+// passed to the guest init on the kernel command line.
+const initArgsSeparator = "--"
+
+// appendInitArgs appends the guest init arguments to the kernel parameters,
+// after the "--" separator. As the kernel passes everything following the
+// separator to the init, this must be done once all the other kernel
+// parameters are set.
+func appendInitArgs(params []Param, initArgs []string) []Param {
+	if len(initArgs) == 0 {
+		return params
+	}
+
+	params = append(params, Param{Key: initArgsSeparator})
+	for _, arg := range initArgs {
+		params = append(params, Param{Key: arg})
+	}
+
+	return params
+}
+
 // DeserializeParams converts []string to []Param
 func DeserializeParams(parameters []string) []Param {
 	var params []Param
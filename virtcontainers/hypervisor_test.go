@@ -10,6 +10,7 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -284,6 +285,17 @@ func testDeserializeParams(t *testing.T, parameters []string, expected []Param)
 	assert.Exactly(result, expected)
 }
 
+func TestAppendInitArgs(t *testing.T) {
+	assert := assert.New(t)
+
+	params := []Param{{Key: "quiet"}}
+	assert.Equal(params, appendInitArgs(params, nil))
+
+	params = appendInitArgs(params, []string{"--log-level", "debug"})
+	assert.Equal([]Param{{Key: "quiet"}, {Key: "--"}, {Key: "--log-level"}, {Key: "debug"}}, params)
+	assert.Equal("quiet -- --log-level debug", strings.Join(SerializeParams(params, "="), " "))
+}
+
 func TestDeserializeParamsNil(t *testing.T) {
 	var parameters []string
 	var expected []Param
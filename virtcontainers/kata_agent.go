@@ -26,6 +26,7 @@ import (
 	"github.com/kata-containers/runtime/virtcontainers/device/config"
 	persistapi "github.com/kata-containers/runtime/virtcontainers/persist/api"
 	vcAnnotations "github.com/kata-containers/runtime/virtcontainers/pkg/annotations"
+	"github.com/kata-containers/runtime/virtcontainers/pkg/compatoci"
 	ns "github.com/kata-containers/runtime/virtcontainers/pkg/nsenter"
 	vcTypes "github.com/kata-containers/runtime/virtcontainers/pkg/types"
 	"github.com/kata-containers/runtime/virtcontainers/pkg/uuid"
@@ -143,6 +144,40 @@ type KataAgentConfig struct {
 	TraceMode     string
 	TraceType     string
 	KernelModules []string
+
+	// InitArgs are the arguments passed to the guest init, through
+	// HypervisorConfig.InitArgs.
+	InitArgs []string
+}
+
+// ModprobeConfig renders the kernel modules parameters as the content of a
+// modprobe.d configuration file, with an "options <module> <params>" line
+// per module with parameters. Modules without parameters are skipped.
+func (c KataAgentConfig) ModprobeConfig() string {
+	var b strings.Builder
+
+	for _, m := range c.KernelModules {
+		name, params := ParseKernelModule(m)
+		if name == "" || len(params) == 0 {
+			continue
+		}
+
+		fmt.Fprintf(&b, "options %s %s\n", name, strings.Join(params, " "))
+	}
+
+	return b.String()
+}
+
+// ParseKernelModule splits a kernel module entry, made of the module name
+// followed by its space separated parameters, into the module name and
+// parameters. An empty name is returned for a blank entry.
+func ParseKernelModule(module string) (string, []string) {
+	fields := strings.Fields(module)
+	if len(fields) == 0 {
+		return "", nil
+	}
+
+	return fields[0], fields[1:]
 }
 
 type kataVSOCK struct {
@@ -844,18 +879,18 @@ func setupKernelModules(kmodules []string) []*grpc.KernelModule {
 	modules := []*grpc.KernelModule{}
 
 	for _, m := range kmodules {
-		l := strings.Fields(strings.TrimSpace(m))
-		if len(l) == 0 {
+		name, params := ParseKernelModule(m)
+		if name == "" {
 			continue
 		}
 
-		module := &grpc.KernelModule{Name: l[0]}
+		module := &grpc.KernelModule{Name: name}
 		modules = append(modules, module)
-		if len(l) == 1 {
+		if len(params) == 0 {
 			continue
 		}
 
-		module.Parameters = append(module.Parameters, l[1:]...)
+		module.Parameters = append(module.Parameters, params...)
 	}
 
 	return modules
@@ -1287,6 +1322,11 @@ func (k *kataAgent) createContainer(sandbox *Sandbox, c *Container) (p *Process,
 		return nil, errorMissingOCISpec
 	}
 
+	// The agent only understands the cgroup v1 resources.
+	if err = compatoci.ApplyUnifiedResources(ociSpec, c.config.UnifiedResources); err != nil {
+		return nil, err
+	}
+
 	// Handle container mounts
 	newMounts, ignoredMounts, err := c.mountSharedDirMounts(kataHostSharedDir, kataGuestSharedDir)
 	if err != nil {
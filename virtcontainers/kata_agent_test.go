@@ -1130,3 +1130,36 @@ func TestKataAgentSetDefaultTraceConfigOptions(t *testing.T) {
 		}
 	}
 }
+
+func TestParseKernelModule(t *testing.T) {
+	assert := assert.New(t)
+
+	name, params := ParseKernelModule(" e1000e InterruptThrottleRate=3000,3000,3000  EEE=1 ")
+	assert.Equal("e1000e", name)
+	assert.Equal([]string{"InterruptThrottleRate=3000,3000,3000", "EEE=1"}, params)
+
+	name, params = ParseKernelModule("virtio_net")
+	assert.Equal("virtio_net", name)
+	assert.Empty(params)
+
+	name, params = ParseKernelModule("  ")
+	assert.Empty(name)
+	assert.Empty(params)
+}
+
+func TestKataAgentConfigModprobeConfig(t *testing.T) {
+	assert := assert.New(t)
+
+	c := KataAgentConfig{
+		KernelModules: []string{
+			"e1000e InterruptThrottleRate=3000,3000,3000 EEE=1",
+			"i915 enable_ppgtt=0",
+		},
+	}
+	assert.Equal("options e1000e InterruptThrottleRate=3000,3000,3000 EEE=1\n"+
+		"options i915 enable_ppgtt=0\n", c.ModprobeConfig())
+
+	// modules without parameters have no options
+	c.KernelModules = []string{"virtio_net", ""}
+	assert.Empty(c.ModprobeConfig())
+}
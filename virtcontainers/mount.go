@@ -326,6 +326,17 @@ type Mount struct {
 	// VM in case this mount is a block device file or a directory
 	// backed by a block device.
 	BlockDeviceID string
+
+	// SubPath is the sub-directory of its volume that Source points to, as
+	// with Kubernetes subPath mounts. Source remains the full path of that
+	// sub-directory, which is the only part of the volume shared with the
+	// guest.
+	SubPath string
+
+	// SizeBytes is the size of a tmpfs mount, as requested by its "size="
+	// option. The raw option is kept in Options. A zero value means the
+	// size is not set or is relative to the memory.
+	SizeBytes uint64
 }
 
 func bindUnmountContainerRootfs(ctx context.Context, sharedDir, sandboxID, cID string) error {
@@ -341,6 +352,20 @@ func bindUnmountContainerRootfs(ctx context.Context, sharedDir, sandboxID, cID s
 	return err
 }
 
+// ValidateMountDestinations checks that the destination of every mount is
+// an absolute path. All the relative destinations are reported in a single
+// error.
+func ValidateMountDestinations(mounts []Mount) error {
+	var result *merr.Error
+	for _, m := range mounts {
+		if !filepath.IsAbs(m.Destination) {
+			result = merr.Append(result, fmt.Errorf("mount destination %q is not absolute", m.Destination))
+		}
+	}
+
+	return result.ErrorOrNil()
+}
+
 func bindUnmountAllRootfs(ctx context.Context, sharedDir string, sandbox *Sandbox) error {
 	span, _ := trace(ctx, "bindUnmountAllRootfs")
 	defer span.Finish()
@@ -46,6 +46,7 @@ func (s *Sandbox) dumpState(ss *persistapi.SandboxState, cs map[string]persistap
 			FsType:        cont.state.Fstype,
 		}
 		state.CgroupPath = cont.state.CgroupPath
+		state.ExitCode = cont.state.ExitCode
 		cs[id] = state
 	}
 
@@ -208,6 +209,7 @@ func (c *Container) loadContState(cs persistapi.ContainerState) {
 		BlockDeviceID: cs.Rootfs.BlockDeviceID,
 		Fstype:        cs.Rootfs.FsType,
 		CgroupPath:    cs.CgroupPath,
+		ExitCode:      cs.ExitCode,
 	}
 }
 
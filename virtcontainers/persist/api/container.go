@@ -96,6 +96,10 @@ type ContainerState struct {
 	// including the hypervisor are placed.
 	CgroupPath string
 
+	// ExitCode is the exit code of the container process, nil until
+	// the process has been waited on.
+	ExitCode *int32
+
 	// DeviceMaps is mapping between sandbox device to dest in container
 	DeviceMaps []DeviceMap
 
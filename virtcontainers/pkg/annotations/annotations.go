@@ -8,6 +8,12 @@ package annotations
 const (
 	vcAnnotationsPrefix = "com.github.containers.virtcontainers."
 
+	kataAnnotationsPrefix     = "io.katacontainers."
+	kataConfAnnotationsPrefix = kataAnnotationsPrefix + "config."
+	kataAnnotHypervisorPrefix = kataConfAnnotationsPrefix + "hypervisor."
+	kataAnnotRuntimePrefix    = kataConfAnnotationsPrefix + "runtime."
+	kataAnnotAgentPrefix      = kataConfAnnotationsPrefix + "agent."
+
 	// KernelPath is a sandbox annotation for passing a per container path pointing at the kernel needed to boot the container VM.
 	KernelPath = vcAnnotationsPrefix + "KernelPath"
 
@@ -65,6 +71,189 @@ const (
 	// The first word is considered as the module name and the rest as its parameters.
 	//
 	KernelModules = vcAnnotationsPrefix + "KernelModules"
+
+	// MountSubPaths is the annotation key for passing the sub-directories
+	// of the volumes mounted through Kubernetes subPath mounts.
+	// Semicolon separated list of mount destinations and the subpath of
+	// the volume mounted there, the mount source being the subpath within
+	// the volume:
+	//
+	//   annotations:
+	//     com.github.containers.virtcontainers.MountSubPaths: "/data=logs/app; /etc/app=config"
+	//
+	MountSubPaths = vcAnnotationsPrefix + "MountSubPaths"
+
+	// ExitCodeKey is the OCI state annotation holding the exit code of the
+	// process of a stopped container.
+	ExitCodeKey = kataAnnotationsPrefix + "exit-code"
+)
+
+// Annotations related to the hypervisor configuration.
+const (
+	// EnableKdump is a sandbox annotation to enable guest kernel crash dumps
+	// through kdump. Only "true" and "false" are accepted.
+	EnableKdump = kataAnnotHypervisorPrefix + "enable_kdump"
+
+	// KdumpCrashKernelSize is a sandbox annotation for passing the amount of
+	// guest memory reserved for the crash kernel when kdump is enabled,
+	// e.g. "256M". It is passed to the guest kernel as "crashkernel=".
+	KdumpCrashKernelSize = kataAnnotHypervisorPrefix + "kdump_crashkernel_size"
+
+	// DefaultMemory is a sandbox annotation for the guest memory size in MiB.
+	DefaultMemory = kataAnnotHypervisorPrefix + "default_memory"
+
+	// DefaultVCPUs is a sandbox annotation for the number of guest vCPUs.
+	DefaultVCPUs = kataAnnotHypervisorPrefix + "default_vcpus"
+
+	// BlockDeviceCacheMode is a sandbox annotation to set the cache mode of
+	// the guest block devices: "writeback", "writethrough" or "none".
+	BlockDeviceCacheMode = kataAnnotHypervisorPrefix + "block_device_cache_mode"
+
+	// VirtioFSQueueSize is a sandbox annotation for passing the size of the
+	// virtio-fs request queues. It only applies to the virtio-fs shared
+	// file system.
+	VirtioFSQueueSize = kataAnnotHypervisorPrefix + "virtio_fs_queue_size"
+
+	// EnableNestedVirt is a sandbox annotation to expose the host
+	// virtualization extensions to the guest. Only "true" and "false" are
+	// accepted.
+	EnableNestedVirt = kataAnnotHypervisorPrefix + "enable_nested_virt"
+
+	// EnableWatchdog is a sandbox annotation to add a watchdog device to the
+	// guest, resetting it when it hangs. Only "true" and "false" are
+	// accepted. The watchdog is only supported by QEMU, and not on arm64
+	// and s390x.
+	EnableWatchdog = kataAnnotHypervisorPrefix + "enable_watchdog"
+
+	// WatchdogTimeout is a sandbox annotation for passing the number of
+	// seconds after which the guest watchdog resets an unresponsive guest.
+	WatchdogTimeout = kataAnnotHypervisorPrefix + "watchdog_timeout"
+
+	// ConfidentialGuest is a sandbox annotation flagging the guest as
+	// running with a confidential computing technology, such as AMD SEV or
+	// Intel TDX. Only "true" and "false" are accepted.
+	ConfidentialGuest = kataAnnotHypervisorPrefix + "confidential_guest"
+
+	// EntropyBootWait is a sandbox annotation to have the agent wait for the
+	// guest entropy pool to be filled before starting the containers. The
+	// agent doesn't support it yet, so only "false" is accepted, "true" being
+	// rejected.
+	EntropyBootWait = kataAnnotHypervisorPrefix + "entropy_boot_wait"
+
+	// HugepageNUMANode is a sandbox annotation for passing the host NUMA
+	// node the huge pages backing the guest memory are allocated from. It
+	// requires huge pages and sandbox_cgroup_only to be enabled.
+	HugepageNUMANode = kataAnnotHypervisorPrefix + "hugepage_numa_node"
+)
+
+// Annotations related to the runtime configuration.
+const (
+	// ExposeKVM is a sandbox annotation to make /dev/kvm available to the
+	// containers, for nested KVM workloads. It requires nested
+	// virtualization to be enabled. Only "true" and "false" are accepted.
+	ExposeKVM = kataAnnotRuntimePrefix + "expose_kvm"
+
+	// TransparentHugepage is a sandbox annotation to set the guest
+	// transparent hugepage policy: "always", "madvise" or "never".
+	TransparentHugepage = kataAnnotRuntimePrefix + "thp"
+
+	// IOScheduler is a sandbox annotation for passing the I/O scheduler of
+	// the guest block devices. It is rejected as long as the agent has no
+	// request to set it.
+	IOScheduler = kataAnnotRuntimePrefix + "io_scheduler"
+
+	// PanicOnOOM is a sandbox annotation to make the guest kernel panic,
+	// rather than kill a process, when running out of memory. Only "true"
+	// and "false" are accepted.
+	PanicOnOOM = kataAnnotRuntimePrefix + "panic_on_oom"
+
+	// QoSClass is a container annotation for passing the Kubernetes QoS
+	// class of the pod: "Guaranteed", "Burstable" or "BestEffort".
+	QoSClass = kataAnnotRuntimePrefix + "qos_class"
+
+	// DefaultRouteMetric is a sandbox annotation for passing the metric of
+	// the guest default routes. It is rejected as long as the routes passed
+	// to the agent carry no metric.
+	DefaultRouteMetric = kataAnnotRuntimePrefix + "default_route_metric"
+
+	// DisableDevpts is a container annotation to omit the devpts mount of
+	// the container. Interactive containers still get a console, as their
+	// terminal is allocated by the agent. Only "true" and "false" are
+	// accepted.
+	DisableDevpts = kataAnnotRuntimePrefix + "disable_devpts"
+
+	// TCPRmem is a sandbox annotation for passing the guest TCP receive
+	// buffer sizes, as a "min default max" triplet of bytes.
+	TCPRmem = kataAnnotRuntimePrefix + "tcp_rmem"
+
+	// TCPWmem is a sandbox annotation for passing the guest TCP send
+	// buffer sizes, as a "min default max" triplet of bytes.
+	TCPWmem = kataAnnotRuntimePrefix + "tcp_wmem"
+
+	// SchedPolicy is a container annotation for passing the scheduling
+	// policy of the container process. The agent can't set it yet, so only
+	// the default "other" policy is accepted, "fifo" and "rr" being
+	// rejected.
+	SchedPolicy = kataAnnotRuntimePrefix + "sched_policy"
+
+	// SchedPriority is a container annotation for passing the static
+	// scheduling priority of the container process, which can only be 0
+	// with the "other" policy.
+	SchedPriority = kataAnnotRuntimePrefix + "sched_priority"
+
+	// Hostname is a sandbox annotation for passing the guest hostname,
+	// overriding the hostname of the OCI spec. An empty value is ignored.
+	Hostname = kataAnnotRuntimePrefix + "hostname"
+
+	// PidMax is a sandbox annotation for passing the maximum PID value of
+	// the guest, applied through the kernel.pid_max sysctl.
+	PidMax = kataAnnotRuntimePrefix + "pid_max"
+
+	// NfConntrackMax is a sandbox annotation for passing the size of the
+	// guest connection tracking table, applied through the
+	// net.netfilter.nf_conntrack_max sysctl.
+	NfConntrackMax = kataAnnotRuntimePrefix + "nf_conntrack_max"
+
+	// VMSwappiness is a sandbox annotation for passing the guest-wide
+	// swappiness, between 0 and 100, applied through the vm.swappiness
+	// sysctl. The swappiness of a container memory cgroup, set from
+	// linux.resources.memory.swappiness, takes precedence over it for the
+	// processes of that container.
+	VMSwappiness = kataAnnotRuntimePrefix + "vm_swappiness"
+
+	// DNSConfigured is a container annotation telling the container
+	// resolves names without a /etc/resolv.conf mount, for instance with a
+	// resolv.conf shipped in its image. Only "true" and "false" are
+	// accepted.
+	DNSConfigured = kataAnnotRuntimePrefix + "dns_configured"
+
+	// CPUAffinity is a container annotation for passing the host CPUs the
+	// container is pinned to, as a comma separated list of CPUs and CPU
+	// ranges, e.g. "0-3,7". The vCPU threads of the hypervisor are pinned
+	// to the CPUs of all the containers of the sandbox.
+	CPUAffinity = kataAnnotRuntimePrefix + "cpu_affinity"
+
+	// RootfsReadonly is a container annotation overriding the readonly
+	// setting of the container rootfs set by the OCI spec. Only "true" and
+	// "false" are accepted.
+	RootfsReadonly = kataAnnotRuntimePrefix + "rootfs_readonly"
+)
+
+// Annotations related to the agent configuration.
+const (
+	// EnableMetrics is a sandbox annotation to enable the guest metrics
+	// collection endpoint of the agent. The agent doesn't provide one yet,
+	// so only "false" is accepted, "true" being rejected.
+	EnableMetrics = kataAnnotAgentPrefix + "enable_metrics"
+
+	// MetricsPort is a sandbox annotation for passing the port the guest
+	// metrics collection endpoint listens on. It is rejected as long as the
+	// agent doesn't provide the endpoint.
+	MetricsPort = kataAnnotAgentPrefix + "metrics_port"
+
+	// InitArgs is a sandbox annotation for passing the space separated
+	// arguments of the guest init.
+	InitArgs = kataAnnotAgentPrefix + "init_args"
 )
 
 const (
@@ -10,11 +10,15 @@ import (
 	"fmt"
 	"io/ioutil"
 	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
 
 	specs "github.com/opencontainers/runtime-spec/specs-go"
 	"github.com/sirupsen/logrus"
 
 	vcAnnotations "github.com/kata-containers/runtime/virtcontainers/pkg/annotations"
+	"github.com/kata-containers/runtime/virtcontainers/utils"
 )
 
 var ociLog = logrus.WithFields(logrus.Fields{
@@ -140,6 +144,268 @@ func ParseConfigJSON(bundlePath string) (specs.Spec, error) {
 	return compSpec.Spec, nil
 }
 
+// Hooks describes the OCI hooks of every phase. On top of the legacy
+// phases known by specs.Hooks, it carries the createRuntime,
+// createContainer and startContainer phases introduced with the
+// runtime-spec v1.0.2, which katautils runs.
+// The container namespaces live inside the VM, where the host binaries
+// of the hooks cannot be run, hence all the hooks are run on the host,
+// in the runtime namespaces.
+type Hooks struct {
+	// Prestart hooks are run during the create operation. They are
+	// deprecated in favour of the CreateRuntime, CreateContainer and
+	// StartContainer hooks.
+	Prestart []specs.Hook `json:"prestart,omitempty"`
+
+	// CreateRuntime hooks are run during the create operation, right
+	// after the prestart hooks.
+	CreateRuntime []specs.Hook `json:"createRuntime,omitempty"`
+
+	// CreateContainer hooks are expected to run in the container
+	// namespaces, once the container mounts are set up and before
+	// pivot_root. They are run right after the CreateRuntime hooks.
+	CreateContainer []specs.Hook `json:"createContainer,omitempty"`
+
+	// StartContainer hooks are expected to run in the container
+	// namespaces, during the start operation right before the container
+	// process is executed. They are run before the container is started.
+	StartContainer []specs.Hook `json:"startContainer,omitempty"`
+
+	// Poststart hooks are run after the container process is started.
+	Poststart []specs.Hook `json:"poststart,omitempty"`
+
+	// Poststop hooks are run after the container is deleted.
+	Poststop []specs.Hook `json:"poststop,omitempty"`
+}
+
+// ParseHooks unmarshals the hooks of every phase from the content of a
+// config.json file.
+func ParseHooks(configByte []byte) (Hooks, error) {
+	var spec struct {
+		Hooks *Hooks `json:"hooks,omitempty"`
+	}
+
+	if err := json.Unmarshal(configByte, &spec); err != nil {
+		return Hooks{}, err
+	}
+
+	if spec.Hooks == nil {
+		return Hooks{}, nil
+	}
+
+	return *spec.Hooks, nil
+}
+
+// ParseConfigJSONHooks unmarshals the hooks of every phase from the
+// config.json file.
+func ParseConfigJSONHooks(bundlePath string) (Hooks, error) {
+	configByte, err := ioutil.ReadFile(getConfigPath(bundlePath))
+	if err != nil {
+		return Hooks{}, err
+	}
+
+	return ParseHooks(configByte)
+}
+
+// parseUnifiedResources unmarshals the cgroup v2 unified resources, the
+// linux.resources.unified map of the runtime-spec, from the content of a
+// config.json file.
+func parseUnifiedResources(configByte []byte) (map[string]string, error) {
+	var spec struct {
+		Linux *struct {
+			Resources *struct {
+				Unified map[string]string `json:"unified,omitempty"`
+			} `json:"resources,omitempty"`
+		} `json:"linux,omitempty"`
+	}
+
+	if err := json.Unmarshal(configByte, &spec); err != nil {
+		return nil, err
+	}
+
+	if spec.Linux == nil || spec.Linux.Resources == nil {
+		return nil, nil
+	}
+
+	return spec.Linux.Resources.Unified, nil
+}
+
+// ParseConfigJSONUnifiedResources unmarshals the cgroup v2 unified resources
+// from the config.json file. The vendored runtime-spec predates them, so
+// ParseConfigJSON drops them.
+func ParseConfigJSONUnifiedResources(bundlePath string) (map[string]string, error) {
+	configByte, err := ioutil.ReadFile(getConfigPath(bundlePath))
+	if err != nil {
+		return nil, err
+	}
+
+	return parseUnifiedResources(configByte)
+}
+
+// parseUnifiedLimit parses a unified resource limit, "max" meaning no limit
+// and being returned as -1.
+func parseUnifiedLimit(key, value string) (int64, error) {
+	if value == "max" {
+		return -1, nil
+	}
+
+	limit, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || limit < 0 {
+		return 0, fmt.Errorf("Invalid value %q for unified resource %s: expecting a number of bytes or \"max\"", value, key)
+	}
+
+	return limit, nil
+}
+
+// ApplyUnifiedResources translates the cgroup v2 unified resources into the
+// cgroup v1 resources of the spec, which are the only ones the agent
+// understands. The unified resources are preferred over the
+// conflicting cgroup v1 resources, with a warning. The unified resources
+// without cgroup v1 equivalent are ignored, with a warning too.
+func ApplyUnifiedResources(spec *specs.Spec, unified map[string]string) error {
+	if len(unified) == 0 {
+		return nil
+	}
+
+	if spec.Linux == nil {
+		spec.Linux = &specs.Linux{}
+	}
+	if spec.Linux.Resources == nil {
+		spec.Linux.Resources = &specs.LinuxResources{}
+	}
+	r := spec.Linux.Resources
+
+	keys := make([]string, 0, len(unified))
+	for key := range unified {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	warnOverride := func(key string) {
+		ociLog.WithField("unified", key).Warn("Overriding the cgroup v1 resources with the unified resources")
+	}
+
+	memory := func() *specs.LinuxMemory {
+		if r.Memory == nil {
+			r.Memory = &specs.LinuxMemory{}
+		}
+		return r.Memory
+	}
+
+	cpu := func() *specs.LinuxCPU {
+		if r.CPU == nil {
+			r.CPU = &specs.LinuxCPU{}
+		}
+		return r.CPU
+	}
+
+	var swap *int64
+	for _, key := range keys {
+		value := strings.TrimSpace(unified[key])
+
+		switch key {
+		case "memory.max", "memory.low", "memory.swap.max":
+			limit, err := parseUnifiedLimit(key, value)
+			if err != nil {
+				return err
+			}
+
+			switch key {
+			case "memory.max":
+				if l := memory().Limit; l != nil && *l != limit {
+					warnOverride(key)
+				}
+				memory().Limit = &limit
+			case "memory.low":
+				if l := memory().Reservation; l != nil && *l != limit {
+					warnOverride(key)
+				}
+				memory().Reservation = &limit
+			default:
+				swap = &limit
+			}
+		case "cpu.weight":
+			weight, err := strconv.ParseUint(value, 10, 64)
+			if err != nil || weight < 1 || weight > 10000 {
+				return fmt.Errorf("Invalid value %q for unified resource %s: expecting a weight between 1 and 10000", value, key)
+			}
+
+			if s := cpu().Shares; s != nil && utils.CPUSharesToWeight(*s) != weight {
+				warnOverride(key)
+			}
+			shares := utils.CPUWeightToShares(weight)
+			cpu().Shares = &shares
+		case "cpu.max":
+			fields := strings.Fields(value)
+			if len(fields) == 0 || len(fields) > 2 {
+				return fmt.Errorf("Invalid value %q for unified resource %s: expecting \"$MAX [$PERIOD]\"", value, key)
+			}
+
+			quota, err := parseUnifiedLimit(key, fields[0])
+			if err != nil {
+				return err
+			}
+
+			var period *uint64
+			if len(fields) == 2 {
+				p, err := strconv.ParseUint(fields[1], 10, 64)
+				if err != nil || p == 0 {
+					return fmt.Errorf("Invalid value %q for unified resource %s: expecting a non-zero period", value, key)
+				}
+				period = &p
+			}
+
+			if (cpu().Quota != nil && *cpu().Quota != quota) ||
+				(period != nil && cpu().Period != nil && *cpu().Period != *period) {
+				warnOverride(key)
+			}
+			cpu().Quota = &quota
+			if period != nil {
+				cpu().Period = period
+			}
+		case "cpuset.cpus":
+			if cpu().Cpus != "" && cpu().Cpus != value {
+				warnOverride(key)
+			}
+			cpu().Cpus = value
+		case "cpuset.mems":
+			if cpu().Mems != "" && cpu().Mems != value {
+				warnOverride(key)
+			}
+			cpu().Mems = value
+		case "pids.max":
+			limit, err := parseUnifiedLimit(key, value)
+			if err != nil {
+				return err
+			}
+
+			if r.Pids != nil && r.Pids.Limit != limit {
+				warnOverride(key)
+			}
+			r.Pids = &specs.LinuxPids{Limit: limit}
+		default:
+			ociLog.WithField("unified", key).Warn("Ignoring unified resource without cgroup v1 equivalent")
+		}
+	}
+
+	// The cgroup v1 swap limit covers the memory and the swap, while the
+	// cgroup v2 one only covers the swap.
+	if swap != nil {
+		limit := memory().Limit
+		total := int64(-1)
+		if *swap >= 0 && limit != nil && *limit >= 0 {
+			total = *limit + *swap
+		}
+
+		if s := memory().Swap; s != nil && *s != total {
+			warnOverride("memory.swap.max")
+		}
+		memory().Swap = &total
+	}
+
+	return nil
+}
+
 func GetContainerSpec(annotations map[string]string) (specs.Spec, error) {
 	if bundlePath, ok := annotations[vcAnnotations.BundlePathKey]; ok {
 		return ParseConfigJSON(bundlePath)
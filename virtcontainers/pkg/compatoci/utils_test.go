@@ -7,6 +7,8 @@ package compatoci
 
 import (
 	"encoding/json"
+	"io/ioutil"
+	"os"
 	"path/filepath"
 	"testing"
 
@@ -56,6 +58,35 @@ const (
 		        }
 		    }
 		}`
+
+	hooksSpec = `
+		{
+		    "ociVersion": "1.0.2",
+		    "hooks": {
+		        "prestart": [
+		            {
+		                "path": "/usr/bin/prestart"
+		            }
+		        ],
+		        "createRuntime": [
+		            {
+		                "path": "/usr/bin/setup-network",
+		                "args": ["setup-network", "--bridge"],
+		                "timeout": 5
+		            }
+		        ],
+		        "createContainer": [
+		            {
+		                "path": "/usr/bin/create-container"
+		            }
+		        ],
+		        "poststop": [
+		            {
+		                "path": "/usr/bin/cleanup"
+		            }
+		        ]
+		    }
+		}`
 )
 
 func TestContainerCapabilities(t *testing.T) {
@@ -145,3 +176,181 @@ func TestGetConfigPath(t *testing.T) {
 	configPath := getConfigPath(tempBundlePath)
 	assert.Equal(t, configPath, expected)
 }
+
+func TestParseHooks(t *testing.T) {
+	assert := assert.New(t)
+
+	hooks, err := ParseHooks([]byte(hooksSpec))
+	assert.NoError(err)
+
+	timeout := 5
+	assert.Equal([]specs.Hook{
+		{
+			Path:    "/usr/bin/setup-network",
+			Args:    []string{"setup-network", "--bridge"},
+			Timeout: &timeout,
+		},
+	}, hooks.CreateRuntime)
+	assert.Equal([]specs.Hook{{Path: "/usr/bin/create-container"}}, hooks.CreateContainer)
+	assert.Equal([]specs.Hook{{Path: "/usr/bin/prestart"}}, hooks.Prestart)
+	assert.Equal([]specs.Hook{{Path: "/usr/bin/cleanup"}}, hooks.Poststop)
+	assert.Empty(hooks.StartContainer)
+	assert.Empty(hooks.Poststart)
+
+	hooks, err = ParseHooks([]byte(capabilitiesSpecStruct))
+	assert.NoError(err)
+	assert.Equal(Hooks{}, hooks)
+
+	_, err = ParseHooks([]byte("{"))
+	assert.Error(err)
+}
+
+func TestParseConfigJSONHooks(t *testing.T) {
+	assert := assert.New(t)
+
+	bundlePath, err := ioutil.TempDir("", "compatoci-hooks")
+	assert.NoError(err)
+	defer os.RemoveAll(bundlePath)
+
+	_, err = ParseConfigJSONHooks(bundlePath)
+	assert.Error(err)
+
+	err = ioutil.WriteFile(getConfigPath(bundlePath), []byte(hooksSpec), 0644)
+	assert.NoError(err)
+
+	hooks, err := ParseConfigJSONHooks(bundlePath)
+	assert.NoError(err)
+	assert.Len(hooks.CreateRuntime, 1)
+}
+
+func TestParseUnifiedResources(t *testing.T) {
+	assert := assert.New(t)
+
+	unified, err := parseUnifiedResources([]byte(`
+		{
+		    "ociVersion": "1.0.2",
+		    "linux": {
+		        "resources": {
+		            "unified": {
+		                "memory.max": "1073741824",
+		                "cpu.weight": "100"
+		            }
+		        }
+		    }
+		}`))
+	assert.NoError(err)
+	assert.Equal(map[string]string{"memory.max": "1073741824", "cpu.weight": "100"}, unified)
+
+	unified, err = parseUnifiedResources([]byte(capabilitiesSpecStruct))
+	assert.NoError(err)
+	assert.Nil(unified)
+
+	_, err = parseUnifiedResources([]byte("{"))
+	assert.Error(err)
+}
+
+func TestApplyUnifiedResources(t *testing.T) {
+	assert := assert.New(t)
+
+	// no unified resources, the spec is untouched
+	spec := specs.Spec{}
+	assert.NoError(ApplyUnifiedResources(&spec, nil))
+	assert.Nil(spec.Linux)
+
+	limit := int64(512 << 20)
+	shares := uint64(1024)
+	spec.Linux = &specs.Linux{
+		Resources: &specs.LinuxResources{
+			Memory: &specs.LinuxMemory{Limit: &limit},
+			CPU:    &specs.LinuxCPU{Shares: &shares},
+		},
+	}
+
+	err := ApplyUnifiedResources(&spec, map[string]string{
+		"memory.max":      "1073741824",
+		"memory.low":      "268435456",
+		"memory.swap.max": "536870912",
+		"cpu.weight":      "100",
+		"cpu.max":         "50000 100000",
+		"cpuset.cpus":     "0-1",
+		"pids.max":        "max",
+		"io.weight":       "100",
+	})
+	assert.NoError(err)
+
+	r := spec.Linux.Resources
+	assert.Equal(int64(1<<30), *r.Memory.Limit)
+	assert.Equal(int64(256<<20), *r.Memory.Reservation)
+	assert.Equal(int64(1<<30+512<<20), *r.Memory.Swap)
+	assert.Equal(uint64(2598), *r.CPU.Shares)
+	assert.Equal(int64(50000), *r.CPU.Quota)
+	assert.Equal(uint64(100000), *r.CPU.Period)
+	assert.Equal("0-1", r.CPU.Cpus)
+	assert.Equal(int64(-1), r.Pids.Limit)
+	assert.Nil(r.BlockIO)
+
+	// no limits
+	spec = specs.Spec{}
+	err = ApplyUnifiedResources(&spec, map[string]string{
+		"memory.max":      "max",
+		"memory.swap.max": "max",
+		"cpu.max":         "max",
+	})
+	assert.NoError(err)
+	r = spec.Linux.Resources
+	assert.Equal(int64(-1), *r.Memory.Limit)
+	assert.Equal(int64(-1), *r.Memory.Swap)
+	assert.Equal(int64(-1), *r.CPU.Quota)
+	assert.Nil(r.CPU.Period)
+
+	for key, value := range map[string]string{
+		"memory.max": "1G",
+		"memory.low": "-1",
+		"cpu.weight": "0",
+		"cpu.max":    "50000 0",
+		"pids.max":   "many",
+	} {
+		spec = specs.Spec{}
+		err = ApplyUnifiedResources(&spec, map[string]string{key: value})
+		assert.Error(err, "%s: %q", key, value)
+	}
+}
+
+func TestParseConfigJSONUnifiedResources(t *testing.T) {
+	assert := assert.New(t)
+
+	bundlePath, err := ioutil.TempDir("", "compatoci-unified")
+	assert.NoError(err)
+	defer os.RemoveAll(bundlePath)
+
+	err = ioutil.WriteFile(getConfigPath(bundlePath), []byte(`
+		{
+		    "ociVersion": "1.0.2",
+		    "process": {
+		        "args": ["sh"]
+		    },
+		    "linux": {
+		        "resources": {
+		            "memory": {
+		                "limit": 536870912
+		            },
+		            "unified": {
+		                "memory.max": "1073741824"
+		            }
+		        }
+		    }
+		}`), 0644)
+	assert.NoError(err)
+
+	// the unified resources are left to the caller
+	spec, err := ParseConfigJSON(bundlePath)
+	assert.NoError(err)
+	assert.Equal(int64(512<<20), *spec.Linux.Resources.Memory.Limit)
+
+	unified, err := ParseConfigJSONUnifiedResources(bundlePath)
+	assert.NoError(err)
+	assert.Equal(map[string]string{"memory.max": "1073741824"}, unified)
+
+	_, err = ParseConfigJSONUnifiedResources(filepath.Join(bundlePath, "missing"))
+	assert.True(os.IsNotExist(err))
+}
@@ -6,16 +6,24 @@
 package oci
 
 import (
+	"bufio"
 	"context"
 	"errors"
 	"fmt"
+	"math"
+	"os"
 	"path/filepath"
+	"regexp"
+	goruntime "runtime"
+	"sort"
 	"strconv"
 	"strings"
 	"syscall"
+	"unicode"
 
 	criContainerdAnnotations "github.com/containerd/cri-containerd/pkg/annotations"
 	crioAnnotations "github.com/cri-o/cri-o/pkg/annotations"
+	merr "github.com/hashicorp/go-multierror"
 	specs "github.com/opencontainers/runtime-spec/specs-go"
 	"github.com/sirupsen/logrus"
 
@@ -24,9 +32,15 @@ import (
 	exp "github.com/kata-containers/runtime/virtcontainers/experimental"
 	vcAnnotations "github.com/kata-containers/runtime/virtcontainers/pkg/annotations"
 	dockershimAnnotations "github.com/kata-containers/runtime/virtcontainers/pkg/annotations/dockershim"
+	"github.com/kata-containers/runtime/virtcontainers/pkg/compatoci"
 	"github.com/kata-containers/runtime/virtcontainers/types"
+	vcUtils "github.com/kata-containers/runtime/virtcontainers/utils"
 )
 
+// criContainerdImageName is the containerd CRI plugin image name annotation,
+// which is not defined by the vendored cri-containerd package.
+const criContainerdImageName = "io.kubernetes.cri.image-name"
+
 type annotationContainerType struct {
 	annotation    string
 	containerType vc.ContainerType
@@ -37,13 +51,18 @@ var (
 	ErrNoLinux = errors.New("missing Linux section")
 
 	// CRIContainerTypeKeyList lists all the CRI keys that could define
-	// the container type from annotations in the config.json.
-	CRIContainerTypeKeyList = []string{criContainerdAnnotations.ContainerType, crioAnnotations.ContainerType, dockershimAnnotations.ContainerTypeLabelKey}
+	// the container type from annotations in the config.json, by decreasing
+	// precedence.
+	CRIContainerTypeKeyList = []string{crioAnnotations.ContainerType, criContainerdAnnotations.ContainerType, dockershimAnnotations.ContainerTypeLabelKey}
 
 	// CRISandboxNameKeyList lists all the CRI keys that could define
 	// the sandbox ID (sandbox ID) from annotations in the config.json.
 	CRISandboxNameKeyList = []string{criContainerdAnnotations.SandboxID, crioAnnotations.SandboxID, dockershimAnnotations.SandboxIDLabelKey}
 
+	// CRIImageNameKeyList lists all the CRI keys that could define the
+	// container image reference from annotations in the config.json.
+	CRIImageNameKeyList = []string{crioAnnotations.ImageName, criContainerdImageName, crioAnnotations.ImageRef}
+
 	// CRIContainerTypeList lists all the maps from CRI ContainerTypes annotations
 	// to a virtcontainers ContainerType.
 	CRIContainerTypeList = []annotationContainerType{
@@ -73,6 +92,91 @@ const (
 
 const KernelModulesSeparator = ";"
 
+// MountSubPathsSeparator separates the entries of the MountSubPaths
+// annotation.
+const MountSubPathsSeparator = ";"
+
+const (
+	// QoSGuaranteed is the Kubernetes QoS class of the containers which
+	// request as much CPU and memory as their limits.
+	QoSGuaranteed = "Guaranteed"
+
+	// QoSBurstable is the Kubernetes QoS class of the containers which
+	// request some resources without being guaranteed.
+	QoSBurstable = "Burstable"
+
+	// QoSBestEffort is the Kubernetes QoS class of the containers without
+	// any resource request or limit.
+	QoSBestEffort = "BestEffort"
+)
+
+// minCPUShares is the CPU shares value used by Kubernetes for the containers
+// which do not request any CPU.
+const minCPUShares = 2
+
+// minOOMScoreAdj and maxOOMScoreAdj are the bounds of the OOM score
+// adjustment of a process.
+const (
+	minOOMScoreAdj = -1000
+	maxOOMScoreAdj = 1000
+)
+
+// DefaultShmMemoryFraction is the default maximum fraction of the guest
+// memory the shm can use.
+const DefaultShmMemoryFraction = 0.5
+
+// DefaultKdumpCrashKernelSize is the amount of guest memory reserved for the
+// crash kernel when kdump is enabled without an explicit reservation size.
+const DefaultKdumpCrashKernelSize = "256M"
+
+// transparentHugepagePolicies lists the supported guest transparent
+// hugepage policies.
+var transparentHugepagePolicies = []string{"always", "madvise", "never"}
+
+// maxNfConntrackMax bounds the net.netfilter.nf_conntrack_max sysctl, which
+// the kernel parses as a signed integer.
+const maxNfConntrackMax = math.MaxInt32
+
+// minPidMax and maxPidMax are the bounds of the kernel.pid_max sysctl on a
+// 64-bit guest kernel.
+const (
+	minPidMax = 301
+	maxPidMax = 4194304
+)
+
+// maxVMSwappiness is the highest value of the vm.swappiness sysctl.
+const maxVMSwappiness = 100
+
+// numaNodePath is the sysfs directory of a host NUMA node.
+var numaNodePath = "/sys/devices/system/node/node%d"
+
+// maxVirtioFSQueueSize is the maximum size of a virtio queue.
+const maxVirtioFSQueueSize = 1024
+
+// DefaultRlimitNofile is the RLIMIT_NOFILE applied to the container
+// process when the OCI spec does not set one. This is the value of the spec
+// generated by "runc spec": runc otherwise lets the container inherit its own
+// limit, while the limit of the agent in the guest is unrelated to the host.
+var DefaultRlimitNofile = specs.POSIXRlimit{
+	Type: "RLIMIT_NOFILE",
+	Soft: 1024,
+	Hard: 1024,
+}
+
+// DefaultPATH is the PATH set for the container process when the OCI spec
+// environment does not define one, as done by the usual container engines.
+const DefaultPATH = "/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"
+
+// maxWatchdogTimeout is the maximum heartbeat, in seconds, supported by the
+// i6300esb watchdog guest driver.
+const maxWatchdogTimeout = 2046
+
+const (
+	kvmDevicePath  = "/dev/kvm"
+	kvmDeviceMajor = 10
+	kvmDeviceMinor = 232
+)
+
 // FactoryConfig is a structure to set the VM factory configuration.
 type FactoryConfig struct {
 	// Template enables VM templating support in VM factory.
@@ -124,6 +228,23 @@ type RuntimeConfig struct {
 
 	//Experimental features enabled
 	Experimental []exp.Feature
+
+	//Determines the maximum fraction of the guest memory the shm can use,
+	//DefaultShmMemoryFraction being used when unset
+	ShmMemoryFraction float64
+
+	//Determines if the capabilities of the CRI sandbox container are
+	//dropped, whatever the OCI spec requests
+	DropSandboxCapabilities bool
+
+	//Determines if a warning is logged for every OCI spec field which is
+	//not supported and ignored
+	WarnDroppedFields bool
+
+	//Determines if unknown capability names in the OCI spec of the sandbox
+	//are rejected rather than only logged. The ones of the containers
+	//added to a running sandbox are always only logged
+	StrictCapabilities bool
 }
 
 // AddKernelParam allows the addition of new kernel parameters to an existing
@@ -132,6 +253,65 @@ func (config *RuntimeConfig) AddKernelParam(p vc.Param) error {
 	return config.HypervisorConfig.AddKernelParam(p)
 }
 
+// AddKernelParams adds a batch of kernel parameters to the hypervisor
+// configuration stored inside the current runtime configuration. The whole
+// batch is validated first: when any parameter is invalid, none is added and
+// the returned error names every invalid parameter by its index.
+func (config *RuntimeConfig) AddKernelParams(params []vc.Param) error {
+	var result *merr.Error
+	for i, p := range params {
+		if p.Key == "" {
+			result = merr.Append(result, fmt.Errorf("kernel parameter %d: empty key for value %q", i, p.Value))
+		}
+	}
+
+	if err := result.ErrorOrNil(); err != nil {
+		return err
+	}
+
+	for _, p := range params {
+		if err := config.HypervisorConfig.AddKernelParam(p); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// DiffKernelParams compares two lists of kernel parameters by key. It returns
+// the parameters of newParams whose key is not in oldParams, the parameters
+// of oldParams whose key is not in newParams, and the parameters of newParams
+// whose value differs from the one in oldParams. As most kernel parameters
+// only apply at boot time, any difference means the VM needs to be restarted.
+func DiffKernelParams(oldParams, newParams []vc.Param) (added, removed, changed []vc.Param) {
+	oldValues := make(map[string]string)
+	for _, p := range oldParams {
+		oldValues[p.Key] = p.Value
+	}
+
+	newValues := make(map[string]string)
+	for _, p := range newParams {
+		newValues[p.Key] = p.Value
+	}
+
+	for _, p := range newParams {
+		oldValue, ok := oldValues[p.Key]
+		if !ok {
+			added = append(added, p)
+		} else if oldValue != p.Value {
+			changed = append(changed, p)
+		}
+	}
+
+	for _, p := range oldParams {
+		if _, ok := newValues[p.Key]; !ok {
+			removed = append(removed, p)
+		}
+	}
+
+	return added, removed, changed
+}
+
 var ociLog = logrus.WithFields(logrus.Fields{
 	"source":    "virtcontainers",
 	"subsystem": "oci",
@@ -160,28 +340,322 @@ func cmdEnvs(spec specs.Spec, envs []types.EnvVar) []types.EnvVar {
 	return envs
 }
 
+func isBindMount(m specs.Mount) bool {
+	return m.Type == "bind" || contains(m.Options, "bind") || contains(m.Options, "rbind")
+}
+
+// bindMountOptions returns whether a bind mount is read-only, and its
+// options in canonical form. The last "ro" or "rw" option wins, and a
+// read-only bind mount gets its "bind" or "rbind" option first and a single
+// "ro" option last, so that the agent reliably remounts it read-only after
+// binding it.
+func bindMountOptions(options []string) (bool, []string) {
+	readonly := false
+	for _, o := range options {
+		switch o {
+		case "ro":
+			readonly = true
+		case "rw":
+			readonly = false
+		}
+	}
+
+	if !readonly {
+		return false, options
+	}
+
+	var bindOpts, otherOpts []string
+	for _, o := range options {
+		switch o {
+		case "bind", "rbind":
+			bindOpts = append(bindOpts, o)
+		case "ro", "rw":
+		default:
+			otherOpts = append(otherOpts, o)
+		}
+	}
+
+	canonical := append(bindOpts, otherOpts...)
+	canonical = append(canonical, "ro")
+
+	return true, canonical
+}
+
 func newMount(m specs.Mount) vc.Mount {
-	return vc.Mount{
+	mnt := vc.Mount{
 		Source:      m.Source,
 		Destination: m.Destination,
 		Type:        m.Type,
 		Options:     m.Options,
 	}
+
+	if isBindMount(m) {
+		mnt.ReadOnly, mnt.Options = bindMountOptions(m.Options)
+	}
+
+	// Sizes relative to the memory, like "size=50%", and invalid sizes,
+	// which the guest rejects when mounting, are left unset.
+	if m.Type == "tmpfs" {
+		for _, o := range m.Options {
+			value := strings.TrimPrefix(o, "size=")
+			if value == o {
+				continue
+			}
+
+			mnt.SizeBytes = 0
+			if strings.HasSuffix(value, "%") {
+				continue
+			}
+
+			size, err := vcUtils.ParseMemorySize(value)
+			if err != nil {
+				ociLog.WithError(err).WithField("destination", m.Destination).Warnf("Invalid %s tmpfs option", o)
+				continue
+			}
+			mnt.SizeBytes = size
+		}
+	}
+
+	return mnt
+}
+
+// DroppedFields returns the OCI spec fields which are set but not supported,
+// and thus ignored. The fields are named after their JSON path in the spec,
+// such as "linux.intelRdt".
+func DroppedFields(spec specs.Spec) []string {
+	var dropped []string
+
+	if spec.Solaris != nil {
+		dropped = append(dropped, "solaris")
+	}
+	if spec.Windows != nil {
+		dropped = append(dropped, "windows")
+	}
+	if spec.VM != nil {
+		dropped = append(dropped, "vm")
+	}
+
+	if spec.Linux == nil {
+		return dropped
+	}
+
+	if spec.Linux.IntelRdt != nil {
+		dropped = append(dropped, "linux.intelRdt")
+	}
+
+	// The cgroup namespace is not created in the guest.
+	for _, n := range spec.Linux.Namespaces {
+		if n.Type == specs.CgroupNamespace {
+			dropped = append(dropped, "linux.namespaces.cgroup")
+		}
+	}
+
+	// These resources are not applied in the guest.
+	if r := spec.Linux.Resources; r != nil {
+		if r.Pids != nil {
+			dropped = append(dropped, "linux.resources.pids")
+		}
+		if r.BlockIO != nil {
+			dropped = append(dropped, "linux.resources.blockIO")
+		}
+		if len(r.HugepageLimits) > 0 {
+			dropped = append(dropped, "linux.resources.hugepageLimits")
+		}
+		if r.Network != nil {
+			dropped = append(dropped, "linux.resources.network")
+		}
+	}
+
+	return dropped
+}
+
+// validateMountOwnership checks the mode, UID and GID options of a tmpfs or
+// devpts mount, which the guest would otherwise only reject when mounting it.
+// The mode must be octal.
+func validateMountOwnership(m specs.Mount) error {
+	if m.Type != "tmpfs" && m.Type != "devpts" {
+		return nil
+	}
+
+	for _, o := range m.Options {
+		kv := strings.SplitN(o, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+
+		switch kv[0] {
+		case "mode":
+			mode, err := strconv.ParseUint(kv[1], 8, 32)
+			if err != nil || mode > 07777 {
+				return fmt.Errorf("Invalid mount option %q: expecting an octal mode", o)
+			}
+		case "uid", "gid":
+			if _, err := strconv.ParseUint(kv[1], 10, 32); err != nil {
+				return fmt.Errorf("Invalid mount option %q: expecting a numeric ID", o)
+			}
+		}
+	}
+
+	return nil
+}
+
+// ValidateMountDestinations checks that the destination of every mount is
+// an absolute path. All the relative destinations are reported in a single
+// error.
+func ValidateMountDestinations(mounts []vc.Mount) error {
+	return vc.ValidateMountDestinations(mounts)
+}
+
+// ValidateMountOptions checks that none of the mount options could break
+// the parsing of the comma separated options string or inject extra
+// options. Control characters, empty options, options with an empty name
+// and unquoted values holding ',' or '=' are rejected. Double quoted values,
+// like the SELinux context ones, may hold commas as with mount(8).
+func ValidateMountOptions(opts []string) error {
+	for _, o := range opts {
+		if o == "" {
+			return fmt.Errorf("Invalid empty mount option")
+		}
+
+		for _, r := range o {
+			if r < ' ' || r == 0x7f {
+				return fmt.Errorf("Invalid mount option %q: unexpected control character %q", o, r)
+			}
+		}
+
+		fields := strings.SplitN(o, "=", 2)
+		if fields[0] == "" || strings.ContainsAny(fields[0], ",\"") {
+			return fmt.Errorf("Invalid mount option %q: invalid option name", o)
+		}
+
+		if len(fields) == 1 {
+			continue
+		}
+
+		value := fields[1]
+		if len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"' {
+			value = value[1 : len(value)-1]
+			if strings.Contains(value, "\"") {
+				return fmt.Errorf("Invalid mount option %q: unexpected quote in the value", o)
+			}
+			continue
+		}
+
+		if strings.ContainsAny(value, ",=\"") {
+			return fmt.Errorf("Invalid mount option %q: unexpected separator in the value", o)
+		}
+	}
+
+	return nil
+}
+
+// ValidateCgroupsPath checks that the cgroups path of the spec matches the
+// cgroup manager: a "slice:prefix:name" path with systemd cgroups, and a
+// plain path otherwise. An empty path is valid in both modes, the runtime
+// choosing the cgroup then.
+func ValidateCgroupsPath(spec specs.Spec, systemd bool) error {
+	if spec.Linux == nil || spec.Linux.CgroupsPath == "" {
+		return nil
+	}
+
+	path := spec.Linux.CgroupsPath
+	if !systemd {
+		if strings.Contains(path, ":") {
+			return fmt.Errorf("Invalid cgroups path %q: expecting a plain path without systemd cgroups", path)
+		}
+		return nil
+	}
+
+	fields := strings.Split(path, ":")
+	if len(fields) != 3 {
+		return fmt.Errorf("Invalid cgroups path %q: expecting \"slice:prefix:name\" with systemd cgroups", path)
+	}
+
+	slice, prefix, name := fields[0], fields[1], fields[2]
+	if slice != "" && (!strings.HasSuffix(slice, ".slice") || strings.Contains(slice, "/")) {
+		return fmt.Errorf("Invalid cgroups path %q: invalid systemd slice %q", path, slice)
+	}
+
+	if name == "" || strings.Contains(prefix, "/") || strings.Contains(name, "/") {
+		return fmt.Errorf("Invalid cgroups path %q: invalid systemd unit name", path)
+	}
+
+	return nil
 }
 
-func containerMounts(spec specs.Spec) []vc.Mount {
+func containerMounts(spec specs.Spec) ([]vc.Mount, error) {
 	ociMounts := spec.Mounts
 
 	if ociMounts == nil {
-		return []vc.Mount{}
+		return []vc.Mount{}, nil
 	}
 
+	subPaths := mountSubPaths(spec.Annotations)
+
 	var mnts []vc.Mount
 	for _, m := range ociMounts {
-		mnts = append(mnts, newMount(m))
+		if err := ValidateMountOptions(m.Options); err != nil {
+			return []vc.Mount{}, fmt.Errorf("mount %s: %v", m.Destination, err)
+		}
+
+		if err := validateMountOwnership(m); err != nil {
+			return []vc.Mount{}, fmt.Errorf("mount %s: %v", m.Destination, err)
+		}
+
+		mnt := newMount(m)
+
+		if subPath, ok := subPaths[filepath.Clean(m.Destination)]; ok {
+			setMountSubPath(&mnt, subPath)
+		}
+		mnts = append(mnts, mnt)
+	}
+
+	return mnts, nil
+}
+
+// mountSubPaths parses the MountSubPaths annotation into a map of the
+// subpaths indexed by mount destination. Malformed entries are ignored.
+func mountSubPaths(annotations map[string]string) map[string]string {
+	subPaths := make(map[string]string)
+
+	value, ok := annotations[vcAnnotations.MountSubPaths]
+	if !ok {
+		return subPaths
+	}
+
+	for _, entry := range strings.Split(value, MountSubPathsSeparator) {
+		fields := strings.SplitN(strings.TrimSpace(entry), "=", 2)
+		if len(fields) != 2 || fields[0] == "" || fields[1] == "" {
+			if entry != "" {
+				ociLog.WithField("entry", entry).Warn("Ignoring malformed mount subpath")
+			}
+			continue
+		}
+
+		subPaths[filepath.Clean(fields[0])] = fields[1]
+	}
+
+	return subPaths
+}
+
+// setMountSubPath records the subpath of a subPath mount within its volume.
+// The mount source is kept as is, so that only the sub-directory is shared
+// with the guest. The subpath must be relative, must stay within the volume
+// and must match the end of the mount source, otherwise it is ignored.
+func setMountSubPath(mnt *vc.Mount, subPath string) {
+	subPath = filepath.Clean(subPath)
+	source := filepath.Clean(mnt.Source)
+
+	if filepath.IsAbs(subPath) || subPath == "." || subPath == ".." || strings.HasPrefix(subPath, "../") ||
+		!strings.HasSuffix(source, "/"+subPath) {
+		ociLog.WithFields(logrus.Fields{
+			"source":  mnt.Source,
+			"subpath": subPath,
+		}).Warn("Ignoring mount subpath not matching the mount source")
+		return
 	}
 
-	return mnts
+	mnt.SubPath = subPath
 }
 
 func contains(s []string, e string) bool {
@@ -225,6 +699,27 @@ func newLinuxDeviceInfo(d specs.LinuxDevice) (*config.DeviceInfo, error) {
 	return &deviceInfo, nil
 }
 
+const (
+	// maxDeviceMajor and maxDeviceMinor are the largest major and minor
+	// numbers which fit in the Linux dev_t encoding.
+	maxDeviceMajor = 1<<12 - 1
+	maxDeviceMinor = 1<<20 - 1
+)
+
+// validateDeviceNumbers checks the major and minor numbers of a device fit
+// in the Linux dev_t encoding, as they would be silently truncated otherwise.
+func validateDeviceNumbers(major, minor int64) error {
+	if major < 0 || major > maxDeviceMajor {
+		return fmt.Errorf("Invalid device major %d: expecting a number between 0 and %d", major, maxDeviceMajor)
+	}
+
+	if minor < 0 || minor > maxDeviceMinor {
+		return fmt.Errorf("Invalid device minor %d: expecting a number between 0 and %d", minor, maxDeviceMinor)
+	}
+
+	return nil
+}
+
 func containerDeviceInfos(spec specs.Spec) ([]config.DeviceInfo, error) {
 	ociLinuxDevices := spec.Linux.Devices
 
@@ -233,34 +728,134 @@ func containerDeviceInfos(spec specs.Spec) ([]config.DeviceInfo, error) {
 	}
 
 	var devices []config.DeviceInfo
+	paths := make(map[string]bool)
 	for _, d := range ociLinuxDevices {
 		linuxDeviceInfo, err := newLinuxDeviceInfo(d)
 		if err != nil {
 			return []config.DeviceInfo{}, err
 		}
 
+		if err := validateDeviceNumbers(linuxDeviceInfo.Major, linuxDeviceInfo.Minor); err != nil {
+			return []config.DeviceInfo{}, fmt.Errorf("device %s: %v", d.Path, err)
+		}
+
+		// Two devices at the same path would confuse the hotplug
+		// bookkeeping, which tracks the devices by their path.
+		path := filepath.Clean(d.Path)
+		if paths[path] {
+			return []config.DeviceInfo{}, fmt.Errorf("Duplicate device path %s", d.Path)
+		}
+		paths[path] = true
+
 		devices = append(devices, *linuxDeviceInfo)
 	}
 
 	return devices, nil
 }
 
+// NamespaceMode describes how a namespace of a container is set up.
+type NamespaceMode string
+
+const (
+	// NamespaceHost means the namespace is not listed in the OCI spec and
+	// the host namespace is used.
+	NamespaceHost NamespaceMode = "host"
+
+	// NamespacePrivate means a new namespace is created for the container.
+	NamespacePrivate NamespaceMode = "private"
+
+	// NamespaceShared means an existing namespace, identified by its path,
+	// is joined by the container.
+	NamespaceShared NamespaceMode = "shared"
+)
+
+// NamespaceSet describes how each namespace of a container is set up.
+type NamespaceSet struct {
+	PID     NamespaceMode
+	Network NamespaceMode
+	Mount   NamespaceMode
+	IPC     NamespaceMode
+	UTS     NamespaceMode
+	User    NamespaceMode
+	Cgroup  NamespaceMode
+
+	// Paths holds the path of every shared namespace.
+	Paths map[specs.LinuxNamespaceType]string
+}
+
+// NamespaceConfig returns how each namespace of the container described by
+// the OCI spec is set up. A namespace listed without a path is private, a
+// namespace listed with a path is shared, and a namespace which is not
+// listed is the host one.
+func NamespaceConfig(spec specs.Spec) NamespaceSet {
+	set := NamespaceSet{
+		PID:     NamespaceHost,
+		Network: NamespaceHost,
+		Mount:   NamespaceHost,
+		IPC:     NamespaceHost,
+		UTS:     NamespaceHost,
+		User:    NamespaceHost,
+		Cgroup:  NamespaceHost,
+		Paths:   make(map[specs.LinuxNamespaceType]string),
+	}
+
+	if spec.Linux == nil {
+		return set
+	}
+
+	modes := map[specs.LinuxNamespaceType]*NamespaceMode{
+		specs.PIDNamespace:     &set.PID,
+		specs.NetworkNamespace: &set.Network,
+		specs.MountNamespace:   &set.Mount,
+		specs.IPCNamespace:     &set.IPC,
+		specs.UTSNamespace:     &set.UTS,
+		specs.UserNamespace:    &set.User,
+		specs.CgroupNamespace:  &set.Cgroup,
+	}
+
+	for _, n := range spec.Linux.Namespaces {
+		mode, ok := modes[n.Type]
+		if !ok {
+			continue
+		}
+
+		if n.Path == "" {
+			*mode = NamespacePrivate
+			continue
+		}
+
+		*mode = NamespaceShared
+		set.Paths[n.Type] = n.Path
+	}
+
+	return set
+}
+
+// networkConfig returns the network configuration of the sandbox. The
+// network namespace path is taken from the spec when it joins an existing
+// network namespace. Otherwise it is left empty, and NetNsCreated unset, as
+// the network namespace is only created by the caller, through
+// katautils.SetupNetworkNamespace, which flags it as created.
 func networkConfig(ocispec specs.Spec, config RuntimeConfig) (vc.NetworkConfig, error) {
 	linux := ocispec.Linux
 	if linux == nil {
 		return vc.NetworkConfig{}, ErrNoLinux
 	}
 
-	var netConf vc.NetworkConfig
-
+	netNamespaces := 0
 	for _, n := range linux.Namespaces {
-		if n.Type != specs.NetworkNamespace {
-			continue
+		if n.Type == specs.NetworkNamespace {
+			netNamespaces++
 		}
+	}
+	if netNamespaces > 1 {
+		return vc.NetworkConfig{}, fmt.Errorf("Found %d network namespaces in the spec, expecting at most one", netNamespaces)
+	}
 
-		if n.Path != "" {
-			netConf.NetNSPath = n.Path
-		}
+	var netConf vc.NetworkConfig
+
+	if ns := NamespaceConfig(ocispec); ns.Network == NamespaceShared {
+		netConf.NetNSPath = ns.Paths[specs.NetworkNamespace]
 	}
 	netConf.InterworkingModel = config.InterNetworkModel
 	netConf.DisableNewNetNs = config.DisableNewNetNs
@@ -274,11 +869,46 @@ func networkConfig(ocispec specs.Spec, config RuntimeConfig) (vc.NetworkConfig,
 	return netConf, nil
 }
 
+// containerTypeFromAnnotations returns the container type defined by the
+// annotations, and whether one is defined. The annotation keys are checked
+// in order: the CRI servers ones, as listed by CRIContainerTypeKeyList, then
+// vcAnnotations.ContainerTypeKey, set by ContainerConfig. The first key found
+// decides, an unknown value being an error.
+func containerTypeFromAnnotations(annotations map[string]string) (vc.ContainerType, bool, error) {
+	for _, key := range CRIContainerTypeKeyList {
+		containerTypeVal, ok := annotations[key]
+		if !ok {
+			continue
+		}
+
+		for _, t := range CRIContainerTypeList {
+			if t.annotation == containerTypeVal {
+				return t.containerType, true, nil
+			}
+
+		}
+
+		return vc.UnknownContainerType, true, fmt.Errorf("Unknown container type %s", containerTypeVal)
+	}
+
+	if containerTypeVal, ok := annotations[vcAnnotations.ContainerTypeKey]; ok {
+		switch cType := vc.ContainerType(containerTypeVal); cType {
+		case vc.PodSandbox, vc.PodContainer:
+			return cType, true, nil
+		default:
+			return vc.UnknownContainerType, true, fmt.Errorf("Unknown container type %s", containerTypeVal)
+		}
+	}
+
+	return vc.UnknownContainerType, false, nil
+}
+
 // GetContainerType determines which type of container matches the annotations
 // table provided.
 func GetContainerType(annotations map[string]string) (vc.ContainerType, error) {
-	if containerType, ok := annotations[vcAnnotations.ContainerTypeKey]; ok {
-		return vc.ContainerType(containerType), nil
+	containerType, found, err := containerTypeFromAnnotations(annotations)
+	if found {
+		return containerType, err
 	}
 
 	ociLog.Errorf("Annotations[%s] not found, cannot determine the container type",
@@ -287,22 +917,16 @@ func GetContainerType(annotations map[string]string) (vc.ContainerType, error) {
 }
 
 // ContainerType returns the type of container and if the container type was
-// found from CRI servers annotations.
+// found from CRI servers annotations. A container without any container type
+// annotation is a sandbox.
+//
+// ContainerType is not memoized: it only costs a few map lookups, see
+// BenchmarkContainerType, and a cache would have to repeat those lookups
+// anyway to notice annotations mutated between calls.
 func ContainerType(spec specs.Spec) (vc.ContainerType, error) {
-	for _, key := range CRIContainerTypeKeyList {
-		containerTypeVal, ok := spec.Annotations[key]
-		if !ok {
-			continue
-		}
-
-		for _, t := range CRIContainerTypeList {
-			if t.annotation == containerTypeVal {
-				return t.containerType, nil
-			}
-
-		}
-
-		return vc.UnknownContainerType, fmt.Errorf("Unknown container type %s", containerTypeVal)
+	containerType, found, err := containerTypeFromAnnotations(spec.Annotations)
+	if found {
+		return containerType, err
 	}
 
 	return vc.PodSandbox, nil
@@ -321,7 +945,39 @@ func SandboxID(spec specs.Spec) (string, error) {
 	return "", fmt.Errorf("Could not find sandbox ID")
 }
 
-func addAssetAnnotations(ocispec specs.Spec, config *vc.SandboxConfig) {
+// GetSandboxIDForContainer returns the sandbox ID found in the annotations
+// of a container, and whether the container is itself a sandbox. The ID of a
+// sandbox is its own container ID, so an empty sandbox ID is returned for a
+// sandbox without sandbox ID annotation. An empty sandbox ID is returned as
+// well for a container without sandbox ID annotation.
+func GetSandboxIDForContainer(annotations map[string]string) (string, bool) {
+	containerType, err := ContainerType(specs.Spec{Annotations: annotations})
+	isSandbox := err == nil && containerType.IsSandbox()
+
+	sandboxID, _ := SandboxID(specs.Spec{Annotations: annotations})
+
+	return sandboxID, isSandbox
+}
+
+// ImageRef returns the container image reference passed by the CRI servers
+// through the annotations, or an empty string when it cannot be found.
+func ImageRef(annotations map[string]string) string {
+	for _, key := range CRIImageNameKeyList {
+		if image, ok := annotations[key]; ok && image != "" {
+			return image
+		}
+	}
+
+	return ""
+}
+
+func addAssetAnnotations(ocispec specs.Spec, config *vc.SandboxConfig) error {
+	return addAssetAnnotationsWithDefaults(ocispec, config, nil)
+}
+
+// addAssetAnnotationsWithDefaults is addAssetAnnotations with fallback
+// values for the asset annotations the spec doesn't set.
+func addAssetAnnotationsWithDefaults(ocispec specs.Spec, config *vc.SandboxConfig, defaults map[string]string) error {
 	assetAnnotations := []string{
 		vcAnnotations.KernelPath,
 		vcAnnotations.ImagePath,
@@ -331,147 +987,1405 @@ func addAssetAnnotations(ocispec specs.Spec, config *vc.SandboxConfig) {
 		vcAnnotations.AssetHashType,
 	}
 
-	for _, a := range assetAnnotations {
-		value, ok := ocispec.Annotations[a]
+	lookup := func(a string) (string, bool) {
+		if value, ok := ocispec.Annotations[a]; ok {
+			return value, true
+		}
+
+		value, ok := defaults[a]
+		return value, ok
+	}
+
+	for _, a := range assetAnnotations {
+		value, ok := lookup(a)
 		if !ok {
 			continue
 		}
 
-		config.Annotations[a] = value
+		config.Annotations[a] = value
+	}
+
+	if value, ok := lookup(vcAnnotations.KernelModules); ok {
+		if c, ok := config.AgentConfig.(vc.KataAgentConfig); ok {
+			modules := strings.Split(value, KernelModulesSeparator)
+			c.KernelModules = modules
+			config.AgentConfig = c
+		}
+	}
+
+	return validateAssetHashType(config.Annotations)
+}
+
+// assetHashTypes lists the supported asset hash types.
+var assetHashTypes = []string{vcAnnotations.SHA512}
+
+// validateAssetHashType checks that the asset hash type is a supported one
+// when an asset hash is set, as an unknown hash type would fail the asset
+// verification.
+func validateAssetHashType(annotations map[string]string) error {
+	_, kernelHash := annotations[vcAnnotations.KernelHash]
+	_, imageHash := annotations[vcAnnotations.ImageHash]
+	if !kernelHash && !imageHash {
+		return nil
+	}
+
+	hashType, ok := annotations[vcAnnotations.AssetHashType]
+	if !ok {
+		return fmt.Errorf("Annotation %s is required with the asset hashes: expecting one of %v",
+			vcAnnotations.AssetHashType, assetHashTypes)
+	}
+
+	if !contains(assetHashTypes, hashType) {
+		return fmt.Errorf("Invalid value %q for annotation %s: expecting one of %v",
+			hashType, vcAnnotations.AssetHashType, assetHashTypes)
+	}
+
+	return nil
+}
+
+// ValidateAssetPaths checks that every asset path of the sandbox
+// configuration, whether set through an annotation or through the hypervisor
+// configuration, is absolute and exists on the host. All the invalid paths
+// are reported in a single error.
+func ValidateAssetPaths(config vc.SandboxConfig) error {
+	return vc.ValidateAssetPaths(config)
+}
+
+// QoSClass returns the Kubernetes QoS class of a container. The QoSClass
+// annotation is used when present, otherwise the class is derived from the
+// OCI spec resources following the Kubernetes rules:
+//
+//   - BestEffort: no memory limit, no CPU quota and no CPU shares request
+//   - Guaranteed: both a memory limit and a CPU quota, with the CPU shares
+//     matching the quota
+//   - Burstable: any other case
+func QoSClass(annotations map[string]string, spec specs.Spec) string {
+	if class, ok := annotations[vcAnnotations.QoSClass]; ok && class != "" {
+		return class
+	}
+
+	var resources specs.LinuxResources
+	if spec.Linux != nil && spec.Linux.Resources != nil {
+		resources = *spec.Linux.Resources
+	}
+
+	var memoryLimit bool
+	if mem := resources.Memory; mem != nil && mem.Limit != nil && *mem.Limit > 0 {
+		memoryLimit = true
+	}
+
+	var cpuLimitMilli, cpuRequestMilli uint64
+	if cpu := resources.CPU; cpu != nil {
+		if cpu.Quota != nil && *cpu.Quota > 0 && cpu.Period != nil && *cpu.Period > 0 {
+			cpuLimitMilli = uint64(*cpu.Quota) * 1000 / *cpu.Period
+		}
+
+		if cpu.Shares != nil && *cpu.Shares > minCPUShares {
+			cpuRequestMilli = *cpu.Shares * 1000 / 1024
+		}
+	}
+
+	if !memoryLimit && cpuLimitMilli == 0 && cpuRequestMilli == 0 {
+		return QoSBestEffort
+	}
+
+	// Converting the CPU request to shares and back loses precision.
+	if memoryLimit && cpuLimitMilli != 0 &&
+		cpuRequestMilli+1 >= cpuLimitMilli && cpuRequestMilli <= cpuLimitMilli+1 {
+		return QoSGuaranteed
+	}
+
+	return QoSBurstable
+}
+
+// ResolvedResources describes the guest resources resulting from both the
+// OCI spec and the annotations.
+type ResolvedResources struct {
+	// MemoryMiB is the guest memory size in MiB, 0 when unset.
+	MemoryMiB uint32
+
+	// VCPUs is the number of guest vCPUs, 0 when unset.
+	VCPUs uint32
+}
+
+// ReconcileResources resolves the guest resources requested by both the OCI
+// spec resources and the DefaultMemory and DefaultVCPUs annotations. The
+// annotations take precedence over the spec. Requesting less guest memory
+// than the spec memory limit is logged as a warning, and is an error when it
+// is less than half of the limit since the containers would be OOM killed
+// far before reaching their limit.
+func ReconcileResources(spec specs.Spec, annotations map[string]string) (ResolvedResources, error) {
+	var resources ResolvedResources
+
+	var specMemoryMiB uint64
+	if spec.Linux != nil && spec.Linux.Resources != nil {
+		if mem := spec.Linux.Resources.Memory; mem != nil && mem.Limit != nil && *mem.Limit > 0 {
+			specMemoryMiB = (uint64(*mem.Limit) + (1 << vcUtils.MibToBytesShift) - 1) >> vcUtils.MibToBytesShift
+			resources.MemoryMiB = uint32(specMemoryMiB)
+		}
+
+		if cpu := spec.Linux.Resources.CPU; cpu != nil && cpu.Quota != nil && cpu.Period != nil && *cpu.Quota > 0 {
+			resources.VCPUs = uint32(vcUtils.ConstraintsToVCPUs(*cpu.Quota, *cpu.Period))
+		}
+	}
+
+	if value, ok := annotations[vcAnnotations.DefaultMemory]; ok {
+		memory, err := strconv.ParseUint(value, 10, 32)
+		if err != nil || memory == 0 {
+			return ResolvedResources{}, fmt.Errorf("Invalid value %q for annotation %s: expecting a positive number of MiB",
+				value, vcAnnotations.DefaultMemory)
+		}
+
+		if memory < specMemoryMiB {
+			if memory < specMemoryMiB/2 {
+				return ResolvedResources{}, fmt.Errorf("Annotation %s requests %d MiB, far below the %d MiB memory limit of the spec",
+					vcAnnotations.DefaultMemory, memory, specMemoryMiB)
+			}
+
+			ociLog.Warnf("Annotation %s requests %d MiB, below the %d MiB memory limit of the spec",
+				vcAnnotations.DefaultMemory, memory, specMemoryMiB)
+		}
+
+		resources.MemoryMiB = uint32(memory)
+	}
+
+	if value, ok := annotations[vcAnnotations.DefaultVCPUs]; ok {
+		vcpus, err := strconv.ParseUint(value, 10, 32)
+		if err != nil || vcpus == 0 {
+			return ResolvedResources{}, fmt.Errorf("Invalid value %q for annotation %s: expecting a positive number of vCPUs",
+				value, vcAnnotations.DefaultVCPUs)
+		}
+
+		resources.VCPUs = uint32(vcpus)
+	}
+
+	return resources, nil
+}
+
+// ValidateGuestMemory cross-checks the guest memory of the sandbox against
+// the sum of the memory limits of its containers, as done by
+// ReconcileResources for a single spec. Guest memory below the sum of the
+// limits is logged as a warning, and is an error when it is less than half
+// of it, since the host would OOM kill the guest far before the containers
+// reach their limits. The check is skipped when the guest memory is unknown.
+func ValidateGuestMemory(sandboxConfig vc.SandboxConfig) error {
+	memoryMiB := sandboxConfig.HypervisorConfig.MemorySize
+	if memoryMiB == 0 {
+		return nil
+	}
+
+	ratio := sandboxConfig.MemoryOvercommitRatio()
+	if ratio <= 1 {
+		return nil
+	}
+
+	limitsMiB := uint64(ratio * float64(memoryMiB))
+	if ratio > 2 {
+		return fmt.Errorf("Guest memory of %d MiB is far below the %d MiB sum of the containers memory limits",
+			memoryMiB, limitsMiB)
+	}
+
+	ociLog.Warnf("Guest memory of %d MiB is below the %d MiB sum of the containers memory limits",
+		memoryMiB, limitsMiB)
+
+	return nil
+}
+
+// crashKernelSizeRegexp matches a "crashkernel=" reservation size with an
+// optional offset, e.g. "256M" or "128M@16M".
+var crashKernelSizeRegexp = regexp.MustCompile(`^[0-9]+[KMG]?(@[0-9]+[KMG]?)?$`)
+
+// parseBoolAnnotation parses a boolean annotation value. Unlike
+// strconv.ParseBool, only "true" and "false" are accepted.
+func parseBoolAnnotation(key, value string) (bool, error) {
+	switch value {
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	default:
+		return false, fmt.Errorf("Invalid value %q for annotation %s: expecting \"true\" or \"false\"", value, key)
+	}
+}
+
+// checkWatchdogSupport checks that the i6300esb watchdog can be added to the
+// VM, which is only done by QEMU and not on the architectures without PCI
+// support for it.
+func checkWatchdogSupport(hypervisorType vc.HypervisorType, arch string) error {
+	if hypervisorType != vc.QemuHypervisor {
+		return fmt.Errorf("Annotation %s is not supported by the %s hypervisor", vcAnnotations.EnableWatchdog, hypervisorType)
+	}
+
+	if arch == "arm64" || arch == "s390x" {
+		return fmt.Errorf("Annotation %s is not supported on %s", vcAnnotations.EnableWatchdog, arch)
+	}
+
+	return nil
+}
+
+func addHypervisorConfigOverrides(ocispec specs.Spec, sandboxConfig *vc.SandboxConfig) error {
+	if value, ok := ocispec.Annotations[vcAnnotations.EnableKdump]; ok {
+		enableKdump, err := parseBoolAnnotation(vcAnnotations.EnableKdump, value)
+		if err != nil {
+			return err
+		}
+
+		size := DefaultKdumpCrashKernelSize
+		if value, ok := ocispec.Annotations[vcAnnotations.KdumpCrashKernelSize]; ok {
+			if !crashKernelSizeRegexp.MatchString(value) {
+				return fmt.Errorf("Invalid value %q for annotation %s", value, vcAnnotations.KdumpCrashKernelSize)
+			}
+			size = value
+		}
+
+		if enableKdump {
+			sandboxConfig.HypervisorConfig.EnableKdump = true
+			if err := sandboxConfig.HypervisorConfig.AddKernelParam(vc.Param{Key: "crashkernel", Value: size}); err != nil {
+				return err
+			}
+		}
+	}
+
+	if value, ok := ocispec.Annotations[vcAnnotations.BlockDeviceCacheMode]; ok {
+		if err := setBlockDeviceCacheMode(&sandboxConfig.HypervisorConfig, value); err != nil {
+			return err
+		}
+	}
+
+	if value, ok := ocispec.Annotations[vcAnnotations.VirtioFSQueueSize]; ok {
+		if sandboxConfig.HypervisorConfig.SharedFS != config.VirtioFS {
+			return fmt.Errorf("Annotation %s requires the %s shared file system, not %q",
+				vcAnnotations.VirtioFSQueueSize, config.VirtioFS, sandboxConfig.HypervisorConfig.SharedFS)
+		}
+
+		queueSize, err := strconv.ParseUint(value, 10, 32)
+		if err != nil || queueSize == 0 || queueSize > maxVirtioFSQueueSize {
+			return fmt.Errorf("Invalid value %q for annotation %s: expecting a number between 1 and %d",
+				value, vcAnnotations.VirtioFSQueueSize, maxVirtioFSQueueSize)
+		}
+
+		sandboxConfig.HypervisorConfig.VirtioFSQueueSize = uint32(queueSize)
+	}
+
+	if value, ok := ocispec.Annotations[vcAnnotations.EnableNestedVirt]; ok {
+		enableNestedVirt, err := parseBoolAnnotation(vcAnnotations.EnableNestedVirt, value)
+		if err != nil {
+			return err
+		}
+
+		sandboxConfig.HypervisorConfig.EnableNestedVirt = enableNestedVirt
+	}
+
+	if value, ok := ocispec.Annotations[vcAnnotations.EnableWatchdog]; ok {
+		enableWatchdog, err := parseBoolAnnotation(vcAnnotations.EnableWatchdog, value)
+		if err != nil {
+			return err
+		}
+
+		var timeout uint64
+		if value, ok := ocispec.Annotations[vcAnnotations.WatchdogTimeout]; ok {
+			timeout, err = strconv.ParseUint(value, 10, 32)
+			if err != nil || timeout == 0 || timeout > maxWatchdogTimeout {
+				return fmt.Errorf("Invalid value %q for annotation %s: expecting a number of seconds between 1 and %d",
+					value, vcAnnotations.WatchdogTimeout, maxWatchdogTimeout)
+			}
+		}
+
+		if enableWatchdog {
+			if err := checkWatchdogSupport(sandboxConfig.HypervisorType, goruntime.GOARCH); err != nil {
+				return err
+			}
+
+			sandboxConfig.HypervisorConfig.EnableWatchdog = true
+			sandboxConfig.HypervisorConfig.WatchdogTimeout = uint32(timeout)
+		}
+	}
+
+	if value, ok := ocispec.Annotations[vcAnnotations.ConfidentialGuest]; ok {
+		confidentialGuest, err := parseBoolAnnotation(vcAnnotations.ConfidentialGuest, value)
+		if err != nil {
+			return err
+		}
+
+		sandboxConfig.HypervisorConfig.ConfidentialGuest = confidentialGuest
+	}
+
+	if value, ok := ocispec.Annotations[vcAnnotations.EntropyBootWait]; ok {
+		entropyBootWait, err := parseBoolAnnotation(vcAnnotations.EntropyBootWait, value)
+		if err != nil {
+			return err
+		}
+
+		// The agent has no way to wait for the guest entropy.
+		if entropyBootWait {
+			return fmt.Errorf("Annotation %s is not supported by the agent", vcAnnotations.EntropyBootWait)
+		}
+	}
+
+	if value, ok := ocispec.Annotations[vcAnnotations.HugepageNUMANode]; ok {
+		if !sandboxConfig.HypervisorConfig.HugePages {
+			return fmt.Errorf("Annotation %s requires huge pages to be enabled", vcAnnotations.HugepageNUMANode)
+		}
+
+		// The hypervisor memory is only constrained by the sandbox cgroup
+		// when it is the only cgroup.
+		if !sandboxConfig.SandboxCgroupOnly {
+			return fmt.Errorf("Annotation %s requires sandbox_cgroup_only to be enabled", vcAnnotations.HugepageNUMANode)
+		}
+
+		node, err := strconv.ParseUint(value, 10, 32)
+		if err != nil {
+			return fmt.Errorf("Invalid value %q for annotation %s: expecting a non-negative number",
+				value, vcAnnotations.HugepageNUMANode)
+		}
+
+		if _, err := os.Stat(fmt.Sprintf(numaNodePath, node)); err != nil {
+			return fmt.Errorf("Invalid value %q for annotation %s: no such host NUMA node",
+				value, vcAnnotations.HugepageNUMANode)
+		}
+
+		numaNode := uint32(node)
+		sandboxConfig.HypervisorConfig.HugepageNUMANode = &numaNode
+	}
+
+	return nil
+}
+
+// setBlockDeviceCacheMode translates a block device cache mode into the
+// QEMU cache options of the hypervisor configuration. Both "writeback" and
+// "writethrough" go through the host page cache and honour the guest flush
+// requests, "writethrough" also turning the write cache of the guest block
+// devices off, while "none" bypasses the host page cache.
+func setBlockDeviceCacheMode(config *vc.HypervisorConfig, mode string) error {
+	switch mode {
+	case "writeback", "writethrough":
+		config.BlockDeviceCacheDirect = false
+	case "none":
+		config.BlockDeviceCacheDirect = true
+	default:
+		return fmt.Errorf("Invalid value %q for annotation %s: expecting one of [writeback writethrough none]",
+			mode, vcAnnotations.BlockDeviceCacheMode)
+	}
+
+	config.BlockDeviceCacheMode = mode
+	config.BlockDeviceCacheSet = true
+	config.BlockDeviceCacheNoflush = false
+
+	return nil
+}
+
+func addRuntimeConfigOverrides(ocispec specs.Spec, sandboxConfig *vc.SandboxConfig) error {
+	if value, ok := ocispec.Annotations[vcAnnotations.ExposeKVM]; ok {
+		exposeKVM, err := parseBoolAnnotation(vcAnnotations.ExposeKVM, value)
+		if err != nil {
+			return err
+		}
+
+		if exposeKVM {
+			if !sandboxConfig.HypervisorConfig.EnableNestedVirt {
+				return fmt.Errorf("Annotation %s requires nested virtualization to be enabled", vcAnnotations.ExposeKVM)
+			}
+
+			major := int64(kvmDeviceMajor)
+			minor := int64(kvmDeviceMinor)
+
+			for i := range sandboxConfig.Containers {
+				c := &sandboxConfig.Containers[i]
+				c.DeviceInfos = append(c.DeviceInfos, config.DeviceInfo{
+					ContainerPath: kvmDevicePath,
+					DevType:       "c",
+					Major:         kvmDeviceMajor,
+					Minor:         kvmDeviceMinor,
+				})
+				c.Resources.Devices = append(c.Resources.Devices, specs.LinuxDeviceCgroup{
+					Allow:  true,
+					Type:   "c",
+					Major:  &major,
+					Minor:  &minor,
+					Access: "rwm",
+				})
+			}
+		}
+	}
+
+	if value, ok := ocispec.Annotations[vcAnnotations.TransparentHugepage]; ok {
+		if !contains(transparentHugepagePolicies, value) {
+			return fmt.Errorf("Invalid value %q for annotation %s: expecting one of %v",
+				value, vcAnnotations.TransparentHugepage, transparentHugepagePolicies)
+		}
+
+		sandboxConfig.TransparentHugepage = value
+	}
+
+	if value := ocispec.Annotations[vcAnnotations.Hostname]; value != "" {
+		sandboxConfig.Hostname = value
+	}
+
+	// The agent has no request to set the I/O scheduler.
+	if _, ok := ocispec.Annotations[vcAnnotations.IOScheduler]; ok {
+		return fmt.Errorf("Annotation %s is not supported by the agent", vcAnnotations.IOScheduler)
+	}
+
+	if value, ok := ocispec.Annotations[vcAnnotations.PanicOnOOM]; ok {
+		panicOnOOM, err := parseBoolAnnotation(vcAnnotations.PanicOnOOM, value)
+		if err != nil {
+			return err
+		}
+
+		sysctl := "0"
+		if panicOnOOM {
+			sysctl = "1"
+		}
+		addGuestSysctl(sandboxConfig, "vm.panic_on_oom", sysctl)
+	}
+
+	// The routes passed to the agent carry no metric.
+	if _, ok := ocispec.Annotations[vcAnnotations.DefaultRouteMetric]; ok {
+		return fmt.Errorf("Annotation %s is not supported by the agent", vcAnnotations.DefaultRouteMetric)
+	}
+
+	if value, ok := ocispec.Annotations[vcAnnotations.PidMax]; ok {
+		pidMax, err := strconv.ParseUint(value, 10, 32)
+		if err != nil || pidMax < minPidMax || pidMax > maxPidMax {
+			return fmt.Errorf("Invalid value %q for annotation %s: expecting a number between %d and %d",
+				value, vcAnnotations.PidMax, minPidMax, maxPidMax)
+		}
+
+		addGuestSysctl(sandboxConfig, "kernel.pid_max", strconv.FormatUint(pidMax, 10))
+	}
+
+	if value, ok := ocispec.Annotations[vcAnnotations.NfConntrackMax]; ok {
+		conntrackMax, err := strconv.ParseUint(value, 10, 32)
+		if err != nil || conntrackMax == 0 || conntrackMax > maxNfConntrackMax {
+			return fmt.Errorf("Invalid value %q for annotation %s: expecting a number between 1 and %d",
+				value, vcAnnotations.NfConntrackMax, maxNfConntrackMax)
+		}
+
+		addGuestSysctl(sandboxConfig, "net.netfilter.nf_conntrack_max", strconv.FormatUint(conntrackMax, 10))
+	}
+
+	if value, ok := ocispec.Annotations[vcAnnotations.VMSwappiness]; ok {
+		swappiness, err := strconv.ParseUint(value, 10, 32)
+		if err != nil || swappiness > maxVMSwappiness {
+			return fmt.Errorf("Invalid value %q for annotation %s: expecting a number between 0 and %d",
+				value, vcAnnotations.VMSwappiness, maxVMSwappiness)
+		}
+
+		addGuestSysctl(sandboxConfig, "vm.swappiness", strconv.FormatUint(swappiness, 10))
+	}
+
+	tcpBufferSysctls := []struct {
+		annotation string
+		sysctl     string
+	}{
+		{vcAnnotations.TCPRmem, "net.ipv4.tcp_rmem"},
+		{vcAnnotations.TCPWmem, "net.ipv4.tcp_wmem"},
+	}
+
+	for _, s := range tcpBufferSysctls {
+		if value, ok := ocispec.Annotations[s.annotation]; ok {
+			sizes, err := parseTCPBufferSizes(s.annotation, value)
+			if err != nil {
+				return err
+			}
+
+			addGuestSysctl(sandboxConfig, s.sysctl, sizes)
+		}
+	}
+
+	return nil
+}
+
+// parseTCPBufferSizes checks a TCP buffer sizes annotation holds a
+// "min default max" triplet of bytes, and returns it normalized.
+func parseTCPBufferSizes(key, value string) (string, error) {
+	fields := strings.Fields(value)
+	if len(fields) != 3 {
+		return "", fmt.Errorf("Invalid value %q for annotation %s: expecting a \"min default max\" triplet", value, key)
+	}
+
+	var sizes [3]uint64
+	for i, f := range fields {
+		size, err := strconv.ParseUint(f, 10, 32)
+		if err != nil {
+			return "", fmt.Errorf("Invalid value %q for annotation %s: expecting a \"min default max\" triplet", value, key)
+		}
+		sizes[i] = size
+	}
+
+	if sizes[0] > sizes[1] || sizes[1] > sizes[2] {
+		return "", fmt.Errorf("Invalid value %q for annotation %s: expecting min <= default <= max", value, key)
+	}
+
+	return strings.Join(fields, " "), nil
+}
+
+func addGuestSysctl(sandboxConfig *vc.SandboxConfig, key, value string) {
+	if sandboxConfig.GuestSysctls == nil {
+		sandboxConfig.GuestSysctls = make(map[string]string)
+	}
+
+	sandboxConfig.GuestSysctls[key] = value
+}
+
+func addAgentConfigOverrides(ocispec specs.Spec, config *vc.SandboxConfig) error {
+	c, ok := config.AgentConfig.(vc.KataAgentConfig)
+	if !ok {
+		return nil
+	}
+
+	// The agent provides no metrics endpoint.
+	if value, ok := ocispec.Annotations[vcAnnotations.EnableMetrics]; ok {
+		enableMetrics, err := parseBoolAnnotation(vcAnnotations.EnableMetrics, value)
+		if err != nil {
+			return err
+		}
+
+		if enableMetrics {
+			return fmt.Errorf("Annotation %s is not supported by the agent", vcAnnotations.EnableMetrics)
+		}
+	}
+
+	if _, ok := ocispec.Annotations[vcAnnotations.MetricsPort]; ok {
+		return fmt.Errorf("Annotation %s is not supported by the agent", vcAnnotations.MetricsPort)
+	}
+
+	if value, ok := ocispec.Annotations[vcAnnotations.InitArgs]; ok {
+		initArgs := strings.Fields(value)
+		if len(initArgs) == 0 {
+			return fmt.Errorf("Invalid value %q for annotation %s: expecting space separated arguments",
+				value, vcAnnotations.InitArgs)
+		}
+
+		c.InitArgs = initArgs
+	}
+
+	config.AgentConfig = c
+
+	return nil
+}
+
+func addAnnotations(ocispec specs.Spec, config *vc.SandboxConfig) error {
+	if err := addAssetAnnotations(ocispec, config); err != nil {
+		return err
+	}
+
+	if err := addHypervisorConfigOverrides(ocispec, config); err != nil {
+		return err
+	}
+
+	if err := addRuntimeConfigOverrides(ocispec, config); err != nil {
+		return err
+	}
+
+	return addAgentConfigOverrides(ocispec, config)
+}
+
+// SandboxConfig converts an OCI compatible runtime configuration file
+// to a virtcontainers sandbox configuration structure.
+//
+// When the process requires a terminal but no console path is provided, the
+// console configured in the runtime configuration is used. If none is
+// configured either, the container is created without a console path and
+// its terminal is expected to be handled by the caller through the process
+// IO streams, as the shim v2 does.
+func SandboxConfig(ocispec specs.Spec, runtime RuntimeConfig, bundlePath, cid, console string, detach, systemdCgroup bool) (vc.SandboxConfig, error) {
+	return SandboxConfigWithContext(context.Background(), ocispec, runtime, bundlePath, cid, console, detach, systemdCgroup)
+}
+
+// SandboxConfigWithContext is SandboxConfig with cancellation: the context
+// is checked before each step accessing the host file system, that is the
+// container devices, the shm size and the annotations parsing, and its error
+// is returned as soon as it is cancelled. A step already started completes.
+func SandboxConfigWithContext(ctx context.Context, ocispec specs.Spec, runtime RuntimeConfig, bundlePath, cid, console string, detach, systemdCgroup bool) (vc.SandboxConfig, error) {
+	// Getting the container config stats the host paths of its devices.
+	if err := ctx.Err(); err != nil {
+		return vc.SandboxConfig{}, err
+	}
+
+	if ocispec.Process != nil && ocispec.Process.Terminal && console == "" {
+		console = runtime.Console
+		if console == "" {
+			ociLog.WithField("container", cid).Warn("terminal requested without a console path")
+		}
+	}
+
+	containerConfig, err := ContainerConfig(ocispec, bundlePath, cid, console, detach)
+	if err != nil {
+		return vc.SandboxConfig{}, err
+	}
+
+	if err := ValidateMountDestinations(containerConfig.Mounts); err != nil {
+		return vc.SandboxConfig{}, err
+	}
+
+	if runtime.WarnDroppedFields {
+		for _, field := range DroppedFields(ocispec) {
+			ociLog.WithFields(logrus.Fields{
+				"container": cid,
+				"field":     field,
+			}).Warn("Ignoring unsupported OCI spec field")
+		}
+	}
+
+	if runtime.StrictCapabilities {
+		if err := ValidateCapabilities(containerConfig.Cmd.Capabilities); err != nil {
+			return vc.SandboxConfig{}, err
+		}
+	}
+
+	if runtime.DropSandboxCapabilities {
+		dropSandboxCapabilities(&containerConfig)
+	}
+
+	// Getting the size of a bind mounted /dev/shm stats its source.
+	if err := ctx.Err(); err != nil {
+		return vc.SandboxConfig{}, err
+	}
+
+	shmSize, err := getShmSize(containerConfig)
+	if err != nil {
+		return vc.SandboxConfig{}, err
+	}
+
+	networkConfig, err := networkConfig(ocispec, runtime)
+	if err != nil {
+		return vc.SandboxConfig{}, err
+	}
+
+	sandboxConfig := vc.SandboxConfig{
+		ID: cid,
+
+		Hostname: ocispec.Hostname,
+
+		HypervisorType:   runtime.HypervisorType,
+		HypervisorConfig: runtime.HypervisorConfig,
+
+		AgentType:   runtime.AgentType,
+		AgentConfig: runtime.AgentConfig,
+
+		ProxyType:   runtime.ProxyType,
+		ProxyConfig: runtime.ProxyConfig,
+
+		ShimType:   runtime.ShimType,
+		ShimConfig: runtime.ShimConfig,
+
+		NetworkConfig: networkConfig,
+
+		Containers: []vc.ContainerConfig{containerConfig},
+
+		Annotations: map[string]string{
+			vcAnnotations.BundlePathKey: bundlePath,
+		},
+
+		ShmSize: shmSize,
+
+		SystemdCgroup: systemdCgroup,
+
+		SandboxCgroupOnly: runtime.SandboxCgroupOnly,
+
+		DisableGuestSeccomp: runtime.DisableGuestSeccomp,
+
+		// Q: Is this really necessary? @weizhang555
+		// Spec: &ocispec,
+
+		Experimental: runtime.Experimental,
+	}
+
+	// The annotations parsing checks that the host NUMA node exists.
+	if err := ctx.Err(); err != nil {
+		return vc.SandboxConfig{}, err
+	}
+
+	if err := addAnnotations(ocispec, &sandboxConfig); err != nil {
+		return vc.SandboxConfig{}, err
+	}
+
+	if err := validateShmSize(shmSize, sandboxConfig.HypervisorConfig.MemorySize, runtime.ShmMemoryFraction); err != nil {
+		return vc.SandboxConfig{}, err
+	}
+
+	return sandboxConfig, nil
+}
+
+// ValidateConfigJSON parses the config.json file of the bundle and returns
+// every problem found in the spec, rather than stopping at the first one as
+// SandboxConfig and ContainerConfig do. This provides a complete report of
+// a broken bundle. Once the spec converts, the resulting configuration is
+// checked with vc.SandboxConfig.ValidateAll, the container being named after
+// the bundle directory.
+func ValidateConfigJSON(bundlePath string) []error {
+	ocispec, err := compatoci.ParseConfigJSON(bundlePath)
+	if err != nil {
+		return []error{err}
+	}
+
+	var errs []error
+
+	if ocispec.Root == nil || ocispec.Root.Path == "" {
+		errs = append(errs, fmt.Errorf("root: path cannot be empty"))
+	}
+
+	if ocispec.Process == nil || len(ocispec.Process.Args) == 0 {
+		errs = append(errs, fmt.Errorf("process: args cannot be empty"))
+	}
+
+	if ocispec.Process != nil {
+		for _, env := range ocispec.Process.Env {
+			if _, err := EnvVars([]string{env}); err != nil {
+				errs = append(errs, fmt.Errorf("process: %v", err))
+			}
+		}
+	}
+
+	if ocispec.Linux == nil {
+		errs = append(errs, ErrNoLinux)
+	} else {
+		for _, d := range ocispec.Linux.Devices {
+			if _, err := newLinuxDeviceInfo(d); err != nil {
+				errs = append(errs, fmt.Errorf("linux: %v", err))
+			} else if err := validateDeviceNumbers(d.Major, d.Minor); err != nil {
+				errs = append(errs, fmt.Errorf("linux: device %s: %v", d.Path, err))
+			}
+		}
+	}
+
+	if _, err := ContainerType(ocispec); err != nil {
+		errs = append(errs, fmt.Errorf("annotations: %v", err))
+	}
+
+	if hooks, err := bundleHooks(ocispec, bundlePath); err != nil {
+		errs = append(errs, fmt.Errorf("hooks: %v", err))
+	} else if err := validateHookTimeouts(hooks); err != nil {
+		errs = append(errs, fmt.Errorf("hooks: %v", err))
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+
+	// The resources are optional in the spec, but expected by the conversion.
+	if ocispec.Linux.Resources == nil {
+		ocispec.Linux.Resources = &specs.LinuxResources{}
+	}
+
+	cid := filepath.Base(bundlePath)
+	containerConfig, err := ContainerConfig(ocispec, bundlePath, cid, "", false)
+	if err != nil {
+		return []error{err}
+	}
+
+	sandboxConfig := vc.SandboxConfig{
+		ID:         cid,
+		Hostname:   ocispec.Hostname,
+		Containers: []vc.ContainerConfig{containerConfig},
+	}
+
+	return sandboxConfig.ValidateAll()
+}
+
+// validateShmSize checks that the shm size doesn't exceed the given fraction
+// of the guest memory, which would make the shm mount fail in the guest.
+// DefaultShmMemoryFraction is used when the fraction is not set, and the
+// check is skipped when the guest memory is unknown.
+func validateShmSize(shmSize uint64, memoryMiB uint32, fraction float64) error {
+	if memoryMiB == 0 {
+		return nil
+	}
+
+	if fraction <= 0 {
+		fraction = DefaultShmMemoryFraction
+	}
+
+	memory := uint64(memoryMiB) << vcUtils.MibToBytesShift
+	if float64(shmSize) > fraction*float64(memory) {
+		return fmt.Errorf("shm size of %d bytes exceeds %g of the %d MiB guest memory, please raise the guest memory",
+			shmSize, fraction, memoryMiB)
+	}
+
+	return nil
+}
+
+// ContainerConfig converts an OCI compatible runtime configuration
+// file to a virtcontainers container configuration structure.
+func ContainerConfig(ocispec specs.Spec, bundlePath, cid, console string, detach bool) (vc.ContainerConfig, error) {
+	hooks, err := bundleHooks(ocispec, bundlePath)
+	if err != nil {
+		return vc.ContainerConfig{}, err
+	}
+
+	if err := validateHookTimeouts(hooks); err != nil {
+		return vc.ContainerConfig{}, err
+	}
+
+	unified, err := bundleUnifiedResources(bundlePath)
+	if err != nil {
+		return vc.ContainerConfig{}, err
+	}
+
+	rootfs := vc.RootFs{Target: ocispec.Root.Path, Mounted: true}
+	if !filepath.IsAbs(rootfs.Target) {
+		rootfs.Target = filepath.Join(bundlePath, ocispec.Root.Path)
+	}
+
+	ociLog.Debugf("container rootfs: %s", rootfs.Target)
+
+	// The process is copied so that the default PATH only applies to the
+	// spec passed to the agent.
+	if !hasEnv(ocispec.Process.Env, "PATH") {
+		process := *ocispec.Process
+		process.Env = append(append([]string{}, process.Env...), "PATH="+DefaultPATH)
+		ocispec.Process = &process
+	}
+
+	cmd := types.Cmd{
+		Args:            ocispec.Process.Args,
+		Envs:            cmdEnvs(ocispec, []types.EnvVar{}),
+		WorkDir:         ocispec.Process.Cwd,
+		User:            strconv.FormatUint(uint64(ocispec.Process.User.UID), 10),
+		PrimaryGroup:    strconv.FormatUint(uint64(ocispec.Process.User.GID), 10),
+		Interactive:     ocispec.Process.Terminal,
+		Console:         console,
+		Detach:          detach,
+		NoNewPrivileges: ocispec.Process.NoNewPrivileges,
+	}
+
+	cmd.SupplementaryGroups = []string{}
+	for _, gid := range ocispec.Process.User.AdditionalGids {
+		cmd.SupplementaryGroups = append(cmd.SupplementaryGroups, strconv.FormatUint(uint64(gid), 10))
+	}
+
+	deviceInfos, err := containerDeviceInfos(ocispec)
+	if err != nil {
+		return vc.ContainerConfig{}, err
+	}
+
+	if len(ocispec.Process.Args) > 0 {
+		if err := validateCommandPath(ocispec.Process.Args[0]); err != nil {
+			ociLog.WithField("container", cid).WithError(err).Warn("Suspicious container command")
+		}
+	}
+
+	if err := validateResolvConf(ocispec); err != nil {
+		ociLog.WithField("container", cid).WithError(err).Warn("Container may not resolve names")
+	}
+
+	if err := validateSchedPolicy(ocispec.Annotations); err != nil {
+		return vc.ContainerConfig{}, err
+	}
+
+	if value, ok := ocispec.Annotations[vcAnnotations.DisableDevpts]; ok {
+		disableDevpts, err := parseBoolAnnotation(vcAnnotations.DisableDevpts, value)
+		if err != nil {
+			return vc.ContainerConfig{}, err
+		}
+
+		if disableDevpts {
+			ocispec.Mounts = withoutDevptsMounts(ocispec.Mounts)
+		}
+	}
+
+	// The CPU shares are carried as is in the container resources, the
+	// agent converts them into a CPU weight on cgroup v2 hosts.
+	if cpu := ocispec.Linux.Resources.CPU; cpu != nil && cpu.Shares != nil && *cpu.Shares < minCPUShares {
+		return vc.ContainerConfig{}, fmt.Errorf("Invalid CPU shares %d: expecting at least %d", *cpu.Shares, minCPUShares)
+	}
+
+	if ocispec.Process != nil {
+		cmd.Capabilities = ocispec.Process.Capabilities
+
+		// Ambient capabilities cannot be raised when no new privileges
+		// can be gained. Rather than failing, the contradictory ambient
+		// set is cleared, leaving the spec untouched.
+		if caps := cmd.Capabilities; caps != nil && cmd.NoNewPrivileges && len(caps.Ambient) > 0 {
+			ociLog.WithField("ambient", caps.Ambient).Warn("Clearing ambient capabilities since noNewPrivileges is set")
+
+			capsCopy := *caps
+			capsCopy.Ambient = nil
+			cmd.Capabilities = &capsCopy
+		}
+
+		// The process is copied so that the default RLIMIT_NOFILE
+		// only applies to the spec passed to the agent.
+		if !hasRlimit(ocispec.Process.Rlimits, DefaultRlimitNofile.Type) {
+			process := *ocispec.Process
+			process.Rlimits = append(append([]specs.POSIXRlimit{}, process.Rlimits...), DefaultRlimitNofile)
+			ocispec.Process = &process
+		}
+
+		cmd.Rlimits = cmdRlimits(ocispec.Process.Rlimits)
+
+		if adj := ocispec.Process.OOMScoreAdj; adj != nil {
+			if *adj < minOOMScoreAdj || *adj > maxOOMScoreAdj {
+				return vc.ContainerConfig{}, fmt.Errorf("Invalid OOM score adjustment %d: expecting a value between %d and %d",
+					*adj, minOOMScoreAdj, maxOOMScoreAdj)
+			}
+
+			oomScoreAdj := *adj
+			cmd.OOMScoreAdj = &oomScoreAdj
+		}
+	}
+
+	mounts, err := containerMounts(ocispec)
+	if err != nil {
+		return vc.ContainerConfig{}, err
+	}
+
+	readonlyRootfs := ocispec.Root.Readonly
+	if value, ok := ocispec.Annotations[vcAnnotations.RootfsReadonly]; ok {
+		readonlyRootfs, err = parseBoolAnnotation(vcAnnotations.RootfsReadonly, value)
+		if err != nil {
+			return vc.ContainerConfig{}, err
+		}
+	}
+
+	// DisableOOMKiller is carried as is: the resources are applied by the
+	// agent to the guest cgroup v1 hierarchy, whatever the host cgroup mode,
+	// and the guest sets memory.oom_control.
+	containerConfig := vc.ContainerConfig{
+		ID:             cid,
+		RootFs:         rootfs,
+		ReadonlyRootfs: readonlyRootfs,
+		Cmd:            cmd,
+		Annotations: map[string]string{
+			vcAnnotations.BundlePathKey: bundlePath,
+		},
+		Mounts:           mounts,
+		DeviceInfos:      deviceInfos,
+		Resources:        *ocispec.Linux.Resources,
+		UnifiedResources: unified,
+		Spec:             &ocispec,
+	}
+
+	// The runtime configuration is unknown here, SandboxConfig rejecting
+	// the unknown capabilities in strict mode.
+	if err := ValidateCapabilities(containerConfig.Cmd.Capabilities); err != nil {
+		ociLog.WithField("container", cid).WithError(err).Warn("Ignoring unknown capabilities")
+	}
+
+	if value, ok := ocispec.Annotations[vcAnnotations.CPUAffinity]; ok {
+		cpus, err := parseCPUList(value)
+		if err != nil {
+			return vc.ContainerConfig{}, fmt.Errorf("Invalid value %q for annotation %s: %v", value, vcAnnotations.CPUAffinity, err)
+		}
+
+		containerConfig.CPUAffinity = cpus
+	}
+
+	if permissiveDevices(ocispec.Linux.Resources.Devices) {
+		ociLog.WithField("container", cid).Warn("container is allowed to access all devices")
+		containerConfig.PermissiveDevices = true
+	}
+
+	cType, err := ContainerType(ocispec)
+	if err != nil {
+		return vc.ContainerConfig{}, err
+	}
+
+	containerConfig.Annotations[vcAnnotations.ContainerTypeKey] = string(cType)
+
+	return containerConfig, nil
+}
+
+// parseCPUList parses a comma separated list of CPUs and CPU ranges, such as
+// "0-3,7", and returns the sorted list of the CPUs without duplicates.
+func parseCPUList(list string) ([]int, error) {
+	set := make(map[int]bool)
+
+	for _, item := range strings.Split(list, ",") {
+		bounds := strings.SplitN(strings.TrimSpace(item), "-", 2)
+
+		first, err := strconv.ParseUint(bounds[0], 10, 16)
+		if err != nil {
+			return nil, fmt.Errorf("expecting a CPU number or range, got %q", item)
+		}
+
+		last := first
+		if len(bounds) == 2 {
+			last, err = strconv.ParseUint(bounds[1], 10, 16)
+			if err != nil {
+				return nil, fmt.Errorf("expecting a CPU number or range, got %q", item)
+			}
+
+			if last < first {
+				return nil, fmt.Errorf("CPU range %q ends before it starts", item)
+			}
+		}
+
+		for cpu := first; cpu <= last; cpu++ {
+			set[int(cpu)] = true
+		}
 	}
 
-	if value, ok := ocispec.Annotations[vcAnnotations.KernelModules]; ok {
-		if c, ok := config.AgentConfig.(vc.KataAgentConfig); ok {
-			modules := strings.Split(value, KernelModulesSeparator)
-			c.KernelModules = modules
-			config.AgentConfig = c
+	cpus := make([]int, 0, len(set))
+	for cpu := range set {
+		cpus = append(cpus, cpu)
+	}
+	sort.Ints(cpus)
+
+	return cpus, nil
+}
+
+// ValidateCapabilities checks that every capability of the capability sets
+// is a known Linux capability, as told by types.IsKnownCapability.
+// All the unknown names are reported in a single error, since the guest would
+// silently ignore them.
+func ValidateCapabilities(caps *specs.LinuxCapabilities) error {
+	if caps == nil {
+		return nil
+	}
+
+	var unknown []string
+	for _, set := range [][]string{caps.Bounding, caps.Effective, caps.Inheritable, caps.Permitted, caps.Ambient} {
+		for _, c := range set {
+			if !types.IsKnownCapability(c) && !contains(unknown, c) {
+				unknown = append(unknown, c)
+			}
 		}
 	}
+
+	if len(unknown) > 0 {
+		return fmt.Errorf("Unknown capabilities: %s", strings.Join(unknown, ", "))
+	}
+
+	return nil
 }
 
-// SandboxConfig converts an OCI compatible runtime configuration file
-// to a virtcontainers sandbox configuration structure.
-func SandboxConfig(ocispec specs.Spec, runtime RuntimeConfig, bundlePath, cid, console string, detach, systemdCgroup bool) (vc.SandboxConfig, error) {
-	containerConfig, err := ContainerConfig(ocispec, bundlePath, cid, console, detach)
-	if err != nil {
-		return vc.SandboxConfig{}, err
+// criSandbox tells if the CRI annotations mark the container as a pod
+// sandbox. Unlike ContainerType, it doesn't consider a container without
+// CRI annotations as a sandbox.
+func criSandbox(annotations map[string]string) bool {
+	for _, key := range CRIContainerTypeKeyList {
+		value, ok := annotations[key]
+		if !ok {
+			continue
+		}
+
+		for _, t := range CRIContainerTypeList {
+			if t.annotation == value {
+				return t.containerType == vc.PodSandbox
+			}
+		}
+
+		return false
 	}
 
-	shmSize, err := getShmSize(containerConfig)
-	if err != nil {
-		return vc.SandboxConfig{}, err
+	return false
+}
+
+// dropSandboxCapabilities leaves the CRI sandbox container, whose pause
+// process only waits for signals, without any capability. The process of
+// the spec is copied, so that the caller spec is left untouched. Other
+// containers, including the single container of a sandbox created without
+// CRI, which runs the user workload, are not modified.
+func dropSandboxCapabilities(containerConfig *vc.ContainerConfig) {
+	if containerConfig.Spec == nil || !criSandbox(containerConfig.Spec.Annotations) {
+		return
 	}
 
-	networkConfig, err := networkConfig(ocispec, runtime)
-	if err != nil {
-		return vc.SandboxConfig{}, err
+	caps := &specs.LinuxCapabilities{
+		Bounding:    []string{},
+		Effective:   []string{},
+		Inheritable: []string{},
+		Permitted:   []string{},
+		Ambient:     []string{},
 	}
 
-	sandboxConfig := vc.SandboxConfig{
-		ID: cid,
+	containerConfig.Cmd.Capabilities = caps
 
-		Hostname: ocispec.Hostname,
+	if spec := containerConfig.Spec; spec != nil && spec.Process != nil {
+		process := *spec.Process
+		process.Capabilities = caps
+		spec.Process = &process
+	}
+}
 
-		HypervisorType:   runtime.HypervisorType,
-		HypervisorConfig: runtime.HypervisorConfig,
+// ValidateDeviceAccess checks that the device cgroup rules of the container
+// give access to every device of the container, unless they are permissive.
+// As with the device cgroup, the rules apply in order and the last rule
+// matching a device decides. All the devices lacking access are reported in
+// a single error.
+func ValidateDeviceAccess(c vc.ContainerConfig) error {
+	if c.PermissiveDevices {
+		return nil
+	}
 
-		AgentType:   runtime.AgentType,
-		AgentConfig: runtime.AgentConfig,
+	var result *merr.Error
+	for _, d := range c.DeviceInfos {
+		// Unbuffered character devices are character devices for the
+		// device cgroup.
+		devType := d.DevType
+		if devType == "u" {
+			devType = "c"
+		}
 
-		ProxyType:   runtime.ProxyType,
-		ProxyConfig: runtime.ProxyConfig,
+		allowed := false
+		for _, r := range c.Resources.Devices {
+			if (r.Type != "" && r.Type != "a" && r.Type != devType) ||
+				(r.Major != nil && *r.Major != d.Major) ||
+				(r.Minor != nil && *r.Minor != d.Minor) {
+				continue
+			}
 
-		ShimType:   runtime.ShimType,
-		ShimConfig: runtime.ShimConfig,
+			// A rule only allowing mknod doesn't give access to the device.
+			if r.Access != "" && !strings.ContainsAny(r.Access, "rw") {
+				continue
+			}
 
-		NetworkConfig: networkConfig,
+			allowed = r.Allow
+		}
 
-		Containers: []vc.ContainerConfig{containerConfig},
+		if !allowed {
+			result = merr.Append(result, fmt.Errorf("device %s (%s %d:%d) is not allowed by the device cgroup rules",
+				d.ContainerPath, d.DevType, d.Major, d.Minor))
+		}
+	}
 
-		Annotations: map[string]string{
-			vcAnnotations.BundlePathKey: bundlePath,
-		},
+	return result.ErrorOrNil()
+}
 
-		ShmSize: shmSize,
+// permissiveDevices tells if the device cgroup rules end up allowing full
+// access to every device. The rules apply in order, so an allow-all wildcard
+// rule only counts when no deny rule follows it.
+func permissiveDevices(devices []specs.LinuxDeviceCgroup) bool {
+	permissive := false
+
+	for _, d := range devices {
+		wildcard := (d.Type == "" || d.Type == "a") && d.Major == nil && d.Minor == nil
+		fullAccess := d.Access == "" || (strings.Contains(d.Access, "r") &&
+			strings.Contains(d.Access, "w") && strings.Contains(d.Access, "m"))
+
+		if !d.Allow {
+			permissive = false
+		} else if wildcard && fullAccess {
+			permissive = true
+		}
+	}
 
-		SystemdCgroup: systemdCgroup,
+	return permissive
+}
 
-		SandboxCgroupOnly: runtime.SandboxCgroupOnly,
+// bundleHooks returns the hooks of the spec, along with the createRuntime,
+// createContainer and startContainer ones specs.Hooks doesn't know, read from
+// the config.json file of the bundle. A bundle without config.json only has
+// the hooks of the spec.
+func bundleHooks(ocispec specs.Spec, bundlePath string) (compatoci.Hooks, error) {
+	hooks, err := compatoci.ParseConfigJSONHooks(bundlePath)
+	if err != nil && !os.IsNotExist(err) {
+		return compatoci.Hooks{}, err
+	}
 
-		DisableGuestSeccomp: runtime.DisableGuestSeccomp,
+	hooks.Prestart, hooks.Poststart, hooks.Poststop = nil, nil, nil
+	if ocispec.Hooks != nil {
+		hooks.Prestart = ocispec.Hooks.Prestart
+		hooks.Poststart = ocispec.Hooks.Poststart
+		hooks.Poststop = ocispec.Hooks.Poststop
+	}
 
-		// Q: Is this really necessary? @weizhang555
-		// Spec: &ocispec,
+	return hooks, nil
+}
 
-		Experimental: runtime.Experimental,
+// bundleUnifiedResources returns the cgroup v2 unified resources read from
+// the config.json file of the bundle, checking they can be translated into
+// the cgroup v1 ones when the container is created. A bundle without
+// config.json has no unified resources.
+func bundleUnifiedResources(bundlePath string) (map[string]string, error) {
+	unified, err := compatoci.ParseConfigJSONUnifiedResources(bundlePath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
 	}
 
-	addAssetAnnotations(ocispec, &sandboxConfig)
+	if err := compatoci.ApplyUnifiedResources(&specs.Spec{}, unified); err != nil {
+		return nil, err
+	}
 
-	return sandboxConfig, nil
+	return unified, nil
 }
 
-// ContainerConfig converts an OCI compatible runtime configuration
-// file to a virtcontainers container configuration structure.
-func ContainerConfig(ocispec specs.Spec, bundlePath, cid, console string, detach bool) (vc.ContainerConfig, error) {
-	rootfs := vc.RootFs{Target: ocispec.Root.Path, Mounted: true}
-	if !filepath.IsAbs(rootfs.Target) {
-		rootfs.Target = filepath.Join(bundlePath, ocispec.Root.Path)
+// validateHookTimeouts checks the timeouts of the OCI hooks of every phase,
+// which are enforced when the hooks are run. A nil or zero timeout means the
+// hook is not bounded, while negative timeouts are rejected as malformed.
+func validateHookTimeouts(hooks compatoci.Hooks) error {
+	for _, phase := range []struct {
+		hookType string
+		list     []specs.Hook
+	}{
+		{"prestart", hooks.Prestart},
+		{"createRuntime", hooks.CreateRuntime},
+		{"createContainer", hooks.CreateContainer},
+		{"startContainer", hooks.StartContainer},
+		{"poststart", hooks.Poststart},
+		{"poststop", hooks.Poststop},
+	} {
+		for _, h := range phase.list {
+			if h.Timeout != nil && *h.Timeout < 0 {
+				return fmt.Errorf("Invalid timeout %d for %s hook %s: expecting a positive number of seconds",
+					*h.Timeout, phase.hookType, h.Path)
+			}
+		}
 	}
 
-	ociLog.Debugf("container rootfs: %s", rootfs.Target)
+	return nil
+}
 
-	cmd := types.Cmd{
-		Args:            ocispec.Process.Args,
-		Envs:            cmdEnvs(ocispec, []types.EnvVar{}),
-		WorkDir:         ocispec.Process.Cwd,
-		User:            strconv.FormatUint(uint64(ocispec.Process.User.UID), 10),
-		PrimaryGroup:    strconv.FormatUint(uint64(ocispec.Process.User.GID), 10),
-		Interactive:     ocispec.Process.Terminal,
-		Console:         console,
-		Detach:          detach,
-		NoNewPrivileges: ocispec.Process.NoNewPrivileges,
+// commandNameRegexp matches a bare command name, looked up in the PATH.
+var commandNameRegexp = regexp.MustCompile(`^[A-Za-z0-9._+-]+$`)
+
+// validateCommandPath checks that the command of the container looks like a
+// plausible binary path, as it cannot be checked against the image. It must
+// be an absolute path, a path relative to the working directory starting
+// with "./" or "../", or a bare command name. Since this is a heuristic, the
+// result is only meant to be logged.
+func validateCommandPath(command string) error {
+	switch {
+	case command == "":
+		return fmt.Errorf("command cannot be empty")
+	case strings.IndexFunc(command, unicode.IsSpace) >= 0:
+		return fmt.Errorf("command %q contains spaces, the arguments should be passed separately", command)
+	case filepath.IsAbs(command), strings.HasPrefix(command, "./"), strings.HasPrefix(command, "../"):
+		return nil
+	case strings.Contains(command, "/"):
+		return fmt.Errorf("command %q is a relative path, expecting an absolute path", command)
+	case !commandNameRegexp.MatchString(command):
+		return fmt.Errorf("command %q is not a valid command name", command)
 	}
 
-	cmd.SupplementaryGroups = []string{}
-	for _, gid := range ocispec.Process.User.AdditionalGids {
-		cmd.SupplementaryGroups = append(cmd.SupplementaryGroups, strconv.FormatUint(uint64(gid), 10))
+	return nil
+}
+
+// validateResolvConf checks that a container with network access has a
+// /etc/resolv.conf mount, unless the DNSConfigured annotation says its name
+// resolution is set up otherwise. A container in a new network namespace
+// only has a loopback interface, so it doesn't need one. Since the image
+// could still provide a resolv.conf, the result is only meant to be logged.
+func validateResolvConf(spec specs.Spec) error {
+	if NamespaceConfig(spec).Network == NamespacePrivate {
+		return nil
 	}
 
-	deviceInfos, err := containerDeviceInfos(ocispec)
-	if err != nil {
-		return vc.ContainerConfig{}, err
+	if value, ok := spec.Annotations[vcAnnotations.DNSConfigured]; ok {
+		dnsConfigured, err := parseBoolAnnotation(vcAnnotations.DNSConfigured, value)
+		if err != nil || dnsConfigured {
+			return err
+		}
 	}
 
-	if ocispec.Process != nil {
-		cmd.Capabilities = ocispec.Process.Capabilities
+	for _, m := range spec.Mounts {
+		if filepath.Clean(m.Destination) == "/etc/resolv.conf" {
+			return nil
+		}
 	}
 
-	containerConfig := vc.ContainerConfig{
-		ID:             cid,
-		RootFs:         rootfs,
-		ReadonlyRootfs: ocispec.Root.Readonly,
-		Cmd:            cmd,
-		Annotations: map[string]string{
-			vcAnnotations.BundlePathKey: bundlePath,
-		},
-		Mounts:      containerMounts(ocispec),
-		DeviceInfos: deviceInfos,
-		Resources:   *ocispec.Linux.Resources,
-		Spec:        &ocispec,
+	return fmt.Errorf("container has network access but no /etc/resolv.conf mount")
+}
+
+// validateSchedPolicy checks the scheduling policy annotations of the
+// container process. The agent process description has no scheduling
+// fields, so only the default "other" policy, with a 0 priority, is accepted
+// and the "fifo" and "rr" real-time policies are rejected.
+func validateSchedPolicy(annotations map[string]string) error {
+	policy, ok := annotations[vcAnnotations.SchedPolicy]
+	if !ok {
+		if _, ok := annotations[vcAnnotations.SchedPriority]; ok {
+			return fmt.Errorf("Annotation %s requires annotation %s", vcAnnotations.SchedPriority, vcAnnotations.SchedPolicy)
+		}
+		return nil
 	}
 
-	cType, err := ContainerType(ocispec)
-	if err != nil {
-		return vc.ContainerConfig{}, err
+	switch policy {
+	case "other":
+	case "fifo", "rr":
+		return fmt.Errorf("Scheduling policy %q of annotation %s is not supported by the agent", policy, vcAnnotations.SchedPolicy)
+	default:
+		return fmt.Errorf("Invalid value %q for annotation %s: expecting \"other\"", policy, vcAnnotations.SchedPolicy)
 	}
 
-	containerConfig.Annotations[vcAnnotations.ContainerTypeKey] = string(cType)
+	if value, ok := annotations[vcAnnotations.SchedPriority]; ok && value != "0" {
+		return fmt.Errorf("Invalid value %q for annotation %s: expecting 0 for the %s policy",
+			value, vcAnnotations.SchedPriority, policy)
+	}
 
-	return containerConfig, nil
+	return nil
+}
+
+// withoutDevptsMounts returns a copy of the mounts without the devpts ones.
+func withoutDevptsMounts(mounts []specs.Mount) []specs.Mount {
+	var filtered []specs.Mount
+	for _, m := range mounts {
+		if m.Type != "devpts" {
+			filtered = append(filtered, m)
+		}
+	}
+
+	return filtered
+}
+
+// hasEnv tells if the environment variable is set in the KEY=VALUE list.
+func hasEnv(envs []string, name string) bool {
+	for _, env := range envs {
+		if strings.SplitN(env, "=", 2)[0] == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// rlimitTypes lists the resource limits supported by Linux.
+var rlimitTypes = []string{
+	"RLIMIT_AS", "RLIMIT_CORE", "RLIMIT_CPU", "RLIMIT_DATA", "RLIMIT_FSIZE",
+	"RLIMIT_LOCKS", "RLIMIT_MEMLOCK", "RLIMIT_MSGQUEUE", "RLIMIT_NICE",
+	"RLIMIT_NOFILE", "RLIMIT_NPROC", "RLIMIT_RSS", "RLIMIT_RTPRIO",
+	"RLIMIT_RTTIME", "RLIMIT_SIGPENDING", "RLIMIT_STACK",
+}
+
+// cmdRlimits converts the OCI process rlimits, skipping the unknown ones
+// with a warning.
+func cmdRlimits(rlimits []specs.POSIXRlimit) []types.Rlimit {
+	var cmdRlimits []types.Rlimit
+
+	for _, r := range rlimits {
+		if !contains(rlimitTypes, r.Type) {
+			ociLog.WithField("rlimit", r.Type).Warn("Skipping unknown rlimit")
+			continue
+		}
+
+		cmdRlimits = append(cmdRlimits, types.Rlimit{
+			Type: r.Type,
+			Soft: r.Soft,
+			Hard: r.Hard,
+		})
+	}
+
+	return cmdRlimits
+}
+
+// hasRlimit returns true if the rlimits include the limit of the given type.
+func hasRlimit(rlimits []specs.POSIXRlimit, rlimitType string) bool {
+	for _, r := range rlimits {
+		if r.Type == rlimitType {
+			return true
+		}
+	}
+
+	return false
 }
 
+// getShmSize returns the shm size of the container, vc.DefaultShmSize being
+// used for a tmpfs /dev/shm mount without size.
 func getShmSize(c vc.ContainerConfig) (uint64, error) {
+	return GetShmSize(c, vc.DefaultShmSize)
+}
+
+// GetShmSize returns the size of the /dev/shm mount of the container, or 0
+// when there is none. The size of a tmpfs mount is given by its "size="
+// option, defaultSize being used when it is not set, relative to the memory
+// or invalid, and the size of a bind mount is the one of the mounted file
+// system.
+func GetShmSize(c vc.ContainerConfig, defaultSize uint64) (uint64, error) {
 	var shmSize uint64
 
 	for _, m := range c.Mounts {
@@ -479,7 +2393,7 @@ func getShmSize(c vc.ContainerConfig) (uint64, error) {
 			continue
 		}
 
-		shmSize = vc.DefaultShmSize
+		shmSize = defaultSize
 
 		if m.Type == "bind" && m.Source != "/dev/shm" {
 			var s syscall.Statfs_t
@@ -489,6 +2403,23 @@ func getShmSize(c vc.ContainerConfig) (uint64, error) {
 			}
 			shmSize = uint64(s.Bsize) * s.Blocks
 		}
+
+		if m.Type == "tmpfs" {
+			for _, o := range m.Options {
+				value := strings.TrimPrefix(o, "size=")
+				if value == o || strings.HasSuffix(value, "%") {
+					continue
+				}
+
+				size, err := vcUtils.ParseMemorySize(value)
+				if err != nil {
+					ociLog.WithError(err).Warnf("Ignoring invalid %s option of the /dev/shm mount", o)
+					shmSize = defaultSize
+					continue
+				}
+				shmSize = size
+			}
+		}
 		break
 	}
 
@@ -498,14 +2429,25 @@ func getShmSize(c vc.ContainerConfig) (uint64, error) {
 }
 
 // StatusToOCIState translates a virtcontainers container status into an OCI state.
+// The exit code of a stopped container, when known, is added to a copy of the
+// annotations, under the ExitCodeKey annotation.
 func StatusToOCIState(status vc.ContainerStatus) specs.State {
+	annotations := status.Annotations
+	if status.State.State == types.StateStopped && status.ExitCode != nil {
+		annotations = make(map[string]string, len(status.Annotations)+1)
+		for k, v := range status.Annotations {
+			annotations[k] = v
+		}
+		annotations[vcAnnotations.ExitCodeKey] = strconv.FormatInt(int64(*status.ExitCode), 10)
+	}
+
 	return specs.State{
 		Version:     specs.Version,
 		ID:          status.ID,
 		Status:      StateToOCIState(status.State.State),
 		Pid:         status.PID,
 		Bundle:      status.Annotations[vcAnnotations.BundlePathKey],
-		Annotations: status.Annotations,
+		Annotations: annotations,
 	}
 }
 
@@ -527,6 +2469,9 @@ func StateToOCIState(state types.StateString) string {
 
 // EnvVars converts an OCI process environment variables slice
 // into a virtcontainers EnvVar slice.
+// Leading and trailing spaces and single quotes are trimmed from the values,
+// but double quotes are preserved exactly: TERM="bar" results in the "bar"
+// value, quotes included. Use EnvVarsUnquote for shell-style unquoting.
 func EnvVars(envs []string) ([]types.EnvVar, error) {
 	var envVars []types.EnvVar
 
@@ -558,6 +2503,166 @@ func EnvVars(envs []string) ([]types.EnvVar, error) {
 	return envVars, nil
 }
 
+// EnvVarsWithLimits converts an OCI process environment variables slice
+// like EnvVars does, but fails when the environment holds more than
+// maxCount variables or more than maxSize bytes, counted as execve(2) does
+// with a terminating NUL per variable. Pathologically large environments
+// slow down every exec in the guest. A zero limit is not enforced.
+func EnvVarsWithLimits(envs []string, maxCount, maxSize int) ([]types.EnvVar, error) {
+	if maxCount > 0 && len(envs) > maxCount {
+		return []types.EnvVar{}, fmt.Errorf("Too many environment variables: %d, expecting at most %d",
+			len(envs), maxCount)
+	}
+
+	if maxSize > 0 {
+		size := 0
+		for _, env := range envs {
+			size += len(env) + 1
+		}
+
+		if size > maxSize {
+			return []types.EnvVar{}, fmt.Errorf("Environment too large: %d bytes, expecting at most %d",
+				size, maxSize)
+		}
+	}
+
+	return EnvVars(envs)
+}
+
+// unquoteEnvValue removes the double quotes surrounding a value, following
+// the shell rules: within the quotes, a backslash only escapes '"', '\',
+// '$' and '`'.
+func unquoteEnvValue(value string) string {
+	if len(value) < 2 || value[0] != '"' || value[len(value)-1] != '"' {
+		return value
+	}
+
+	quoted := value[1 : len(value)-1]
+
+	var b strings.Builder
+	for i := 0; i < len(quoted); i++ {
+		if quoted[i] == '\\' && i+1 < len(quoted) && strings.IndexByte("\"\\$`", quoted[i+1]) >= 0 {
+			i++
+		}
+		b.WriteByte(quoted[i])
+	}
+
+	return b.String()
+}
+
+// EnvVarsUnquote converts an OCI process environment variables slice into
+// a virtcontainers EnvVar slice, like EnvVars, and additionally removes the
+// double quotes surrounding the values, shell-style: TERM="bar" results in
+// the bar value.
+func EnvVarsUnquote(envs []string) ([]types.EnvVar, error) {
+	envVars, err := EnvVars(envs)
+	if err != nil {
+		return []types.EnvVar{}, err
+	}
+
+	for i := range envVars {
+		envVars[i].Value = unquoteEnvValue(envVars[i].Value)
+	}
+
+	return envVars, nil
+}
+
+// EnvVarsDedup converts an OCI process environment variables slice like
+// EnvVars does, but only keeps the last occurrence of each variable, as a
+// shell would. The remaining variables keep their relative order.
+func EnvVarsDedup(envs []string) ([]types.EnvVar, error) {
+	envVars, err := EnvVars(envs)
+	if err != nil {
+		return []types.EnvVar{}, err
+	}
+
+	last := make(map[string]int, len(envVars))
+	for i, env := range envVars {
+		last[env.Var] = i
+	}
+
+	var deduped []types.EnvVar
+	for i, env := range envVars {
+		if last[env.Var] == i {
+			deduped = append(deduped, env)
+		}
+	}
+
+	return deduped, nil
+}
+
+// EnvVarsFromFile reads a Docker style environment file into a
+// virtcontainers EnvVar slice. Blank lines and lines starting with '#' are
+// skipped, KEY=VALUE lines set the value verbatim, and bare KEY lines take
+// the value of the variable from the host environment, being skipped when
+// it is not set there. Malformed lines are reported with their line number.
+func EnvVarsFromFile(path string) ([]types.EnvVar, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return []types.EnvVar{}, err
+	}
+	defer f.Close()
+
+	var envVars []types.EnvVar
+
+	scanner := bufio.NewScanner(f)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimLeft(scanner.Text(), " \t")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.SplitN(line, "=", 2)
+		name := fields[0]
+		if name == "" || strings.ContainsAny(name, " \t") {
+			return []types.EnvVar{}, fmt.Errorf("%s:%d: invalid environment variable name %q", path, lineNum, name)
+		}
+
+		if len(fields) == 1 {
+			value, ok := os.LookupEnv(name)
+			if !ok {
+				continue
+			}
+			fields = append(fields, value)
+		}
+
+		envVars = append(envVars, types.EnvVar{
+			Var:   name,
+			Value: fields[1],
+		})
+	}
+
+	if err := scanner.Err(); err != nil {
+		return []types.EnvVar{}, err
+	}
+
+	return envVars, nil
+}
+
+// EnvVarsToStrings converts a virtcontainers EnvVar slice back into a
+// KEY=VALUE slice, the inverse of EnvVars. When a variable is defined
+// several times the last definition wins, and the result is sorted by key
+// so that it is deterministic.
+func EnvVarsToStrings(envs []types.EnvVar) []string {
+	values := make(map[string]string, len(envs))
+	for _, env := range envs {
+		values[env.Var] = env.Value
+	}
+
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	envStrings := make([]string, 0, len(keys))
+	for _, key := range keys {
+		envStrings = append(envStrings, key+"="+values[key])
+	}
+
+	return envStrings
+}
+
 // GetOCIConfig returns an OCI spec configuration from the annotation
 // stored into the container status.
 func GetOCIConfig(status vc.ContainerStatus) (specs.Spec, error) {
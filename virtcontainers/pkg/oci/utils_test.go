@@ -6,6 +6,7 @@
 package oci
 
 import (
+	"context"
 	"fmt"
 	"io/ioutil"
 	"os"
@@ -14,6 +15,7 @@ import (
 	"runtime"
 	"strconv"
 	"strings"
+	"syscall"
 	"testing"
 
 	"github.com/cri-o/cri-o/pkg/annotations"
@@ -98,7 +100,13 @@ func TestMinimalSandboxConfig(t *testing.T) {
 			Effective:   capList,
 			Inheritable: capList,
 			Permitted:   capList,
-			Ambient:     capList,
+		},
+		Rlimits: []types.Rlimit{
+			{
+				Type: "RLIMIT_NOFILE",
+				Soft: 1024,
+				Hard: 1024,
+			},
 		},
 	}
 
@@ -116,6 +124,7 @@ func TestMinimalSandboxConfig(t *testing.T) {
 			Type:        "tmpfs",
 			Options:     []string{"nosuid", "strictatime", "mode=755", "size=65536k"},
 			HostPath:    "",
+			SizeBytes:   65536 << 10,
 		},
 		{
 			Source:      "devpts",
@@ -293,8 +302,26 @@ func TestStatusToOCIStateSuccessfulWithStoppedState(t *testing.T) {
 		Annotations: containerAnnotations,
 	}
 
+	// unknown exit code
+	testStatusToOCIStateSuccessful(t, cStatus, expected)
+
+	exitCode := int32(0)
+	cStatus.ExitCode = &exitCode
+	expected.Annotations = map[string]string{
+		vcAnnotations.BundlePathKey: tempBundlePath,
+		vcAnnotations.ExitCodeKey:   "0",
+	}
+
 	testStatusToOCIStateSuccessful(t, cStatus, expected)
 
+	// killed by SIGKILL
+	exitCode = 128 + int32(syscall.SIGKILL)
+	expected.Annotations[vcAnnotations.ExitCodeKey] = "137"
+
+	testStatusToOCIStateSuccessful(t, cStatus, expected)
+
+	// the status annotations are left untouched
+	assert.Equal(t, map[string]string{vcAnnotations.BundlePathKey: tempBundlePath}, containerAnnotations)
 }
 
 func TestStatusToOCIStateSuccessfulWithNoState(t *testing.T) {
@@ -470,6 +497,67 @@ func TestContainerTypeFailure(t *testing.T) {
 	assert.Equal(containerType, expected)
 }
 
+func TestContainerTypeAnnotationKeys(t *testing.T) {
+	assert := assert.New(t)
+
+	data := []struct {
+		annotations map[string]string
+		expected    vc.ContainerType
+	}{
+		{map[string]string{annotations.ContainerType: annotations.ContainerTypeSandbox}, vc.PodSandbox},
+		{map[string]string{annotations.ContainerType: annotations.ContainerTypeContainer}, vc.PodContainer},
+		{map[string]string{"io.kubernetes.cri.container-type": "sandbox"}, vc.PodSandbox},
+		{map[string]string{"io.kubernetes.cri.container-type": "container"}, vc.PodContainer},
+		{map[string]string{vcAnnotations.ContainerTypeKey: string(vc.PodSandbox)}, vc.PodSandbox},
+		{map[string]string{vcAnnotations.ContainerTypeKey: string(vc.PodContainer)}, vc.PodContainer},
+		// cri-o wins over containerd, which wins over virtcontainers
+		{
+			map[string]string{
+				annotations.ContainerType:          annotations.ContainerTypeContainer,
+				"io.kubernetes.cri.container-type": "sandbox",
+				vcAnnotations.ContainerTypeKey:     string(vc.PodSandbox),
+			},
+			vc.PodContainer,
+		},
+		{
+			map[string]string{
+				"io.kubernetes.cri.container-type": "container",
+				vcAnnotations.ContainerTypeKey:     string(vc.PodSandbox),
+			},
+			vc.PodContainer,
+		},
+	}
+
+	for _, d := range data {
+		containerType, err := ContainerType(specs.Spec{Annotations: d.annotations})
+		assert.NoError(err, "%v", d.annotations)
+		assert.Equal(d.expected, containerType, "%v", d.annotations)
+
+		containerType, err = GetContainerType(d.annotations)
+		assert.NoError(err, "%v", d.annotations)
+		assert.Equal(d.expected, containerType, "%v", d.annotations)
+	}
+
+	a := map[string]string{vcAnnotations.ContainerTypeKey: "InvalidType"}
+	_, err := ContainerType(specs.Spec{Annotations: a})
+	assert.Error(err)
+	_, err = GetContainerType(a)
+	assert.Error(err)
+}
+
+func BenchmarkContainerType(b *testing.B) {
+	spec := specs.Spec{
+		Annotations: map[string]string{
+			"io.kubernetes.cri.container-type": "container",
+			vcAnnotations.BundlePathKey:        tempBundlePath,
+		},
+	}
+
+	for i := 0; i < b.N; i++ {
+		ContainerType(spec)
+	}
+}
+
 func TestSandboxIDSuccessful(t *testing.T) {
 	var ociSpec specs.Spec
 	testSandboxID := "testSandboxID"
@@ -484,6 +572,37 @@ func TestSandboxIDSuccessful(t *testing.T) {
 	assert.Equal(sandboxID, testSandboxID)
 }
 
+func TestGetSandboxIDForContainer(t *testing.T) {
+	assert := assert.New(t)
+
+	// a sandbox
+	sandboxID, isSandbox := GetSandboxIDForContainer(map[string]string{
+		annotations.ContainerType: annotations.ContainerTypeSandbox,
+		annotations.SandboxID:     "sandbox",
+	})
+	assert.True(isSandbox)
+	assert.Equal("sandbox", sandboxID)
+
+	sandboxID, isSandbox = GetSandboxIDForContainer(nil)
+	assert.True(isSandbox)
+	assert.Empty(sandboxID)
+
+	// a container of the sandbox
+	sandboxID, isSandbox = GetSandboxIDForContainer(map[string]string{
+		"io.kubernetes.cri.container-type": "container",
+		"io.kubernetes.cri.sandbox-id":     "sandbox",
+	})
+	assert.False(isSandbox)
+	assert.Equal("sandbox", sandboxID)
+
+	// a container without sandbox ID
+	sandboxID, isSandbox = GetSandboxIDForContainer(map[string]string{
+		annotations.ContainerType: annotations.ContainerTypeContainer,
+	})
+	assert.False(isSandbox)
+	assert.Empty(sandboxID)
+}
+
 func TestSandboxIDFailure(t *testing.T) {
 	var ociSpec specs.Spec
 	assert := assert.New(t)
@@ -523,6 +642,42 @@ func TestAddKernelParamInvalid(t *testing.T) {
 	assert.Error(t, err)
 }
 
+func TestAddKernelParams(t *testing.T) {
+	var config RuntimeConfig
+	assert := assert.New(t)
+
+	existing := vc.Param{Key: "quiet"}
+	err := config.AddKernelParam(existing)
+	assert.NoError(err)
+
+	valid := []vc.Param{
+		{Key: "foo", Value: "bar"},
+		{Key: "baz"},
+	}
+
+	err = config.AddKernelParams(valid)
+	assert.NoError(err)
+	assert.Exactly(append([]vc.Param{existing}, valid...), config.HypervisorConfig.KernelParams)
+
+	// all or nothing
+	config.HypervisorConfig.KernelParams = []vc.Param{existing}
+	mixed := []vc.Param{
+		{Key: "foo", Value: "bar"},
+		{Key: "", Value: "first"},
+		{Key: "baz"},
+		{Key: "", Value: "second"},
+	}
+
+	err = config.AddKernelParams(mixed)
+	assert.Error(err)
+	assert.Contains(err.Error(), "kernel parameter 1")
+	assert.Contains(err.Error(), "kernel parameter 3")
+	assert.Exactly([]vc.Param{existing}, config.HypervisorConfig.KernelParams)
+
+	assert.NoError(config.AddKernelParams(nil))
+	assert.Exactly([]vc.Param{existing}, config.HypervisorConfig.KernelParams)
+}
+
 func TestDeviceTypeFailure(t *testing.T) {
 	var ociSpec specs.Spec
 
@@ -564,6 +719,136 @@ func TestDevicePathEmpty(t *testing.T) {
 	assert.NotNil(t, err, "This test should fail as path cannot be empty for device")
 }
 
+func TestDevicePathDuplicate(t *testing.T) {
+	assert := assert.New(t)
+
+	var ociSpec specs.Spec
+	ociSpec.Linux = &specs.Linux{
+		Devices: []specs.LinuxDevice{
+			{
+				Path:  "/dev/foo",
+				Type:  "c",
+				Major: 252,
+				Minor: 1,
+			},
+			{
+				Path:  "/dev/foo",
+				Type:  "b",
+				Major: 8,
+				Minor: 0,
+			},
+		},
+	}
+
+	_, err := containerDeviceInfos(ociSpec)
+	assert.Error(err)
+	assert.Contains(err.Error(), "Duplicate device path /dev/foo")
+
+	ociSpec.Linux.Devices[1].Path = "/dev/bar"
+	devices, err := containerDeviceInfos(ociSpec)
+	assert.NoError(err)
+	assert.Len(devices, 2)
+}
+
+func TestValidateDeviceAccess(t *testing.T) {
+	assert := assert.New(t)
+
+	major := int64(252)
+	minor := int64(1)
+
+	c := vc.ContainerConfig{
+		DeviceInfos: []config.DeviceInfo{
+			{ContainerPath: "/dev/foo", DevType: "c", Major: 252, Minor: 1},
+		},
+	}
+
+	// no allow rule
+	err := ValidateDeviceAccess(c)
+	assert.Error(err)
+	assert.Contains(err.Error(), "/dev/foo")
+
+	// the permissive flag grants access to every device
+	c.PermissiveDevices = true
+	assert.NoError(ValidateDeviceAccess(c))
+	c.PermissiveDevices = false
+
+	c.Resources.Devices = []specs.LinuxDeviceCgroup{
+		{Allow: false, Access: "rwm"},
+		{Allow: true, Type: "c", Major: &major, Minor: &minor, Access: "rwm"},
+	}
+	assert.NoError(ValidateDeviceAccess(c))
+
+	// a later deny rule wins
+	c.Resources.Devices = append(c.Resources.Devices, specs.LinuxDeviceCgroup{Allow: false, Type: "c", Major: &major, Access: "rwm"})
+	assert.Error(ValidateDeviceAccess(c))
+
+	// mknod only
+	c.Resources.Devices = []specs.LinuxDeviceCgroup{
+		{Allow: true, Type: "c", Major: &major, Minor: &minor, Access: "m"},
+	}
+	assert.Error(ValidateDeviceAccess(c))
+
+	// wrong type
+	c.Resources.Devices = []specs.LinuxDeviceCgroup{
+		{Allow: true, Type: "b", Major: &major, Minor: &minor, Access: "rw"},
+	}
+	assert.Error(ValidateDeviceAccess(c))
+
+	// only the devices lacking access are reported
+	c.Resources.Devices = []specs.LinuxDeviceCgroup{
+		{Allow: true, Type: "c", Major: &major, Access: "rw"},
+	}
+	c.DeviceInfos = append(c.DeviceInfos, config.DeviceInfo{ContainerPath: "/dev/bar", DevType: "b", Major: 8, Minor: 0})
+	err = ValidateDeviceAccess(c)
+	assert.Error(err)
+	assert.Contains(err.Error(), "/dev/bar")
+	assert.NotContains(err.Error(), "/dev/foo")
+}
+
+func TestValidateDeviceNumbers(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, d := range []struct {
+		major, minor int64
+		valid        bool
+	}{
+		{0, 0, true},
+		{252, 1, true},
+		{4095, 1048575, true},
+		{4096, 0, false},
+		{0, 1048576, false},
+		{-1, 0, false},
+		{0, -1, false},
+	} {
+		err := validateDeviceNumbers(d.major, d.minor)
+		if d.valid {
+			assert.NoError(err, "%d:%d", d.major, d.minor)
+		} else {
+			assert.Error(err, "%d:%d", d.major, d.minor)
+		}
+	}
+
+	var ociSpec specs.Spec
+	ociSpec.Linux = &specs.Linux{
+		Devices: []specs.LinuxDevice{
+			{
+				Path:  "/dev/foo",
+				Type:  "c",
+				Major: 252,
+				Minor: 1 << 20,
+			},
+		},
+	}
+
+	_, err := containerDeviceInfos(ociSpec)
+	assert.Error(err)
+
+	ociSpec.Linux.Devices[0].Minor = 1
+	devices, err := containerDeviceInfos(ociSpec)
+	assert.NoError(err)
+	assert.Len(devices, 1)
+}
+
 func TestGetShmSize(t *testing.T) {
 	containerConfig := vc.ContainerConfig{
 		Mounts: []vc.Mount{},
@@ -585,12 +870,69 @@ func TestGetShmSize(t *testing.T) {
 	assert.Nil(t, err)
 	assert.Equal(t, shmSize, uint64(vc.DefaultShmSize))
 
+	containerConfig.Mounts[0].Options = []string{"nosuid", "size=128Mi"}
+	shmSize, err = getShmSize(containerConfig)
+	assert.Nil(t, err)
+	assert.Equal(t, shmSize, uint64(128<<20))
+
+	// an invalid size falls back to the default
+	containerConfig.Mounts[0].Options = []string{"size=lots"}
+	shmSize, err = getShmSize(containerConfig)
+	assert.Nil(t, err)
+	assert.Equal(t, shmSize, uint64(vc.DefaultShmSize))
+
+	containerConfig.Mounts[0].Options = []string{"size=64m"}
+	shmSize, err = getShmSize(containerConfig)
+	assert.Nil(t, err)
+	assert.Equal(t, shmSize, uint64(64<<20))
+
+	containerConfig.Mounts[0].Options = nil
 	containerConfig.Mounts[0].Source = "/var/run/shared/shm"
 	containerConfig.Mounts[0].Type = "bind"
 	_, err = getShmSize(containerConfig)
 	assert.NotNil(t, err)
 }
 
+func TestGetShmSizeDefault(t *testing.T) {
+	assert := assert.New(t)
+
+	containerConfig := vc.ContainerConfig{
+		Mounts: []vc.Mount{
+			{
+				Source:      "shm",
+				Destination: "/dev/shm",
+				Type:        "tmpfs",
+				Options:     []string{"nosuid"},
+			},
+		},
+	}
+
+	shmSize, err := GetShmSize(containerConfig, 256<<20)
+	assert.NoError(err)
+	assert.Equal(uint64(256<<20), shmSize)
+
+	// the size option wins over the default
+	containerConfig.Mounts[0].Options = []string{"size=128Mi"}
+	shmSize, err = GetShmSize(containerConfig, 256<<20)
+	assert.NoError(err)
+	assert.Equal(uint64(128<<20), shmSize)
+
+	// a size relative to the memory falls back to the default
+	containerConfig.Mounts[0].Options = []string{"size=50%"}
+	shmSize, err = GetShmSize(containerConfig, 256<<20)
+	assert.NoError(err)
+	assert.Equal(uint64(256<<20), shmSize)
+
+	shmSize, err = getShmSize(containerConfig)
+	assert.NoError(err)
+	assert.Equal(uint64(vc.DefaultShmSize), shmSize)
+
+	// no /dev/shm mount
+	shmSize, err = GetShmSize(vc.ContainerConfig{}, 256<<20)
+	assert.NoError(err)
+	assert.Zero(shmSize)
+}
+
 func TestGetShmSizeBindMounted(t *testing.T) {
 	if os.Geteuid() != 0 {
 		t.Skip("Test disabled as requires root privileges")
@@ -654,7 +996,7 @@ func TestAddAssetAnnotations(t *testing.T) {
 		vcAnnotations.InitrdPath:    "/abc/rgb/initrd",
 		vcAnnotations.KernelHash:    "3l2353we871g",
 		vcAnnotations.ImageHash:     "52ss2550983",
-		vcAnnotations.AssetHashType: "sha",
+		vcAnnotations.AssetHashType: "sha512",
 	}
 
 	config := vc.SandboxConfig{
@@ -666,7 +1008,8 @@ func TestAddAssetAnnotations(t *testing.T) {
 		Annotations: expectedAnnotations,
 	}
 
-	addAssetAnnotations(ocispec, &config)
+	err := addAssetAnnotations(ocispec, &config)
+	assert.NoError(err)
 	assert.Exactly(expectedAnnotations, config.Annotations)
 
 	expectedAgentConfig := vc.KataAgentConfig{
@@ -677,7 +1020,2501 @@ func TestAddAssetAnnotations(t *testing.T) {
 	}
 
 	ocispec.Annotations[vcAnnotations.KernelModules] = strings.Join(expectedAgentConfig.KernelModules, KernelModulesSeparator)
-	addAssetAnnotations(ocispec, &config)
+	err = addAssetAnnotations(ocispec, &config)
+	assert.NoError(err)
 	assert.Exactly(expectedAgentConfig, config.AgentConfig)
 
 }
+
+func TestAddAssetAnnotationsWithDefaults(t *testing.T) {
+	assert := assert.New(t)
+
+	defaults := map[string]string{
+		vcAnnotations.KernelPath:    "/usr/share/kata/vmlinuz",
+		vcAnnotations.ImagePath:     "/usr/share/kata/kata.img",
+		vcAnnotations.KernelModules: "e1000e;i915 enable_ppgtt=0",
+	}
+
+	config := vc.SandboxConfig{
+		Annotations: make(map[string]string),
+		AgentConfig: vc.KataAgentConfig{},
+	}
+
+	// the spec wins, the defaults fill the gaps
+	ocispec := specs.Spec{
+		Annotations: map[string]string{
+			vcAnnotations.KernelPath:    "/abc/rgb/kernel",
+			vcAnnotations.KernelHash:    "3l2353we871g",
+			vcAnnotations.AssetHashType: "sha512",
+		},
+	}
+
+	err := addAssetAnnotationsWithDefaults(ocispec, &config, defaults)
+	assert.NoError(err)
+	assert.Exactly(map[string]string{
+		vcAnnotations.KernelPath:    "/abc/rgb/kernel",
+		vcAnnotations.KernelHash:    "3l2353we871g",
+		vcAnnotations.AssetHashType: "sha512",
+		vcAnnotations.ImagePath:     "/usr/share/kata/kata.img",
+	}, config.Annotations)
+	assert.Exactly(vc.KataAgentConfig{KernelModules: []string{"e1000e", "i915 enable_ppgtt=0"}}, config.AgentConfig)
+
+	// the kernel modules of the spec replace the default ones
+	ocispec.Annotations[vcAnnotations.KernelModules] = "virtio_net;vfio_pci ids=10de:1db6"
+	err = addAssetAnnotationsWithDefaults(ocispec, &config, defaults)
+	assert.NoError(err)
+	assert.Exactly(vc.KataAgentConfig{KernelModules: []string{"virtio_net", "vfio_pci ids=10de:1db6"}}, config.AgentConfig)
+
+	// no defaults
+	config = vc.SandboxConfig{
+		Annotations: make(map[string]string),
+		AgentConfig: vc.KataAgentConfig{},
+	}
+	delete(ocispec.Annotations, vcAnnotations.KernelModules)
+	err = addAssetAnnotationsWithDefaults(ocispec, &config, nil)
+	assert.NoError(err)
+	assert.Exactly(ocispec.Annotations, config.Annotations)
+	assert.Exactly(vc.KataAgentConfig{}, config.AgentConfig)
+}
+
+func TestAddAssetAnnotationsHashType(t *testing.T) {
+	assert := assert.New(t)
+
+	newConfig := func() vc.SandboxConfig {
+		return vc.SandboxConfig{
+			Annotations: make(map[string]string),
+			AgentConfig: vc.KataAgentConfig{},
+		}
+	}
+
+	ocispec := specs.Spec{
+		Annotations: map[string]string{
+			vcAnnotations.ImageHash:     "52ss2550983",
+			vcAnnotations.AssetHashType: vcAnnotations.SHA512,
+		},
+	}
+
+	config := newConfig()
+	assert.NoError(addAssetAnnotations(ocispec, &config))
+
+	// unknown hash type
+	for _, hashType := range []string{"sha", "SHA512", "md5", ""} {
+		config = newConfig()
+		ocispec.Annotations[vcAnnotations.AssetHashType] = hashType
+		err := addAssetAnnotations(ocispec, &config)
+		assert.Error(err, "hash type %q", hashType)
+		assert.Contains(err.Error(), vcAnnotations.SHA512)
+	}
+
+	// hash without a hash type
+	config = newConfig()
+	delete(ocispec.Annotations, vcAnnotations.AssetHashType)
+	err := addAssetAnnotations(ocispec, &config)
+	assert.Error(err)
+	assert.Contains(err.Error(), vcAnnotations.AssetHashType)
+
+	// the hash type is only checked along with a hash
+	config = newConfig()
+	ocispec.Annotations = map[string]string{vcAnnotations.AssetHashType: "md5"}
+	assert.NoError(addAssetAnnotations(ocispec, &config))
+}
+
+func TestAddHypervisorAnnotationsKdump(t *testing.T) {
+	assert := assert.New(t)
+
+	config := vc.SandboxConfig{
+		Annotations: make(map[string]string),
+	}
+
+	ocispec := specs.Spec{
+		Annotations: map[string]string{
+			vcAnnotations.EnableKdump: "true",
+		},
+	}
+
+	err := addHypervisorConfigOverrides(ocispec, &config)
+	assert.NoError(err)
+	assert.True(config.HypervisorConfig.EnableKdump)
+	assert.Exactly([]vc.Param{{Key: "crashkernel", Value: DefaultKdumpCrashKernelSize}}, config.HypervisorConfig.KernelParams)
+
+	config.HypervisorConfig = vc.HypervisorConfig{}
+	ocispec.Annotations[vcAnnotations.KdumpCrashKernelSize] = "128M@16M"
+	err = addHypervisorConfigOverrides(ocispec, &config)
+	assert.NoError(err)
+	assert.Exactly([]vc.Param{{Key: "crashkernel", Value: "128M@16M"}}, config.HypervisorConfig.KernelParams)
+
+	config.HypervisorConfig = vc.HypervisorConfig{}
+	ocispec.Annotations[vcAnnotations.EnableKdump] = "false"
+	err = addHypervisorConfigOverrides(ocispec, &config)
+	assert.NoError(err)
+	assert.False(config.HypervisorConfig.EnableKdump)
+	assert.Empty(config.HypervisorConfig.KernelParams)
+
+	ocispec.Annotations[vcAnnotations.EnableKdump] = "yes"
+	err = addHypervisorConfigOverrides(ocispec, &config)
+	assert.Error(err)
+
+	ocispec.Annotations[vcAnnotations.EnableKdump] = "true"
+	ocispec.Annotations[vcAnnotations.KdumpCrashKernelSize] = "lots"
+	err = addHypervisorConfigOverrides(ocispec, &config)
+	assert.Error(err)
+}
+
+func TestNewMountReadonlyBind(t *testing.T) {
+	assert := assert.New(t)
+
+	m := newMount(specs.Mount{
+		Source:      "/host/data",
+		Destination: "/data",
+		Type:        "bind",
+		Options:     []string{"rbind", "ro"},
+	})
+	assert.True(m.ReadOnly)
+	assert.Equal([]string{"rbind", "ro"}, m.Options)
+
+	m = newMount(specs.Mount{
+		Source:      "/host/data",
+		Destination: "/data",
+		Type:        "none",
+		Options:     []string{"ro", "nosuid", "bind"},
+	})
+	assert.True(m.ReadOnly)
+	assert.Equal([]string{"bind", "nosuid", "ro"}, m.Options)
+
+	m = newMount(specs.Mount{
+		Source:      "/host/data",
+		Destination: "/data",
+		Type:        "bind",
+		Options:     []string{"ro", "rbind", "rw"},
+	})
+	assert.False(m.ReadOnly)
+	assert.Equal([]string{"ro", "rbind", "rw"}, m.Options)
+
+	m = newMount(specs.Mount{
+		Source:      "proc",
+		Destination: "/proc",
+		Type:        "proc",
+		Options:     []string{"ro"},
+	})
+	assert.False(m.ReadOnly)
+	assert.Equal([]string{"ro"}, m.Options)
+}
+
+func TestAddRuntimeAnnotationsExposeKVM(t *testing.T) {
+	assert := assert.New(t)
+
+	sandboxConfig := vc.SandboxConfig{
+		Annotations: make(map[string]string),
+		Containers:  []vc.ContainerConfig{{ID: containerID}},
+	}
+
+	ocispec := specs.Spec{
+		Annotations: map[string]string{
+			vcAnnotations.ExposeKVM: "true",
+		},
+	}
+
+	// nested virtualization is not enabled
+	err := addRuntimeConfigOverrides(ocispec, &sandboxConfig)
+	assert.Error(err)
+
+	ocispec.Annotations[vcAnnotations.EnableNestedVirt] = "true"
+	err = addHypervisorConfigOverrides(ocispec, &sandboxConfig)
+	assert.NoError(err)
+	assert.True(sandboxConfig.HypervisorConfig.EnableNestedVirt)
+
+	err = addRuntimeConfigOverrides(ocispec, &sandboxConfig)
+	assert.NoError(err)
+
+	expectedDeviceInfos := []config.DeviceInfo{
+		{
+			ContainerPath: "/dev/kvm",
+			DevType:       "c",
+			Major:         10,
+			Minor:         232,
+		},
+	}
+	assert.Exactly(expectedDeviceInfos, sandboxConfig.Containers[0].DeviceInfos)
+	assert.Len(sandboxConfig.Containers[0].Resources.Devices, 1)
+	assert.True(sandboxConfig.Containers[0].Resources.Devices[0].Allow)
+
+	sandboxConfig.Containers[0] = vc.ContainerConfig{ID: containerID}
+	ocispec.Annotations[vcAnnotations.ExposeKVM] = "false"
+	err = addRuntimeConfigOverrides(ocispec, &sandboxConfig)
+	assert.NoError(err)
+	assert.Empty(sandboxConfig.Containers[0].DeviceInfos)
+
+	ocispec.Annotations[vcAnnotations.ExposeKVM] = "1"
+	err = addRuntimeConfigOverrides(ocispec, &sandboxConfig)
+	assert.Error(err)
+}
+
+func TestNamespaceConfig(t *testing.T) {
+	assert := assert.New(t)
+
+	ocispec := specs.Spec{
+		Linux: &specs.Linux{
+			Namespaces: []specs.LinuxNamespace{
+				{Type: specs.PIDNamespace},
+				{Type: specs.NetworkNamespace},
+				{Type: specs.MountNamespace},
+				{Type: specs.IPCNamespace},
+				{Type: specs.UTSNamespace},
+				{Type: specs.UserNamespace},
+				{Type: specs.CgroupNamespace},
+			},
+		},
+	}
+
+	expected := NamespaceSet{
+		PID:     NamespacePrivate,
+		Network: NamespacePrivate,
+		Mount:   NamespacePrivate,
+		IPC:     NamespacePrivate,
+		UTS:     NamespacePrivate,
+		User:    NamespacePrivate,
+		Cgroup:  NamespacePrivate,
+		Paths:   map[specs.LinuxNamespaceType]string{},
+	}
+	assert.Exactly(expected, NamespaceConfig(ocispec))
+
+	// Share the network namespace of another process and use the host
+	// IPC namespace.
+	ocispec.Linux.Namespaces = []specs.LinuxNamespace{
+		{Type: specs.PIDNamespace},
+		{Type: specs.NetworkNamespace, Path: "/proc/1234/ns/net"},
+		{Type: specs.MountNamespace},
+		{Type: specs.UTSNamespace},
+	}
+
+	expected = NamespaceSet{
+		PID:     NamespacePrivate,
+		Network: NamespaceShared,
+		Mount:   NamespacePrivate,
+		IPC:     NamespaceHost,
+		UTS:     NamespacePrivate,
+		User:    NamespaceHost,
+		Cgroup:  NamespaceHost,
+		Paths: map[specs.LinuxNamespaceType]string{
+			specs.NetworkNamespace: "/proc/1234/ns/net",
+		},
+	}
+	assert.Exactly(expected, NamespaceConfig(ocispec))
+
+	netConf, err := networkConfig(ocispec, RuntimeConfig{})
+	assert.NoError(err)
+	assert.Equal("/proc/1234/ns/net", netConf.NetNSPath)
+}
+
+func TestNetworkConfigNamespace(t *testing.T) {
+	assert := assert.New(t)
+
+	ocispec := specs.Spec{
+		Linux: &specs.Linux{
+			Namespaces: []specs.LinuxNamespace{
+				{Type: specs.PIDNamespace},
+				{Type: specs.NetworkNamespace, Path: "/var/run/netns/cni-1234"},
+			},
+		},
+	}
+
+	// an existing network namespace is joined
+	netConf, err := networkConfig(ocispec, RuntimeConfig{})
+	assert.NoError(err)
+	assert.Equal("/var/run/netns/cni-1234", netConf.NetNSPath)
+	assert.False(netConf.NetNsCreated)
+
+	// a new network namespace is left to the caller to create
+	ocispec.Linux.Namespaces[1].Path = ""
+	netConf, err = networkConfig(ocispec, RuntimeConfig{})
+	assert.NoError(err)
+	assert.Empty(netConf.NetNSPath)
+	assert.False(netConf.NetNsCreated)
+
+	// so is a missing network namespace
+	ocispec.Linux.Namespaces = ocispec.Linux.Namespaces[:1]
+	netConf, err = networkConfig(ocispec, RuntimeConfig{})
+	assert.NoError(err)
+	assert.Empty(netConf.NetNSPath)
+	assert.False(netConf.NetNsCreated)
+
+	ocispec.Linux.Namespaces = append(ocispec.Linux.Namespaces,
+		specs.LinuxNamespace{Type: specs.NetworkNamespace, Path: "/var/run/netns/cni-1234"},
+		specs.LinuxNamespace{Type: specs.NetworkNamespace, Path: "/var/run/netns/cni-5678"})
+	_, err = networkConfig(ocispec, RuntimeConfig{})
+	assert.Error(err)
+}
+
+func TestAddRuntimeAnnotationsTransparentHugepage(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, policy := range []string{"always", "madvise", "never"} {
+		var sandboxConfig vc.SandboxConfig
+		ocispec := specs.Spec{
+			Annotations: map[string]string{
+				vcAnnotations.TransparentHugepage: policy,
+			},
+		}
+
+		err := addRuntimeConfigOverrides(ocispec, &sandboxConfig)
+		assert.NoError(err)
+		assert.Equal(policy, sandboxConfig.TransparentHugepage)
+	}
+
+	var sandboxConfig vc.SandboxConfig
+	err := addRuntimeConfigOverrides(specs.Spec{}, &sandboxConfig)
+	assert.NoError(err)
+	assert.Empty(sandboxConfig.TransparentHugepage)
+
+	ocispec := specs.Spec{
+		Annotations: map[string]string{
+			vcAnnotations.TransparentHugepage: "sometimes",
+		},
+	}
+	err = addRuntimeConfigOverrides(ocispec, &sandboxConfig)
+	assert.Error(err)
+}
+
+func TestImageRef(t *testing.T) {
+	assert := assert.New(t)
+
+	image := "docker.io/library/busybox:latest"
+
+	assert.Equal(image, ImageRef(map[string]string{
+		annotations.ImageName: image,
+		annotations.ImageRef:  "docker.io/library/busybox@sha256:1234",
+	}))
+
+	assert.Equal(image, ImageRef(map[string]string{
+		"io.kubernetes.cri.image-name": image,
+	}))
+
+	assert.Equal("docker.io/library/busybox@sha256:1234", ImageRef(map[string]string{
+		annotations.ImageRef: "docker.io/library/busybox@sha256:1234",
+	}))
+
+	assert.Empty(ImageRef(map[string]string{}))
+	assert.Empty(ImageRef(nil))
+}
+
+func TestReconcileResources(t *testing.T) {
+	assert := assert.New(t)
+
+	limit := int64(1024 << 20)
+	quota := int64(200000)
+	period := uint64(100000)
+
+	ocispec := specs.Spec{
+		Linux: &specs.Linux{
+			Resources: &specs.LinuxResources{
+				Memory: &specs.LinuxMemory{Limit: &limit},
+				CPU:    &specs.LinuxCPU{Quota: &quota, Period: &period},
+			},
+		},
+	}
+
+	resources, err := ReconcileResources(ocispec, nil)
+	assert.NoError(err)
+	assert.Equal(ResolvedResources{MemoryMiB: 1024, VCPUs: 2}, resources)
+
+	// annotations override the spec
+	resources, err = ReconcileResources(ocispec, map[string]string{
+		vcAnnotations.DefaultMemory: "2048",
+		vcAnnotations.DefaultVCPUs:  "4",
+	})
+	assert.NoError(err)
+	assert.Equal(ResolvedResources{MemoryMiB: 2048, VCPUs: 4}, resources)
+
+	// slightly below the spec limit is only a warning
+	resources, err = ReconcileResources(ocispec, map[string]string{
+		vcAnnotations.DefaultMemory: "768",
+	})
+	assert.NoError(err)
+	assert.Equal(ResolvedResources{MemoryMiB: 768, VCPUs: 2}, resources)
+
+	// far below the spec limit is a conflict
+	_, err = ReconcileResources(ocispec, map[string]string{
+		vcAnnotations.DefaultMemory: "256",
+	})
+	assert.Error(err)
+
+	for _, value := range []string{"0", "-1", "lots"} {
+		_, err = ReconcileResources(specs.Spec{}, map[string]string{
+			vcAnnotations.DefaultMemory: value,
+		})
+		assert.Error(err)
+
+		_, err = ReconcileResources(specs.Spec{}, map[string]string{
+			vcAnnotations.DefaultVCPUs: value,
+		})
+		assert.Error(err)
+	}
+}
+
+func TestAddRuntimeAnnotationsPanicOnOOM(t *testing.T) {
+	assert := assert.New(t)
+
+	data := map[string]string{
+		"true":  "1",
+		"false": "0",
+	}
+
+	for value, sysctl := range data {
+		var sandboxConfig vc.SandboxConfig
+		ocispec := specs.Spec{
+			Annotations: map[string]string{
+				vcAnnotations.PanicOnOOM: value,
+			},
+		}
+
+		err := addRuntimeConfigOverrides(ocispec, &sandboxConfig)
+		assert.NoError(err)
+		assert.Equal(map[string]string{"vm.panic_on_oom": sysctl}, sandboxConfig.GuestSysctls)
+	}
+
+	var sandboxConfig vc.SandboxConfig
+	ocispec := specs.Spec{
+		Annotations: map[string]string{
+			vcAnnotations.PanicOnOOM: "TRUE",
+		},
+	}
+	err := addRuntimeConfigOverrides(ocispec, &sandboxConfig)
+	assert.Error(err)
+	assert.Nil(sandboxConfig.GuestSysctls)
+}
+
+func TestAddRuntimeAnnotationsPidMax(t *testing.T) {
+	assert := assert.New(t)
+
+	var sandboxConfig vc.SandboxConfig
+	ocispec := specs.Spec{
+		Annotations: map[string]string{
+			vcAnnotations.PidMax: "131072",
+		},
+	}
+
+	err := addRuntimeConfigOverrides(ocispec, &sandboxConfig)
+	assert.NoError(err)
+	assert.Equal(map[string]string{"kernel.pid_max": "131072"}, sandboxConfig.GuestSysctls)
+
+	for _, value := range []string{"0", "-1", "300", "4194305", "big"} {
+		var sandboxConfig vc.SandboxConfig
+		ocispec.Annotations[vcAnnotations.PidMax] = value
+
+		err := addRuntimeConfigOverrides(ocispec, &sandboxConfig)
+		assert.Error(err, "value %q", value)
+		assert.Nil(sandboxConfig.GuestSysctls)
+	}
+}
+
+func TestAddRuntimeAnnotationsNfConntrackMax(t *testing.T) {
+	assert := assert.New(t)
+
+	var sandboxConfig vc.SandboxConfig
+	ocispec := specs.Spec{
+		Annotations: map[string]string{
+			vcAnnotations.NfConntrackMax: "1048576",
+		},
+	}
+
+	err := addRuntimeConfigOverrides(ocispec, &sandboxConfig)
+	assert.NoError(err)
+	assert.Equal(map[string]string{"net.netfilter.nf_conntrack_max": "1048576"}, sandboxConfig.GuestSysctls)
+
+	for _, value := range []string{"0", "-1", "2147483648", "1M", ""} {
+		var sandboxConfig vc.SandboxConfig
+		ocispec.Annotations[vcAnnotations.NfConntrackMax] = value
+
+		err := addRuntimeConfigOverrides(ocispec, &sandboxConfig)
+		assert.Error(err, "value %q", value)
+		assert.Nil(sandboxConfig.GuestSysctls)
+	}
+}
+
+func TestAddRuntimeAnnotationsVMSwappiness(t *testing.T) {
+	assert := assert.New(t)
+
+	var sandboxConfig vc.SandboxConfig
+	ocispec := specs.Spec{
+		Annotations: map[string]string{
+			vcAnnotations.VMSwappiness: "10",
+		},
+	}
+
+	err := addRuntimeConfigOverrides(ocispec, &sandboxConfig)
+	assert.NoError(err)
+	assert.Equal(map[string]string{"vm.swappiness": "10"}, sandboxConfig.GuestSysctls)
+
+	for _, value := range []string{"101", "-1", "high", ""} {
+		var sandboxConfig vc.SandboxConfig
+		ocispec.Annotations[vcAnnotations.VMSwappiness] = value
+
+		err := addRuntimeConfigOverrides(ocispec, &sandboxConfig)
+		assert.Error(err, "value %q", value)
+		assert.Nil(sandboxConfig.GuestSysctls)
+	}
+}
+
+func TestContainerConfigNoNewPrivilegesAmbientCapabilities(t *testing.T) {
+	assert := assert.New(t)
+
+	capList := []string{"CAP_NET_BIND_SERVICE"}
+
+	ocispec := specs.Spec{
+		Root: &specs.Root{Path: "rootfs"},
+		Process: &specs.Process{
+			Args:            []string{"sh"},
+			NoNewPrivileges: true,
+			Capabilities: &specs.LinuxCapabilities{
+				Bounding:  capList,
+				Effective: capList,
+				Permitted: capList,
+				Ambient:   capList,
+			},
+		},
+		Linux: &specs.Linux{
+			Resources: &specs.LinuxResources{},
+		},
+	}
+
+	containerConfig, err := ContainerConfig(ocispec, tempBundlePath, containerID, consolePath, false)
+	assert.NoError(err)
+	assert.Empty(containerConfig.Cmd.Capabilities.Ambient)
+	assert.Equal(capList, containerConfig.Cmd.Capabilities.Effective)
+
+	// the spec is left untouched
+	assert.Equal(capList, ocispec.Process.Capabilities.Ambient)
+
+	ocispec.Process.NoNewPrivileges = false
+	containerConfig, err = ContainerConfig(ocispec, tempBundlePath, containerID, consolePath, false)
+	assert.NoError(err)
+	assert.Equal(capList, containerConfig.Cmd.Capabilities.Ambient)
+}
+
+func TestQoSClass(t *testing.T) {
+	assert := assert.New(t)
+
+	limit := int64(512 << 20)
+	quota := int64(50000)
+	period := uint64(100000)
+	// 500m CPU request
+	shares := uint64(512)
+	lowShares := uint64(256)
+	minShares := uint64(2)
+
+	newSpec := func(limit *int64, quota *int64, shares *uint64) specs.Spec {
+		return specs.Spec{
+			Linux: &specs.Linux{
+				Resources: &specs.LinuxResources{
+					Memory: &specs.LinuxMemory{Limit: limit},
+					CPU:    &specs.LinuxCPU{Quota: quota, Period: &period, Shares: shares},
+				},
+			},
+		}
+	}
+
+	assert.Equal(QoSBestEffort, QoSClass(nil, specs.Spec{}))
+	assert.Equal(QoSBestEffort, QoSClass(nil, newSpec(nil, nil, &minShares)))
+	assert.Equal(QoSGuaranteed, QoSClass(nil, newSpec(&limit, &quota, &shares)))
+	assert.Equal(QoSBurstable, QoSClass(nil, newSpec(&limit, &quota, &lowShares)))
+	assert.Equal(QoSBurstable, QoSClass(nil, newSpec(nil, nil, &shares)))
+	assert.Equal(QoSBurstable, QoSClass(nil, newSpec(&limit, nil, &minShares)))
+
+	annotations := map[string]string{
+		vcAnnotations.QoSClass: QoSGuaranteed,
+	}
+	assert.Equal(QoSGuaranteed, QoSClass(annotations, specs.Spec{}))
+}
+
+func TestAddHypervisorAnnotationsBlockDeviceCacheMode(t *testing.T) {
+	assert := assert.New(t)
+
+	data := map[string]bool{
+		"writeback":    false,
+		"writethrough": false,
+		"none":         true,
+	}
+
+	for mode, direct := range data {
+		var sandboxConfig vc.SandboxConfig
+		ocispec := specs.Spec{
+			Annotations: map[string]string{
+				vcAnnotations.BlockDeviceCacheMode: mode,
+			},
+		}
+
+		err := addHypervisorConfigOverrides(ocispec, &sandboxConfig)
+		assert.NoError(err)
+		assert.Equal(mode, sandboxConfig.HypervisorConfig.BlockDeviceCacheMode)
+		assert.True(sandboxConfig.HypervisorConfig.BlockDeviceCacheSet)
+		assert.Equal(direct, sandboxConfig.HypervisorConfig.BlockDeviceCacheDirect)
+		assert.False(sandboxConfig.HypervisorConfig.BlockDeviceCacheNoflush)
+	}
+
+	var sandboxConfig vc.SandboxConfig
+	ocispec := specs.Spec{
+		Annotations: map[string]string{
+			vcAnnotations.BlockDeviceCacheMode: "unsafe",
+		},
+	}
+	err := addHypervisorConfigOverrides(ocispec, &sandboxConfig)
+	assert.Error(err)
+	assert.False(sandboxConfig.HypervisorConfig.BlockDeviceCacheSet)
+}
+
+func TestEnvVarsUnquote(t *testing.T) {
+	assert := assert.New(t)
+	envVars := []string{"foo=bar", "TERM=\"bar\"", "foo=\"\"", "MSG=\"say \\\"hi\\\" \\\\o/\"", "HALF=\"open"}
+
+	// quotes are preserved by default
+	vcEnvVars, err := EnvVars(envVars)
+	assert.NoError(err)
+	assert.Exactly([]types.EnvVar{
+		{Var: "foo", Value: "bar"},
+		{Var: "TERM", Value: "\"bar\""},
+		{Var: "foo", Value: "\"\""},
+		{Var: "MSG", Value: "\"say \\\"hi\\\" \\\\o/\""},
+		{Var: "HALF", Value: "\"open"},
+	}, vcEnvVars)
+
+	vcEnvVars, err = EnvVarsUnquote(envVars)
+	assert.NoError(err)
+	assert.Exactly([]types.EnvVar{
+		{Var: "foo", Value: "bar"},
+		{Var: "TERM", Value: "bar"},
+		{Var: "foo", Value: ""},
+		{Var: "MSG", Value: "say \"hi\" \\o/"},
+		{Var: "HALF", Value: "\"open"},
+	}, vcEnvVars)
+
+	_, err = EnvVarsUnquote([]string{"=foo"})
+	assert.Error(err)
+}
+
+func TestEnvVarsWithLimits(t *testing.T) {
+	assert := assert.New(t)
+
+	var envVars []string
+	for i := 0; i < 2000; i++ {
+		envVars = append(envVars, fmt.Sprintf("VAR%d=value", i))
+	}
+
+	// no limits
+	vcEnvVars, err := EnvVarsWithLimits(envVars, 0, 0)
+	assert.NoError(err)
+	assert.Len(vcEnvVars, len(envVars))
+
+	vcEnvVars, err = EnvVarsWithLimits(envVars, 2000, 64*1024)
+	assert.NoError(err)
+	assert.Len(vcEnvVars, len(envVars))
+
+	_, err = EnvVarsWithLimits(envVars, 1000, 0)
+	assert.Error(err)
+
+	_, err = EnvVarsWithLimits(envVars, 0, 16*1024)
+	assert.Error(err)
+
+	// "foo=bar" and its terminating NUL
+	_, err = EnvVarsWithLimits([]string{"foo=bar"}, 1, 8)
+	assert.NoError(err)
+	_, err = EnvVarsWithLimits([]string{"foo=bar"}, 1, 7)
+	assert.Error(err)
+
+	_, err = EnvVarsWithLimits([]string{"foo"}, 1, 8)
+	assert.Error(err)
+}
+
+func TestEnvVarsDedup(t *testing.T) {
+	assert := assert.New(t)
+	envVars := []string{"foo=bar", "TERM=xterm", "HOME=/home/foo", "TERM=\"bar\"", "PATH=/bin", "foo=\"\"", "TERM=vt100"}
+
+	vcEnvVars, err := EnvVarsDedup(envVars)
+	assert.NoError(err)
+	assert.Exactly([]types.EnvVar{
+		{Var: "HOME", Value: "/home/foo"},
+		{Var: "PATH", Value: "/bin"},
+		{Var: "foo", Value: "\"\""},
+		{Var: "TERM", Value: "vt100"},
+	}, vcEnvVars)
+
+	// without duplicates, the result matches EnvVars
+	vcEnvVars, err = EnvVarsDedup([]string{"foo=bar", "HOME=/root"})
+	assert.NoError(err)
+	assert.Exactly([]types.EnvVar{
+		{Var: "foo", Value: "bar"},
+		{Var: "HOME", Value: "/root"},
+	}, vcEnvVars)
+
+	_, err = EnvVarsDedup([]string{"foo"})
+	assert.Error(err)
+}
+
+func TestValidateShmSize(t *testing.T) {
+	assert := assert.New(t)
+
+	// unknown guest memory
+	assert.NoError(validateShmSize(1<<30, 0, 0))
+
+	assert.NoError(validateShmSize(vc.DefaultShmSize, 2048, 0))
+	assert.NoError(validateShmSize(1<<30, 2048, 0))
+	assert.Error(validateShmSize(1<<30+1, 2048, 0))
+
+	assert.NoError(validateShmSize(1536<<20, 2048, 0.75))
+	assert.Error(validateShmSize(1536<<20, 2048, 0.5))
+}
+
+func TestSandboxConfigOversizedShm(t *testing.T) {
+	assert := assert.New(t)
+
+	ocispec := specs.Spec{
+		Root: &specs.Root{Path: "rootfs"},
+		Process: &specs.Process{
+			Args: []string{"sh"},
+		},
+		Mounts: []specs.Mount{
+			{
+				Source:      "shm",
+				Destination: "/dev/shm",
+				Type:        "tmpfs",
+				Options:     []string{"size=1Gi"},
+			},
+		},
+		Linux: &specs.Linux{
+			Resources: &specs.LinuxResources{},
+		},
+	}
+
+	runtimeConfig := RuntimeConfig{
+		HypervisorType: vc.QemuHypervisor,
+		HypervisorConfig: vc.HypervisorConfig{
+			MemorySize: 1024,
+		},
+	}
+
+	_, err := SandboxConfig(ocispec, runtimeConfig, tempBundlePath, containerID, consolePath, false, false)
+	assert.Error(err)
+
+	runtimeConfig.ShmMemoryFraction = 1
+	sandboxConfig, err := SandboxConfig(ocispec, runtimeConfig, tempBundlePath, containerID, consolePath, false, false)
+	assert.NoError(err)
+	assert.Equal(uint64(1<<30), sandboxConfig.ShmSize)
+}
+
+func TestAddAgentAnnotationsMetrics(t *testing.T) {
+	assert := assert.New(t)
+
+	sandboxConfig := vc.SandboxConfig{
+		AgentConfig: vc.KataAgentConfig{},
+	}
+
+	ocispec := specs.Spec{
+		Annotations: map[string]string{
+			vcAnnotations.EnableMetrics: "false",
+		},
+	}
+
+	err := addAgentConfigOverrides(ocispec, &sandboxConfig)
+	assert.NoError(err)
+	assert.Exactly(vc.KataAgentConfig{}, sandboxConfig.AgentConfig)
+
+	// the agent provides no metrics endpoint
+	for _, value := range []string{"true", "on"} {
+		ocispec.Annotations[vcAnnotations.EnableMetrics] = value
+		err = addAgentConfigOverrides(ocispec, &sandboxConfig)
+		assert.Error(err, "value %q", value)
+	}
+
+	ocispec.Annotations = map[string]string{
+		vcAnnotations.MetricsPort: "8090",
+	}
+	err = addAgentConfigOverrides(ocispec, &sandboxConfig)
+	assert.Error(err)
+}
+
+func TestDiffKernelParams(t *testing.T) {
+	assert := assert.New(t)
+
+	old := []vc.Param{
+		{Key: "quiet", Value: ""},
+		{Key: "console", Value: "hvc0"},
+		{Key: "systemd.unit", Value: "kata-containers.target"},
+	}
+
+	new := []vc.Param{
+		{Key: "console", Value: "hvc1"},
+		{Key: "systemd.unit", Value: "kata-containers.target"},
+		{Key: "crashkernel", Value: "256M"},
+	}
+
+	added, removed, changed := DiffKernelParams(old, new)
+	assert.Equal([]vc.Param{{Key: "crashkernel", Value: "256M"}}, added)
+	assert.Equal([]vc.Param{{Key: "quiet", Value: ""}}, removed)
+	assert.Equal([]vc.Param{{Key: "console", Value: "hvc1"}}, changed)
+
+	added, removed, changed = DiffKernelParams(old, old)
+	assert.Empty(added)
+	assert.Empty(removed)
+	assert.Empty(changed)
+}
+
+func TestValidateCapabilities(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.NoError(ValidateCapabilities(nil))
+	assert.NoError(ValidateCapabilities(&specs.LinuxCapabilities{
+		Bounding:  []string{"CAP_CHOWN", "CAP_NET_BIND_SERVICE", "CAP_AUDIT_READ"},
+		Effective: []string{"CAP_CHOWN"},
+	}))
+
+	// un-prefixed names are accepted, as by Cmd.EffectiveCapabilities
+	assert.NoError(ValidateCapabilities(&specs.LinuxCapabilities{
+		Bounding: []string{"CAP_CHOWN", "NET_RAW", "net_bind_service"},
+	}))
+
+	err := ValidateCapabilities(&specs.LinuxCapabilities{
+		Bounding:  []string{"CAP_CHOWN", "CAP_NET_BNID_SERVICE"},
+		Effective: []string{"CAP_NET_BNID_SERVICE"},
+		Ambient:   []string{"NET_RWA"},
+	})
+	assert.Error(err)
+	assert.Equal("Unknown capabilities: CAP_NET_BNID_SERVICE, NET_RWA", err.Error())
+}
+
+func TestSandboxConfigStrictCapabilities(t *testing.T) {
+	assert := assert.New(t)
+
+	specCaps := &specs.LinuxCapabilities{
+		Bounding:  []string{"CAP_CHOWN", "CAP_NET_BNID_SERVICE"},
+		Effective: []string{"CAP_CHOWN", "CAP_NET_BNID_SERVICE"},
+	}
+
+	ocispec := specs.Spec{
+		Root: &specs.Root{Path: "rootfs"},
+		Process: &specs.Process{
+			Args:         []string{"/pause"},
+			Capabilities: specCaps,
+		},
+		Linux: &specs.Linux{
+			Resources: &specs.LinuxResources{},
+		},
+		Annotations: map[string]string{
+			annotations.ContainerType: annotations.ContainerTypeSandbox,
+		},
+	}
+
+	runtimeConfig := RuntimeConfig{
+		HypervisorType: vc.QemuHypervisor,
+	}
+
+	// lenient, the unknown capabilities are passed through
+	sandboxConfig, err := SandboxConfig(ocispec, runtimeConfig, tempBundlePath, containerID, consolePath, false, false)
+	assert.NoError(err)
+	assert.Equal(specCaps, sandboxConfig.Containers[0].Cmd.Capabilities)
+
+	runtimeConfig.StrictCapabilities = true
+	_, err = SandboxConfig(ocispec, runtimeConfig, tempBundlePath, containerID, consolePath, false, false)
+	assert.Error(err)
+	assert.Contains(err.Error(), "CAP_NET_BNID_SERVICE")
+
+	// the containers added to a sandbox only get a warning
+	containerConfig, err := ContainerConfig(ocispec, tempBundlePath, containerID, consolePath, false)
+	assert.NoError(err)
+	assert.Equal(specCaps, containerConfig.Cmd.Capabilities)
+
+	specCaps.Bounding = []string{"CAP_CHOWN", "CAP_NET_BIND_SERVICE"}
+	specCaps.Effective = []string{"CAP_CHOWN", "CAP_NET_BIND_SERVICE"}
+	_, err = SandboxConfig(ocispec, runtimeConfig, tempBundlePath, containerID, consolePath, false, false)
+	assert.NoError(err)
+}
+
+func TestSandboxConfigDropSandboxCapabilities(t *testing.T) {
+	assert := assert.New(t)
+
+	specCaps := &specs.LinuxCapabilities{
+		Bounding:  []string{"CAP_CHOWN", "CAP_NET_RAW", "CAP_SYS_ADMIN"},
+		Effective: []string{"CAP_CHOWN", "CAP_NET_RAW", "CAP_SYS_ADMIN"},
+		Permitted: []string{"CAP_CHOWN", "CAP_NET_RAW", "CAP_SYS_ADMIN"},
+	}
+
+	ocispec := specs.Spec{
+		Root: &specs.Root{Path: "rootfs"},
+		Process: &specs.Process{
+			Args:         []string{"/pause"},
+			Capabilities: specCaps,
+		},
+		Linux: &specs.Linux{
+			Resources: &specs.LinuxResources{},
+		},
+		Annotations: map[string]string{
+			annotations.ContainerType: annotations.ContainerTypeSandbox,
+		},
+	}
+
+	runtimeConfig := RuntimeConfig{
+		HypervisorType: vc.QemuHypervisor,
+	}
+
+	// disabled, the capabilities of the spec are kept
+	sandboxConfig, err := SandboxConfig(ocispec, runtimeConfig, tempBundlePath, containerID, consolePath, false, false)
+	assert.NoError(err)
+	assert.Equal(specCaps, sandboxConfig.Containers[0].Cmd.Capabilities)
+
+	runtimeConfig.DropSandboxCapabilities = true
+	sandboxConfig, err = SandboxConfig(ocispec, runtimeConfig, tempBundlePath, containerID, consolePath, false, false)
+	assert.NoError(err)
+
+	expected := &specs.LinuxCapabilities{
+		Bounding:    []string{},
+		Effective:   []string{},
+		Inheritable: []string{},
+		Permitted:   []string{},
+		Ambient:     []string{},
+	}
+	c := sandboxConfig.Containers[0]
+	assert.Equal(expected, c.Cmd.Capabilities)
+	assert.Equal(expected, c.Spec.Process.Capabilities)
+	assert.Equal(specCaps, ocispec.Process.Capabilities)
+
+	// other containers keep their capabilities
+	ocispec.Annotations[annotations.ContainerType] = annotations.ContainerTypeContainer
+	containerConfig, err := ContainerConfig(ocispec, tempBundlePath, containerID, consolePath, false)
+	assert.NoError(err)
+	dropSandboxCapabilities(&containerConfig)
+	assert.Equal(specCaps, containerConfig.Cmd.Capabilities)
+
+	// without CRI annotations, the single container runs the workload
+	// and keeps its capabilities
+	ocispec.Annotations = map[string]string{}
+	sandboxConfig, err = SandboxConfig(ocispec, runtimeConfig, tempBundlePath, containerID, consolePath, false, false)
+	assert.NoError(err)
+	c = sandboxConfig.Containers[0]
+	assert.Equal(string(vc.PodSandbox), c.Annotations[vcAnnotations.ContainerTypeKey])
+	assert.Equal(specCaps, c.Cmd.Capabilities)
+	assert.Equal(specCaps, c.Spec.Process.Capabilities)
+}
+
+func TestSandboxConfigWithContext(t *testing.T) {
+	assert := assert.New(t)
+
+	ocispec := specs.Spec{
+		Root:    &specs.Root{Path: "rootfs"},
+		Process: &specs.Process{Args: []string{"sh"}},
+		Linux: &specs.Linux{
+			Resources: &specs.LinuxResources{},
+		},
+	}
+
+	runtimeConfig := RuntimeConfig{
+		HypervisorType: vc.QemuHypervisor,
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := SandboxConfigWithContext(ctx, ocispec, runtimeConfig, tempBundlePath, containerID, consolePath, false, false)
+	assert.Equal(context.Canceled, err)
+
+	sandboxConfig, err := SandboxConfigWithContext(context.Background(), ocispec, runtimeConfig, tempBundlePath, containerID, consolePath, false, false)
+	assert.NoError(err)
+	assert.Equal(containerID, sandboxConfig.ID)
+}
+
+func TestSandboxConfigTerminalConsole(t *testing.T) {
+	assert := assert.New(t)
+
+	ocispec := specs.Spec{
+		Root: &specs.Root{Path: "rootfs"},
+		Process: &specs.Process{
+			Args:     []string{"sh"},
+			Terminal: true,
+		},
+		Linux: &specs.Linux{
+			Resources: &specs.LinuxResources{},
+		},
+	}
+
+	runtimeConfig := RuntimeConfig{
+		HypervisorType: vc.QemuHypervisor,
+		Console:        consolePath,
+	}
+
+	// the runtime console is used by default
+	sandboxConfig, err := SandboxConfig(ocispec, runtimeConfig, tempBundlePath, containerID, "", false, false)
+	assert.NoError(err)
+	assert.True(sandboxConfig.Containers[0].Cmd.Interactive)
+	assert.Equal(consolePath, sandboxConfig.Containers[0].Cmd.Console)
+
+	// an explicit console takes precedence
+	sandboxConfig, err = SandboxConfig(ocispec, runtimeConfig, tempBundlePath, containerID, "/dev/pts/3", false, false)
+	assert.NoError(err)
+	assert.Equal("/dev/pts/3", sandboxConfig.Containers[0].Cmd.Console)
+
+	// no console at all is left to the caller
+	runtimeConfig.Console = ""
+	sandboxConfig, err = SandboxConfig(ocispec, runtimeConfig, tempBundlePath, containerID, "", false, false)
+	assert.NoError(err)
+	assert.True(sandboxConfig.Containers[0].Cmd.Interactive)
+	assert.Empty(sandboxConfig.Containers[0].Cmd.Console)
+
+	// no terminal means no console
+	ocispec.Process.Terminal = false
+	runtimeConfig.Console = consolePath
+	sandboxConfig, err = SandboxConfig(ocispec, runtimeConfig, tempBundlePath, containerID, "", true, false)
+	assert.NoError(err)
+	assert.False(sandboxConfig.Containers[0].Cmd.Interactive)
+	assert.Empty(sandboxConfig.Containers[0].Cmd.Console)
+}
+
+func TestAddHypervisorAnnotationsVirtioFSQueueSize(t *testing.T) {
+	assert := assert.New(t)
+
+	sandboxConfig := vc.SandboxConfig{
+		HypervisorConfig: vc.HypervisorConfig{
+			SharedFS: config.VirtioFS,
+		},
+	}
+
+	ocispec := specs.Spec{
+		Annotations: map[string]string{
+			vcAnnotations.VirtioFSQueueSize: "512",
+		},
+	}
+
+	err := addHypervisorConfigOverrides(ocispec, &sandboxConfig)
+	assert.NoError(err)
+	assert.Equal(uint32(512), sandboxConfig.HypervisorConfig.VirtioFSQueueSize)
+
+	for _, value := range []string{"0", "1025", "-1", "many"} {
+		ocispec.Annotations[vcAnnotations.VirtioFSQueueSize] = value
+		err = addHypervisorConfigOverrides(ocispec, &sandboxConfig)
+		assert.Error(err, "queue size %q", value)
+	}
+
+	// not using virtio-fs
+	sandboxConfig = vc.SandboxConfig{
+		HypervisorConfig: vc.HypervisorConfig{
+			SharedFS: config.Virtio9P,
+		},
+	}
+	ocispec.Annotations[vcAnnotations.VirtioFSQueueSize] = "512"
+	err = addHypervisorConfigOverrides(ocispec, &sandboxConfig)
+	assert.Error(err)
+	assert.Zero(sandboxConfig.HypervisorConfig.VirtioFSQueueSize)
+}
+
+func TestContainerConfigCPUShares(t *testing.T) {
+	assert := assert.New(t)
+
+	shares := uint64(512)
+
+	ocispec := specs.Spec{
+		Root:    &specs.Root{Path: "rootfs"},
+		Process: &specs.Process{Args: []string{"sh"}},
+		Linux: &specs.Linux{
+			Resources: &specs.LinuxResources{
+				CPU: &specs.LinuxCPU{Shares: &shares},
+			},
+		},
+	}
+
+	containerConfig, err := ContainerConfig(ocispec, tempBundlePath, containerID, consolePath, false)
+	assert.NoError(err)
+	assert.NotNil(containerConfig.Resources.CPU)
+	assert.Equal(shares, *containerConfig.Resources.CPU.Shares)
+
+	shares = 1
+	_, err = ContainerConfig(ocispec, tempBundlePath, containerID, consolePath, false)
+	assert.Error(err)
+}
+
+func TestEnvVarsToStrings(t *testing.T) {
+	assert := assert.New(t)
+
+	envs := []string{"TERM=xterm", "HOME=/root", "PATH=/bin:/usr/bin", "EMPTY=", "TERM=vt100"}
+
+	envVars, err := EnvVars(envs)
+	assert.NoError(err)
+
+	envStrings := EnvVarsToStrings(envVars)
+	assert.Equal([]string{"EMPTY=", "HOME=/root", "PATH=/bin:/usr/bin", "TERM=vt100"}, envStrings)
+
+	roundTrip, err := EnvVars(envStrings)
+	assert.NoError(err)
+	assert.Equal(envStrings, EnvVarsToStrings(roundTrip))
+
+	assert.Empty(EnvVarsToStrings(nil))
+}
+
+func TestAddHypervisorAnnotationsWatchdog(t *testing.T) {
+	assert := assert.New(t)
+
+	sandboxConfig := vc.SandboxConfig{
+		HypervisorType: vc.QemuHypervisor,
+		Annotations:    make(map[string]string),
+	}
+
+	ocispec := specs.Spec{
+		Annotations: map[string]string{
+			vcAnnotations.EnableWatchdog: "true",
+		},
+	}
+
+	err := addHypervisorConfigOverrides(ocispec, &sandboxConfig)
+	assert.NoError(err)
+	assert.True(sandboxConfig.HypervisorConfig.EnableWatchdog)
+	assert.Zero(sandboxConfig.HypervisorConfig.WatchdogTimeout)
+
+	sandboxConfig.HypervisorConfig = vc.HypervisorConfig{}
+	ocispec.Annotations[vcAnnotations.WatchdogTimeout] = "60"
+	err = addHypervisorConfigOverrides(ocispec, &sandboxConfig)
+	assert.NoError(err)
+	assert.True(sandboxConfig.HypervisorConfig.EnableWatchdog)
+	assert.Equal(uint32(60), sandboxConfig.HypervisorConfig.WatchdogTimeout)
+	// the heartbeat kernel parameter is added by the hypervisor
+	assert.Empty(sandboxConfig.HypervisorConfig.KernelParams)
+
+	sandboxConfig.HypervisorConfig = vc.HypervisorConfig{}
+	ocispec.Annotations[vcAnnotations.EnableWatchdog] = "false"
+	err = addHypervisorConfigOverrides(ocispec, &sandboxConfig)
+	assert.NoError(err)
+	assert.False(sandboxConfig.HypervisorConfig.EnableWatchdog)
+
+	ocispec.Annotations[vcAnnotations.EnableWatchdog] = "1"
+	err = addHypervisorConfigOverrides(ocispec, &sandboxConfig)
+	assert.Error(err)
+
+	ocispec.Annotations[vcAnnotations.EnableWatchdog] = "true"
+	for _, timeout := range []string{"0", "-1", "5000", "1m"} {
+		ocispec.Annotations[vcAnnotations.WatchdogTimeout] = timeout
+		err = addHypervisorConfigOverrides(ocispec, &sandboxConfig)
+		assert.Error(err, "timeout %q", timeout)
+	}
+
+	delete(ocispec.Annotations, vcAnnotations.WatchdogTimeout)
+	sandboxConfig.HypervisorType = vc.FirecrackerHypervisor
+	err = addHypervisorConfigOverrides(ocispec, &sandboxConfig)
+	assert.Error(err)
+
+	assert.NoError(checkWatchdogSupport(vc.QemuHypervisor, "amd64"))
+	assert.NoError(checkWatchdogSupport(vc.QemuHypervisor, "ppc64le"))
+	assert.Error(checkWatchdogSupport(vc.QemuHypervisor, "arm64"))
+	assert.Error(checkWatchdogSupport(vc.QemuHypervisor, "s390x"))
+	assert.Error(checkWatchdogSupport(vc.AcrnHypervisor, "amd64"))
+}
+
+func TestContainerMountsSubPath(t *testing.T) {
+	assert := assert.New(t)
+
+	spec := specs.Spec{
+		Mounts: []specs.Mount{
+			{
+				Source:      "/var/lib/kubelet/pods/uid/volumes/kubernetes.io~empty-dir/data/logs/app",
+				Destination: "/data",
+				Type:        "bind",
+				Options:     []string{"rbind"},
+			},
+			{
+				Source:      "/var/lib/kubelet/pods/uid/volumes/kubernetes.io~configmap/config",
+				Destination: "/etc/app/",
+				Type:        "bind",
+				Options:     []string{"rbind"},
+			},
+			{
+				Source:      "/host/other",
+				Destination: "/other",
+				Type:        "bind",
+				Options:     []string{"rbind"},
+			},
+		},
+		Annotations: map[string]string{
+			vcAnnotations.MountSubPaths: "/data=logs/app; /etc/app=mismatch; /other=../other; malformed",
+		},
+	}
+
+	mounts, err := containerMounts(spec)
+	assert.NoError(err)
+	assert.Len(mounts, 3)
+
+	// the source still points to the subpath only
+	assert.Equal(spec.Mounts[0].Source, mounts[0].Source)
+	assert.Equal("logs/app", mounts[0].SubPath)
+
+	// subpaths not matching the source or escaping the volume are ignored
+	assert.Equal(spec.Mounts[1].Source, mounts[1].Source)
+	assert.Empty(mounts[1].SubPath)
+	assert.Equal(spec.Mounts[2].Source, mounts[2].Source)
+	assert.Empty(mounts[2].SubPath)
+
+	delete(spec.Annotations, vcAnnotations.MountSubPaths)
+	mounts, err = containerMounts(spec)
+	assert.NoError(err)
+	assert.Equal(spec.Mounts[0].Source, mounts[0].Source)
+	assert.Empty(mounts[0].SubPath)
+}
+
+func TestAddRuntimeAnnotationsDefaultRouteMetric(t *testing.T) {
+	assert := assert.New(t)
+
+	// the routes passed to the agent carry no metric
+	for _, value := range []string{"100", "0", "high"} {
+		var sandboxConfig vc.SandboxConfig
+		ocispec := specs.Spec{
+			Annotations: map[string]string{
+				vcAnnotations.DefaultRouteMetric: value,
+			},
+		}
+
+		err := addRuntimeConfigOverrides(ocispec, &sandboxConfig)
+		assert.Error(err, "metric %q", value)
+	}
+}
+
+func TestAddHypervisorAnnotationsConfidentialGuest(t *testing.T) {
+	assert := assert.New(t)
+
+	var sandboxConfig vc.SandboxConfig
+	ocispec := specs.Spec{
+		Annotations: map[string]string{
+			vcAnnotations.ConfidentialGuest: "true",
+		},
+	}
+
+	err := addHypervisorConfigOverrides(ocispec, &sandboxConfig)
+	assert.NoError(err)
+	assert.True(sandboxConfig.HypervisorConfig.ConfidentialGuest)
+	assert.True(sandboxConfig.IsConfidential())
+
+	ocispec.Annotations[vcAnnotations.ConfidentialGuest] = "sev"
+	err = addHypervisorConfigOverrides(ocispec, &sandboxConfig)
+	assert.Error(err)
+}
+
+func TestContainerConfigRlimitNofile(t *testing.T) {
+	assert := assert.New(t)
+
+	ocispec := specs.Spec{
+		Root: &specs.Root{Path: "rootfs"},
+		Process: &specs.Process{
+			Args: []string{"sh"},
+			Rlimits: []specs.POSIXRlimit{
+				{Type: "RLIMIT_CORE", Soft: 0, Hard: 0},
+			},
+		},
+		Linux: &specs.Linux{
+			Resources: &specs.LinuxResources{},
+		},
+	}
+
+	containerConfig, err := ContainerConfig(ocispec, tempBundlePath, containerID, consolePath, false)
+	assert.NoError(err)
+	assert.Equal([]specs.POSIXRlimit{
+		{Type: "RLIMIT_CORE", Soft: 0, Hard: 0},
+		DefaultRlimitNofile,
+	}, containerConfig.Spec.Process.Rlimits)
+
+	// the spec is left untouched
+	assert.Len(ocispec.Process.Rlimits, 1)
+
+	nofile := specs.POSIXRlimit{Type: "RLIMIT_NOFILE", Soft: 65536, Hard: 65536}
+	ocispec.Process.Rlimits = []specs.POSIXRlimit{nofile}
+
+	containerConfig, err = ContainerConfig(ocispec, tempBundlePath, containerID, consolePath, false)
+	assert.NoError(err)
+	assert.Equal([]specs.POSIXRlimit{nofile}, containerConfig.Spec.Process.Rlimits)
+}
+
+func TestContainerConfigDisableDevpts(t *testing.T) {
+	assert := assert.New(t)
+
+	ocispec := specs.Spec{
+		Root:    &specs.Root{Path: "rootfs"},
+		Process: &specs.Process{Args: []string{"sh"}, Terminal: true},
+		Mounts: []specs.Mount{
+			{Source: "proc", Destination: "/proc", Type: "proc"},
+			{Source: "devpts", Destination: "/dev/pts", Type: "devpts"},
+		},
+		Linux: &specs.Linux{
+			Resources: &specs.LinuxResources{},
+		},
+		Annotations: map[string]string{
+			vcAnnotations.DisableDevpts: "true",
+		},
+	}
+
+	hasDevpts := func(mounts []vc.Mount) bool {
+		for _, m := range mounts {
+			if m.Type == "devpts" {
+				return true
+			}
+		}
+		return false
+	}
+
+	containerConfig, err := ContainerConfig(ocispec, tempBundlePath, containerID, consolePath, false)
+	assert.NoError(err)
+	assert.False(hasDevpts(containerConfig.Mounts))
+	assert.Len(containerConfig.Spec.Mounts, 1)
+	assert.Equal(consolePath, containerConfig.Cmd.Console)
+
+	// the spec is left untouched
+	assert.Len(ocispec.Mounts, 2)
+
+	ocispec.Annotations[vcAnnotations.DisableDevpts] = "false"
+	containerConfig, err = ContainerConfig(ocispec, tempBundlePath, containerID, consolePath, false)
+	assert.NoError(err)
+	assert.True(hasDevpts(containerConfig.Mounts))
+
+	ocispec.Annotations[vcAnnotations.DisableDevpts] = "yes"
+	_, err = ContainerConfig(ocispec, tempBundlePath, containerID, consolePath, false)
+	assert.Error(err)
+}
+
+func TestDroppedFields(t *testing.T) {
+	assert := assert.New(t)
+
+	pidsLimit := int64(100)
+	ocispec := specs.Spec{
+		Root:    &specs.Root{Path: "rootfs"},
+		Process: &specs.Process{Args: []string{"sh"}},
+		Linux: &specs.Linux{
+			Resources: &specs.LinuxResources{},
+		},
+	}
+	assert.Empty(DroppedFields(ocispec))
+
+	ocispec.Linux.IntelRdt = &specs.LinuxIntelRdt{L3CacheSchema: "L3:0=ffff"}
+	ocispec.Linux.Namespaces = []specs.LinuxNamespace{
+		{Type: specs.PIDNamespace},
+		{Type: specs.CgroupNamespace},
+	}
+	ocispec.Linux.Resources.Pids = &specs.LinuxPids{Limit: pidsLimit}
+	ocispec.Linux.Resources.HugepageLimits = []specs.LinuxHugepageLimit{{Pagesize: "2MB", Limit: 1 << 30}}
+	ocispec.VM = &specs.VM{}
+
+	assert.Equal([]string{
+		"vm",
+		"linux.intelRdt",
+		"linux.namespaces.cgroup",
+		"linux.resources.pids",
+		"linux.resources.hugepageLimits",
+	}, DroppedFields(ocispec))
+
+	// the warnings are opt-in and don't fail the conversion
+	runtimeConfig := RuntimeConfig{
+		HypervisorType:    vc.QemuHypervisor,
+		WarnDroppedFields: true,
+	}
+	_, err := SandboxConfig(ocispec, runtimeConfig, tempBundlePath, containerID, consolePath, false, false)
+	assert.NoError(err)
+}
+
+func TestContainerMountsOwnership(t *testing.T) {
+	assert := assert.New(t)
+
+	spec := specs.Spec{
+		Mounts: []specs.Mount{
+			{
+				Source:      "tmpfs",
+				Destination: "/dev",
+				Type:        "tmpfs",
+				Options:     []string{"nosuid", "strictatime", "mode=755", "size=65536k"},
+			},
+			{
+				Source:      "devpts",
+				Destination: "/dev/pts",
+				Type:        "devpts",
+				Options:     []string{"nosuid", "noexec", "newinstance", "ptmxmode=0666", "mode=0620", "gid=5"},
+			},
+			{
+				Source:      "shm",
+				Destination: "/dev/shm",
+				Type:        "tmpfs",
+				Options:     []string{"mode=1777", "uid=1000", "gid=1000"},
+			},
+			{
+				Source:      "/data",
+				Destination: "/data",
+				Type:        "bind",
+				Options:     []string{"rbind", "mode=755"},
+			},
+		},
+	}
+
+	_, err := containerMounts(spec)
+	assert.NoError(err)
+
+	for _, option := range []string{"mode=789", "mode=rwx", "mode=17777", "uid=-1", "gid=root"} {
+		spec.Mounts[0].Options = []string{option}
+		_, err := containerMounts(spec)
+		assert.Error(err, "option %q", option)
+	}
+}
+
+func TestValidateMountDestinations(t *testing.T) {
+	assert := assert.New(t)
+
+	mounts := []vc.Mount{
+		{Source: "proc", Destination: "/proc", Type: "proc"},
+		{Source: "/data", Destination: "/data", Type: "bind"},
+	}
+	assert.NoError(ValidateMountDestinations(mounts))
+	assert.NoError(ValidateMountDestinations(nil))
+
+	mounts = append(mounts,
+		vc.Mount{Source: "/data", Destination: "data", Type: "bind"},
+		vc.Mount{Source: "tmpfs", Destination: "./tmp", Type: "tmpfs"})
+	err := ValidateMountDestinations(mounts)
+	assert.Error(err)
+	assert.Contains(err.Error(), `"data"`)
+	assert.Contains(err.Error(), `"./tmp"`)
+	assert.NotContains(err.Error(), `"/data"`)
+
+	// SandboxConfig rejects the relative destinations
+	ocispec := specs.Spec{
+		Root:    &specs.Root{Path: "rootfs"},
+		Process: &specs.Process{Args: []string{"sh"}},
+		Mounts: []specs.Mount{
+			{Source: "/data", Destination: "data", Type: "bind", Options: []string{"rbind"}},
+		},
+		Linux: &specs.Linux{
+			Resources: &specs.LinuxResources{},
+		},
+	}
+
+	runtimeConfig := RuntimeConfig{
+		HypervisorType: vc.QemuHypervisor,
+	}
+
+	_, err = SandboxConfig(ocispec, runtimeConfig, tempBundlePath, containerID, consolePath, false, false)
+	assert.Error(err)
+	assert.Contains(err.Error(), "is not absolute")
+}
+
+func TestValidateCgroupsPath(t *testing.T) {
+	assert := assert.New(t)
+
+	spec := specs.Spec{}
+	assert.NoError(ValidateCgroupsPath(spec, true))
+	assert.NoError(ValidateCgroupsPath(spec, false))
+
+	spec.Linux = &specs.Linux{}
+	assert.NoError(ValidateCgroupsPath(spec, true))
+	assert.NoError(ValidateCgroupsPath(spec, false))
+
+	for _, path := range []string{"/kubepods/besteffort/pod1/ctr", "kata/ctr"} {
+		spec.Linux.CgroupsPath = path
+		assert.NoError(ValidateCgroupsPath(spec, false), "path %q", path)
+		assert.Error(ValidateCgroupsPath(spec, true), "path %q", path)
+	}
+
+	for _, path := range []string{"system.slice:kata:ctr", ":kata:ctr", "kubepods-besteffort.slice:crio:ctr"} {
+		spec.Linux.CgroupsPath = path
+		assert.NoError(ValidateCgroupsPath(spec, true), "path %q", path)
+		assert.Error(ValidateCgroupsPath(spec, false), "path %q", path)
+	}
+
+	for _, path := range []string{"system.slice:ctr", "system.slice:kata:", "system:kata:ctr",
+		"/system.slice:kata:ctr", "system.slice:kata:a/b", "a:b:c:d"} {
+		spec.Linux.CgroupsPath = path
+		assert.Error(ValidateCgroupsPath(spec, true), "path %q", path)
+	}
+}
+
+func TestValidateMountOptions(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.NoError(ValidateMountOptions(nil))
+	assert.NoError(ValidateMountOptions([]string{"rbind", "ro", "size=65536k", "mode=1777",
+		"lowerdir=/a:/b", "context=system_u:object_r:container_file_t:s0",
+		`context="system_u:object_r:container_file_t:s0:c1,c2"`}))
+
+	for _, o := range []string{"", "ro,exec", "size=64m,suid", "mode=755\nsuid", "uid=0=1", "=1", "nosuid\x00",
+		`context="a",suid`, `context="a"b"`} {
+		assert.Error(ValidateMountOptions([]string{"rbind", o}), "option %q", o)
+	}
+
+	spec := specs.Spec{
+		Mounts: []specs.Mount{
+			{
+				Source:      "tmpfs",
+				Destination: "/tmp",
+				Type:        "tmpfs",
+				Options:     []string{"size=64m,exec,suid"},
+			},
+		},
+	}
+
+	_, err := containerMounts(spec)
+	assert.Error(err)
+}
+
+func TestAddRuntimeAnnotationsTCPBuffers(t *testing.T) {
+	assert := assert.New(t)
+
+	var sandboxConfig vc.SandboxConfig
+	ocispec := specs.Spec{
+		Annotations: map[string]string{
+			vcAnnotations.TCPRmem: "4096 87380  6291456",
+			vcAnnotations.TCPWmem: "4096 16384 4194304",
+		},
+	}
+
+	err := addRuntimeConfigOverrides(ocispec, &sandboxConfig)
+	assert.NoError(err)
+	assert.Equal(map[string]string{
+		"net.ipv4.tcp_rmem": "4096 87380 6291456",
+		"net.ipv4.tcp_wmem": "4096 16384 4194304",
+	}, sandboxConfig.GuestSysctls)
+
+	for _, value := range []string{"4096 87380", "4096 87380 6291456 1", "4096 -1 6291456", "4096 a 6291456", "8192 4096 6291456"} {
+		sandboxConfig = vc.SandboxConfig{}
+		ocispec.Annotations = map[string]string{
+			vcAnnotations.TCPWmem: value,
+		}
+		err = addRuntimeConfigOverrides(ocispec, &sandboxConfig)
+		assert.Error(err, "value %q", value)
+		assert.Nil(sandboxConfig.GuestSysctls)
+	}
+}
+
+func TestNewMountTmpfsSize(t *testing.T) {
+	assert := assert.New(t)
+
+	m := newMount(specs.Mount{
+		Source:      "tmpfs",
+		Destination: "/tmp",
+		Type:        "tmpfs",
+		Options:     []string{"nosuid", "size=100M"},
+	})
+	assert.Equal(uint64(100<<20), m.SizeBytes)
+	assert.Equal([]string{"nosuid", "size=100M"}, m.Options)
+
+	m = newMount(specs.Mount{
+		Source:      "tmpfs",
+		Destination: "/tmp",
+		Type:        "tmpfs",
+		Options:     []string{"nosuid"},
+	})
+	assert.Zero(m.SizeBytes)
+
+	m = newMount(specs.Mount{
+		Source:      "tmpfs",
+		Destination: "/tmp",
+		Type:        "tmpfs",
+		Options:     []string{"size=50%"},
+	})
+	assert.Zero(m.SizeBytes)
+
+	m = newMount(specs.Mount{
+		Source:      "tmpfs",
+		Destination: "/tmp",
+		Type:        "tmpfs",
+		Options:     []string{"size=lots"},
+	})
+	assert.Zero(m.SizeBytes)
+	assert.Equal([]string{"size=lots"}, m.Options)
+
+	// only tmpfs mounts are sized
+	m = newMount(specs.Mount{
+		Source:      "/host/tmp",
+		Destination: "/tmp",
+		Type:        "bind",
+		Options:     []string{"rbind", "size=100M"},
+	})
+	assert.Zero(m.SizeBytes)
+}
+
+func TestAddAgentAnnotationsInitArgs(t *testing.T) {
+	assert := assert.New(t)
+
+	sandboxConfig := vc.SandboxConfig{
+		AgentConfig: vc.KataAgentConfig{},
+	}
+
+	ocispec := specs.Spec{
+		Annotations: map[string]string{},
+	}
+
+	// defaults are kept when the annotation is absent
+	err := addAgentConfigOverrides(ocispec, &sandboxConfig)
+	assert.NoError(err)
+	assert.Exactly(vc.KataAgentConfig{}, sandboxConfig.AgentConfig)
+
+	ocispec.Annotations[vcAnnotations.InitArgs] = " --log-level  debug --unit=custom.target "
+	err = addAgentConfigOverrides(ocispec, &sandboxConfig)
+	assert.NoError(err)
+	assert.Exactly(vc.KataAgentConfig{InitArgs: []string{"--log-level", "debug", "--unit=custom.target"}}, sandboxConfig.AgentConfig)
+
+	ocispec.Annotations[vcAnnotations.InitArgs] = "  "
+	err = addAgentConfigOverrides(ocispec, &sandboxConfig)
+	assert.Error(err)
+}
+
+func TestValidateAssetPaths(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "oci-assets")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	kernelPath := filepath.Join(dir, "kernel")
+	err = ioutil.WriteFile(kernelPath, []byte{}, 0644)
+	assert.NoError(err)
+
+	sandboxConfig := vc.SandboxConfig{
+		HypervisorConfig: vc.HypervisorConfig{
+			KernelPath: kernelPath,
+		},
+		Annotations: map[string]string{},
+	}
+	assert.NoError(ValidateAssetPaths(sandboxConfig))
+
+	sandboxConfig.Annotations[vcAnnotations.KernelPath] = "vmlinux"
+	sandboxConfig.HypervisorConfig.ImagePath = filepath.Join(dir, "image")
+	err = ValidateAssetPaths(sandboxConfig)
+	assert.Error(err)
+	assert.Contains(err.Error(), "\"vmlinux\" is not absolute")
+	assert.Contains(err.Error(), "image path")
+}
+
+func TestContainerConfigPermissiveDevices(t *testing.T) {
+	assert := assert.New(t)
+
+	major := int64(1)
+	minor := int64(3)
+
+	ocispec := specs.Spec{
+		Root:    &specs.Root{Path: "rootfs"},
+		Process: &specs.Process{Args: []string{"sh"}},
+		Linux: &specs.Linux{
+			Resources: &specs.LinuxResources{
+				Devices: []specs.LinuxDeviceCgroup{
+					{Allow: false, Access: "rwm"},
+					{Allow: true, Type: "c", Major: &major, Minor: &minor, Access: "rwm"},
+				},
+			},
+		},
+	}
+
+	containerConfig, err := ContainerConfig(ocispec, tempBundlePath, containerID, consolePath, false)
+	assert.NoError(err)
+	assert.False(containerConfig.PermissiveDevices)
+
+	ocispec.Linux.Resources.Devices = append(ocispec.Linux.Resources.Devices,
+		specs.LinuxDeviceCgroup{Allow: true, Access: "rwm"})
+
+	containerConfig, err = ContainerConfig(ocispec, tempBundlePath, containerID, consolePath, false)
+	assert.NoError(err)
+	assert.True(containerConfig.PermissiveDevices)
+
+	// a deny rule following the wildcard restricts the access again
+	ocispec.Linux.Resources.Devices = append(ocispec.Linux.Resources.Devices,
+		specs.LinuxDeviceCgroup{Allow: false, Type: "c", Major: &major, Minor: &minor, Access: "rwm"})
+
+	containerConfig, err = ContainerConfig(ocispec, tempBundlePath, containerID, consolePath, false)
+	assert.NoError(err)
+	assert.False(containerConfig.PermissiveDevices)
+
+	// read only access to all devices is not permissive
+	ocispec.Linux.Resources.Devices = []specs.LinuxDeviceCgroup{{Allow: true, Type: "a", Access: "r"}}
+
+	containerConfig, err = ContainerConfig(ocispec, tempBundlePath, containerID, consolePath, false)
+	assert.NoError(err)
+	assert.False(containerConfig.PermissiveDevices)
+}
+
+func TestSandboxConfigToOCISpec(t *testing.T) {
+	assert := assert.New(t)
+
+	fileMode := os.FileMode(0666)
+	uid := uint32(0)
+	gid := uint32(0)
+
+	ocispec := specs.Spec{
+		Version:  specs.Version,
+		Hostname: "sandbox",
+		Root:     &specs.Root{Path: "/rootfs", Readonly: true},
+		Process: &specs.Process{
+			Args: []string{"sh"},
+			Env:  []string{"PATH=/bin:/usr/bin", "TERM=xterm"},
+			Cwd:  "/",
+			User: specs.User{UID: 1000, GID: 1000, AdditionalGids: []uint32{10}},
+		},
+		Mounts: []specs.Mount{
+			{
+				Destination: "/data",
+				Source:      "/var/lib/data",
+				Type:        "bind",
+				Options:     []string{"rbind", "rw"},
+			},
+		},
+		Linux: &specs.Linux{
+			Resources: &specs.LinuxResources{},
+			Devices: []specs.LinuxDevice{
+				{
+					Path:     "/dev/null",
+					Type:     "c",
+					Major:    1,
+					Minor:    3,
+					FileMode: &fileMode,
+					UID:      &uid,
+					GID:      &gid,
+				},
+			},
+		},
+	}
+
+	savedFunc := config.GetHostPathFunc
+	config.GetHostPathFunc = func(devInfo config.DeviceInfo) (string, error) {
+		return devInfo.ContainerPath, nil
+	}
+	defer func() {
+		config.GetHostPathFunc = savedFunc
+	}()
+
+	runtimeConfig := RuntimeConfig{
+		HypervisorType: vc.QemuHypervisor,
+		AgentType:      vc.KataContainersAgent,
+		ProxyType:      vc.KataProxyType,
+		ShimType:       vc.KataShimType,
+	}
+
+	sandboxConfig, err := SandboxConfig(ocispec, runtimeConfig, tempBundlePath, containerID, consolePath, false, false)
+	assert.NoError(err)
+
+	exported, err := sandboxConfig.ToOCISpec()
+	assert.NoError(err)
+
+	assert.Equal(ocispec.Hostname, exported.Hostname)
+	assert.Equal(ocispec.Root, exported.Root)
+	assert.Equal(ocispec.Process.Args, exported.Process.Args)
+	assert.Equal(ocispec.Process.Env, exported.Process.Env)
+	assert.Equal(ocispec.Process.Cwd, exported.Process.Cwd)
+	assert.Equal(ocispec.Process.User, exported.Process.User)
+	assert.Equal(ocispec.Mounts, exported.Mounts)
+	assert.Equal(ocispec.Linux.Devices, exported.Linux.Devices)
+	assert.Equal(tempBundlePath, exported.Annotations[vcAnnotations.BundlePathKey])
+	assert.Equal(string(vc.PodSandbox), exported.Annotations[vcAnnotations.ContainerTypeKey])
+
+	sandboxConfig.Containers = nil
+	_, err = sandboxConfig.ToOCISpec()
+	assert.Error(err)
+}
+
+func TestAddHypervisorAnnotationsEntropyBootWait(t *testing.T) {
+	assert := assert.New(t)
+
+	var sandboxConfig vc.SandboxConfig
+	ocispec := specs.Spec{
+		Annotations: map[string]string{
+			vcAnnotations.EntropyBootWait: "false",
+		},
+	}
+
+	err := addHypervisorConfigOverrides(ocispec, &sandboxConfig)
+	assert.NoError(err)
+	assert.Empty(sandboxConfig.HypervisorConfig.KernelParams)
+
+	// the agent can't wait for the guest entropy, and only strict booleans
+	// are accepted
+	for _, value := range []string{"true", "", "1", "yes", "TRUE"} {
+		ocispec.Annotations[vcAnnotations.EntropyBootWait] = value
+
+		err := addHypervisorConfigOverrides(ocispec, &sandboxConfig)
+		assert.Error(err, "value %q", value)
+	}
+}
+
+func TestAddHypervisorAnnotationsHugepageNUMANode(t *testing.T) {
+	assert := assert.New(t)
+
+	sysfs, err := ioutil.TempDir("", "numa-nodes")
+	assert.NoError(err)
+	defer os.RemoveAll(sysfs)
+
+	err = os.Mkdir(filepath.Join(sysfs, "node1"), 0755)
+	assert.NoError(err)
+
+	savedNUMANodePath := numaNodePath
+	numaNodePath = filepath.Join(sysfs, "node%d")
+	defer func() {
+		numaNodePath = savedNUMANodePath
+	}()
+
+	ocispec := specs.Spec{
+		Annotations: map[string]string{
+			vcAnnotations.HugepageNUMANode: "1",
+		},
+	}
+
+	sandboxConfig := vc.SandboxConfig{SandboxCgroupOnly: true}
+	sandboxConfig.HypervisorConfig.HugePages = true
+	err = addHypervisorConfigOverrides(ocispec, &sandboxConfig)
+	assert.NoError(err)
+	assert.NotNil(sandboxConfig.HypervisorConfig.HugepageNUMANode)
+	assert.Equal(uint32(1), *sandboxConfig.HypervisorConfig.HugepageNUMANode)
+
+	// huge pages are not enabled
+	sandboxConfig = vc.SandboxConfig{SandboxCgroupOnly: true}
+	err = addHypervisorConfigOverrides(ocispec, &sandboxConfig)
+	assert.Error(err)
+	assert.Nil(sandboxConfig.HypervisorConfig.HugepageNUMANode)
+
+	// the hypervisor is not in the sandbox cgroup
+	sandboxConfig = vc.SandboxConfig{}
+	sandboxConfig.HypervisorConfig.HugePages = true
+	err = addHypervisorConfigOverrides(ocispec, &sandboxConfig)
+	assert.Error(err)
+	assert.Nil(sandboxConfig.HypervisorConfig.HugepageNUMANode)
+
+	for _, value := range []string{"0", "-1", "node1", ""} {
+		sandboxConfig = vc.SandboxConfig{SandboxCgroupOnly: true}
+		sandboxConfig.HypervisorConfig.HugePages = true
+		ocispec.Annotations[vcAnnotations.HugepageNUMANode] = value
+
+		err = addHypervisorConfigOverrides(ocispec, &sandboxConfig)
+		assert.Error(err, "value %q", value)
+		assert.Nil(sandboxConfig.HypervisorConfig.HugepageNUMANode)
+	}
+}
+
+func TestContainerConfigHookTimeouts(t *testing.T) {
+	assert := assert.New(t)
+
+	zero := 0
+	timeout := 5
+	negative := -1
+
+	ocispec := specs.Spec{
+		Root:    &specs.Root{Path: "rootfs"},
+		Process: &specs.Process{Args: []string{"sh"}},
+		Linux: &specs.Linux{
+			Resources: &specs.LinuxResources{},
+		},
+		Hooks: &specs.Hooks{
+			Prestart: []specs.Hook{
+				{Path: "/usr/bin/unbounded"},
+				{Path: "/usr/bin/zero", Timeout: &zero},
+				{Path: "/usr/bin/bounded", Timeout: &timeout},
+			},
+		},
+	}
+
+	containerConfig, err := ContainerConfig(ocispec, tempBundlePath, containerID, consolePath, false)
+	assert.NoError(err)
+	assert.Equal(ocispec.Hooks.Prestart, containerConfig.Spec.Hooks.Prestart)
+	assert.Equal(timeout, *containerConfig.Spec.Hooks.Prestart[2].Timeout)
+
+	ocispec.Hooks.Poststop = []specs.Hook{{Path: "/usr/bin/malformed", Timeout: &negative}}
+	_, err = ContainerConfig(ocispec, tempBundlePath, containerID, consolePath, false)
+	assert.Error(err)
+
+	// the hooks unknown to specs.Hooks are read from the bundle
+	ocispec.Hooks.Poststop = nil
+	bundlePath, err := ioutil.TempDir("", "oci-hooks")
+	assert.NoError(err)
+	defer os.RemoveAll(bundlePath)
+
+	for _, hookType := range []string{"createRuntime", "createContainer", "startContainer"} {
+		config := fmt.Sprintf(`{"hooks": {"%s": [{"path": "/usr/bin/malformed", "timeout": -1}]}}`, hookType)
+		err = ioutil.WriteFile(filepath.Join(bundlePath, "config.json"), []byte(config), 0644)
+		assert.NoError(err)
+
+		_, err = ContainerConfig(ocispec, bundlePath, containerID, consolePath, false)
+		assert.Error(err, hookType)
+	}
+
+	config := `{"hooks": {"createRuntime": [{"path": "/usr/bin/bounded", "timeout": 5}]}}`
+	err = ioutil.WriteFile(filepath.Join(bundlePath, "config.json"), []byte(config), 0644)
+	assert.NoError(err)
+
+	_, err = ContainerConfig(ocispec, bundlePath, containerID, consolePath, false)
+	assert.NoError(err)
+}
+
+func TestEnvVarsFromFile(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "oci-env")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	os.Setenv("KATA_TEST_INHERITED", "host value")
+	defer os.Unsetenv("KATA_TEST_INHERITED")
+	os.Unsetenv("KATA_TEST_UNSET")
+
+	envFile := filepath.Join(dir, "env")
+	content := `# comment
+PATH=/bin:/usr/bin
+
+  TERM=xterm
+QUOTED="a b"
+EMPTY=
+KATA_TEST_INHERITED
+KATA_TEST_UNSET
+`
+	err = ioutil.WriteFile(envFile, []byte(content), 0644)
+	assert.NoError(err)
+
+	envVars, err := EnvVarsFromFile(envFile)
+	assert.NoError(err)
+	assert.Equal([]types.EnvVar{
+		{Var: "PATH", Value: "/bin:/usr/bin"},
+		{Var: "TERM", Value: "xterm"},
+		{Var: "QUOTED", Value: `"a b"`},
+		{Var: "EMPTY", Value: ""},
+		{Var: "KATA_TEST_INHERITED", Value: "host value"},
+	}, envVars)
+
+	err = ioutil.WriteFile(envFile, []byte("FOO=bar\n\n=value\n"), 0644)
+	assert.NoError(err)
+
+	_, err = EnvVarsFromFile(envFile)
+	assert.Error(err)
+	assert.Contains(err.Error(), ":3:")
+
+	_, err = EnvVarsFromFile(filepath.Join(dir, "missing"))
+	assert.Error(err)
+}
+
+func TestContainerConfigOOMScoreAdj(t *testing.T) {
+	assert := assert.New(t)
+
+	ocispec := specs.Spec{
+		Root:    &specs.Root{Path: "rootfs"},
+		Process: &specs.Process{Args: []string{"sh"}},
+		Linux:   &specs.Linux{Resources: &specs.LinuxResources{}},
+	}
+
+	containerConfig, err := ContainerConfig(ocispec, tempBundlePath, containerID, consolePath, false)
+	assert.NoError(err)
+	assert.Nil(containerConfig.Cmd.OOMScoreAdj)
+
+	for _, value := range []int{-999, 0, 1000} {
+		adj := value
+		ocispec.Process.OOMScoreAdj = &adj
+
+		containerConfig, err := ContainerConfig(ocispec, tempBundlePath, containerID, consolePath, false)
+		assert.NoError(err, "value %d", value)
+		assert.NotNil(containerConfig.Cmd.OOMScoreAdj)
+		assert.Equal(value, *containerConfig.Cmd.OOMScoreAdj)
+	}
+
+	for _, value := range []int{-1001, 1001} {
+		adj := value
+		ocispec.Process.OOMScoreAdj = &adj
+
+		_, err := ContainerConfig(ocispec, tempBundlePath, containerID, consolePath, false)
+		assert.Error(err, "value %d", value)
+	}
+}
+
+func TestContainerConfigSchedPolicy(t *testing.T) {
+	assert := assert.New(t)
+
+	ocispec := specs.Spec{
+		Root:        &specs.Root{Path: "rootfs"},
+		Process:     &specs.Process{Args: []string{"sh"}},
+		Linux:       &specs.Linux{Resources: &specs.LinuxResources{}},
+		Annotations: map[string]string{},
+	}
+
+	_, err := ContainerConfig(ocispec, tempBundlePath, containerID, consolePath, false)
+	assert.NoError(err)
+
+	valid := []map[string]string{
+		{vcAnnotations.SchedPolicy: "other"},
+		{vcAnnotations.SchedPolicy: "other", vcAnnotations.SchedPriority: "0"},
+	}
+
+	for _, a := range valid {
+		ocispec.Annotations = a
+		_, err := ContainerConfig(ocispec, tempBundlePath, containerID, consolePath, false)
+		assert.NoError(err, "%v", a)
+	}
+
+	invalid := []map[string]string{
+		// the agent can't set the real-time policies
+		{vcAnnotations.SchedPolicy: "fifo"},
+		{vcAnnotations.SchedPolicy: "rr", vcAnnotations.SchedPriority: "50"},
+		{vcAnnotations.SchedPolicy: "batch"},
+		{vcAnnotations.SchedPolicy: "FIFO"},
+		{vcAnnotations.SchedPolicy: "other", vcAnnotations.SchedPriority: "10"},
+		{vcAnnotations.SchedPriority: "10"},
+	}
+
+	for _, a := range invalid {
+		ocispec.Annotations = a
+		_, err := ContainerConfig(ocispec, tempBundlePath, containerID, consolePath, false)
+		assert.Error(err, "%v", a)
+	}
+}
+
+func TestValidateGuestMemory(t *testing.T) {
+	assert := assert.New(t)
+
+	limit := int64(1024 << 20)
+	newContainer := func(id string) vc.ContainerConfig {
+		return vc.ContainerConfig{
+			ID: id,
+			Resources: specs.LinuxResources{
+				Memory: &specs.LinuxMemory{Limit: &limit},
+			},
+		}
+	}
+
+	sandboxConfig := vc.SandboxConfig{
+		Containers: []vc.ContainerConfig{newContainer("foo"), newContainer("bar")},
+	}
+
+	// unknown guest memory
+	assert.NoError(ValidateGuestMemory(sandboxConfig))
+
+	sandboxConfig.HypervisorConfig.MemorySize = 2048
+	assert.NoError(ValidateGuestMemory(sandboxConfig))
+
+	// below the limits, only a warning
+	sandboxConfig.HypervisorConfig.MemorySize = 1536
+	assert.NoError(ValidateGuestMemory(sandboxConfig))
+
+	// far below the limits
+	sandboxConfig.HypervisorConfig.MemorySize = 512
+	err := ValidateGuestMemory(sandboxConfig)
+	assert.Error(err)
+	assert.Contains(err.Error(), "2048 MiB")
+}
+
+func TestContainerConfigUnifiedResources(t *testing.T) {
+	assert := assert.New(t)
+
+	bundlePath, err := ioutil.TempDir("", "oci-unified")
+	assert.NoError(err)
+	defer os.RemoveAll(bundlePath)
+
+	err = ioutil.WriteFile(filepath.Join(bundlePath, "config.json"), []byte(`
+		{
+		    "ociVersion": "1.0.2",
+		    "process": {
+		        "args": ["sh"]
+		    },
+		    "root": {
+		        "path": "rootfs"
+		    },
+		    "linux": {
+		        "resources": {
+		            "unified": {
+		                "memory.max": "1073741824"
+		            }
+		        }
+		    }
+		}`), 0644)
+	assert.NoError(err)
+
+	ocispec, err := compatoci.ParseConfigJSON(bundlePath)
+	assert.NoError(err)
+
+	// the unified resources are only translated when sent to the agent
+	containerConfig, err := ContainerConfig(ocispec, bundlePath, containerID, consolePath, false)
+	assert.NoError(err)
+	assert.Equal(map[string]string{"memory.max": "1073741824"}, containerConfig.UnifiedResources)
+	assert.Nil(containerConfig.Resources.Memory)
+
+	err = ioutil.WriteFile(filepath.Join(bundlePath, "config.json"), []byte(`
+		{
+		    "ociVersion": "1.0.2",
+		    "linux": {
+		        "resources": {
+		            "unified": {
+		                "memory.max": "1G"
+		            }
+		        }
+		    }
+		}`), 0644)
+	assert.NoError(err)
+
+	_, err = ContainerConfig(ocispec, bundlePath, containerID, consolePath, false)
+	assert.Error(err)
+}
+
+func TestContainerConfigDisableOOMKiller(t *testing.T) {
+	assert := assert.New(t)
+
+	disableOOMKiller := true
+	ocispec := specs.Spec{
+		Root:    &specs.Root{Path: "rootfs"},
+		Process: &specs.Process{Args: []string{"sh"}},
+		Linux: &specs.Linux{
+			Resources: &specs.LinuxResources{
+				Memory: &specs.LinuxMemory{DisableOOMKiller: &disableOOMKiller},
+			},
+		},
+	}
+
+	// the flag is carried to the agent, whatever the host cgroup mode
+	containerConfig, err := ContainerConfig(ocispec, tempBundlePath, containerID, consolePath, false)
+	assert.NoError(err)
+	assert.NotNil(containerConfig.Resources.Memory)
+	assert.Equal(&disableOOMKiller, containerConfig.Resources.Memory.DisableOOMKiller)
+	assert.Equal(&disableOOMKiller, containerConfig.Spec.Linux.Resources.Memory.DisableOOMKiller)
+}
+
+func TestValidateConfigJSON(t *testing.T) {
+	assert := assert.New(t)
+
+	bundlePath, err := ioutil.TempDir("", "oci-validate")
+	assert.NoError(err)
+	defer os.RemoveAll(bundlePath)
+
+	configPath := filepath.Join(bundlePath, "config.json")
+
+	// missing config.json
+	errs := ValidateConfigJSON(bundlePath)
+	assert.Len(errs, 1)
+
+	err = ioutil.WriteFile(configPath, []byte(`
+		{
+		    "ociVersion": "1.0.1",
+		    "process": {
+		        "args": ["sh"],
+		        "env": ["PATH=/bin"],
+		        "cwd": "/"
+		    },
+		    "root": {"path": "rootfs"},
+		    "linux": {
+		        "devices": [{"path": "/dev/null", "type": "c", "major": 1, "minor": 3}]
+		    }
+		}`), 0644)
+	assert.NoError(err)
+	assert.Empty(ValidateConfigJSON(bundlePath))
+
+	err = ioutil.WriteFile(configPath, []byte(`
+		{
+		    "ociVersion": "1.0.1",
+		    "process": {
+		        "args": [],
+		        "env": ["PATH=/bin", "=foo", "BAR"],
+		        "cwd": "/"
+		    },
+		    "root": {"path": ""},
+		    "linux": {
+		        "devices": [
+		            {"path": "/dev/foo", "type": "x", "major": 1, "minor": 3},
+		            {"path": "/dev/bar", "type": "c", "major": 5000, "minor": 3}
+		        ]
+		    },
+		    "annotations": {
+		        "io.kubernetes.cri-o.ContainerType": "unknown"
+		    }
+		}`), 0644)
+	assert.NoError(err)
+
+	errs = ValidateConfigJSON(bundlePath)
+	assert.Len(errs, 7, "%v", errs)
+
+	// the converted spec is checked with ValidateAll
+	err = ioutil.WriteFile(configPath, []byte(`
+		{
+		    "ociVersion": "1.0.1",
+		    "process": {
+		        "args": ["sh"],
+		        "cwd": "/"
+		    },
+		    "root": {"path": "rootfs"},
+		    "mounts": [{"source": "/data", "destination": "data", "type": "bind", "options": ["rbind"]}],
+		    "linux": {}
+		}`), 0644)
+	assert.NoError(err)
+
+	errs = ValidateConfigJSON(bundlePath)
+	assert.Len(errs, 1, "%v", errs)
+	assert.Contains(errs[0].Error(), `mount destination "data" is not absolute`)
+}
+
+func TestContainerConfigDefaultPATH(t *testing.T) {
+	assert := assert.New(t)
+
+	ocispec := specs.Spec{
+		Root:    &specs.Root{Path: "rootfs"},
+		Process: &specs.Process{Args: []string{"sh"}},
+		Linux:   &specs.Linux{Resources: &specs.LinuxResources{}},
+	}
+
+	// no environment, PATH is injected
+	containerConfig, err := ContainerConfig(ocispec, tempBundlePath, containerID, consolePath, false)
+	assert.NoError(err)
+	assert.Equal([]types.EnvVar{{Var: "PATH", Value: DefaultPATH}}, containerConfig.Cmd.Envs)
+	assert.Equal([]string{"PATH=" + DefaultPATH}, containerConfig.Spec.Process.Env)
+	assert.Empty(ocispec.Process.Env)
+
+	// the other variables are preserved
+	ocispec.Process.Env = []string{"TERM=xterm"}
+	containerConfig, err = ContainerConfig(ocispec, tempBundlePath, containerID, consolePath, false)
+	assert.NoError(err)
+	assert.Equal([]types.EnvVar{{Var: "TERM", Value: "xterm"}, {Var: "PATH", Value: DefaultPATH}}, containerConfig.Cmd.Envs)
+
+	// PATH set by the spec is preserved
+	ocispec.Process.Env = []string{"PATH=/opt/bin", "TERM=xterm"}
+	containerConfig, err = ContainerConfig(ocispec, tempBundlePath, containerID, consolePath, false)
+	assert.NoError(err)
+	assert.Equal([]types.EnvVar{{Var: "PATH", Value: "/opt/bin"}, {Var: "TERM", Value: "xterm"}}, containerConfig.Cmd.Envs)
+	assert.Equal(ocispec.Process.Env, containerConfig.Spec.Process.Env)
+}
+
+func TestAddRuntimeAnnotationsIOScheduler(t *testing.T) {
+	assert := assert.New(t)
+
+	var sandboxConfig vc.SandboxConfig
+	err := addRuntimeConfigOverrides(specs.Spec{}, &sandboxConfig)
+	assert.NoError(err)
+
+	// the agent has no request to set the I/O scheduler
+	for _, scheduler := range []string{"none", "mq-deadline", "kyber", "cfq"} {
+		ocispec := specs.Spec{
+			Annotations: map[string]string{
+				vcAnnotations.IOScheduler: scheduler,
+			},
+		}
+
+		err := addRuntimeConfigOverrides(ocispec, &sandboxConfig)
+		assert.Error(err, "scheduler %q", scheduler)
+	}
+}
+
+func TestAddRuntimeAnnotationsHostname(t *testing.T) {
+	assert := assert.New(t)
+
+	sandboxConfig := vc.SandboxConfig{
+		Hostname: "spec-hostname",
+	}
+	ocispec := specs.Spec{
+		Hostname: "spec-hostname",
+		Annotations: map[string]string{
+			vcAnnotations.Hostname: "guest-hostname",
+		},
+	}
+
+	err := addRuntimeConfigOverrides(ocispec, &sandboxConfig)
+	assert.NoError(err)
+	assert.Equal("guest-hostname", sandboxConfig.Hostname)
+
+	// an empty value falls back to the spec hostname
+	sandboxConfig.Hostname = "spec-hostname"
+	ocispec.Annotations[vcAnnotations.Hostname] = ""
+	err = addRuntimeConfigOverrides(ocispec, &sandboxConfig)
+	assert.NoError(err)
+	assert.Equal("spec-hostname", sandboxConfig.Hostname)
+
+	delete(ocispec.Annotations, vcAnnotations.Hostname)
+	err = addRuntimeConfigOverrides(ocispec, &sandboxConfig)
+	assert.NoError(err)
+	assert.Equal("spec-hostname", sandboxConfig.Hostname)
+}
+
+func TestValidateCommandPath(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, command := range []string{"/bin/sh", "sh", "python3.8", "g++", "./run.sh", "../bin/app"} {
+		assert.NoError(validateCommandPath(command), "command %q", command)
+	}
+
+	for _, command := range []string{"", "/bin/sh -c", "sh\t", "usr/bin/sh", "sh;ls", "$HOME/app"} {
+		assert.Error(validateCommandPath(command), "command %q", command)
+	}
+
+	// a malformed command is only a warning
+	ocispec := specs.Spec{
+		Root:    &specs.Root{Path: "rootfs"},
+		Process: &specs.Process{Args: []string{"usr/bin /sh"}},
+		Linux:   &specs.Linux{Resources: &specs.LinuxResources{}},
+	}
+
+	containerConfig, err := ContainerConfig(ocispec, tempBundlePath, containerID, consolePath, false)
+	assert.NoError(err)
+	assert.Equal([]string{"usr/bin /sh"}, containerConfig.Cmd.Args)
+}
+
+func TestValidateResolvConf(t *testing.T) {
+	assert := assert.New(t)
+
+	netns := specs.LinuxNamespace{Type: specs.NetworkNamespace, Path: "/var/run/netns/test"}
+	ocispec := specs.Spec{
+		Linux: &specs.Linux{
+			Namespaces: []specs.LinuxNamespace{netns},
+		},
+	}
+
+	err := validateResolvConf(ocispec)
+	assert.Error(err)
+	assert.Contains(err.Error(), "/etc/resolv.conf")
+
+	// no network access
+	ocispec.Linux.Namespaces = []specs.LinuxNamespace{{Type: specs.NetworkNamespace}}
+	assert.NoError(validateResolvConf(ocispec))
+
+	ocispec.Linux.Namespaces = []specs.LinuxNamespace{netns}
+	ocispec.Annotations = map[string]string{vcAnnotations.DNSConfigured: "true"}
+	assert.NoError(validateResolvConf(ocispec))
+
+	ocispec.Annotations[vcAnnotations.DNSConfigured] = "false"
+	assert.Error(validateResolvConf(ocispec))
+
+	ocispec.Mounts = []specs.Mount{
+		{
+			Source:      "/var/lib/containers/resolv.conf",
+			Destination: "/etc/resolv.conf",
+			Type:        "bind",
+			Options:     []string{"rbind", "ro"},
+		},
+	}
+	assert.NoError(validateResolvConf(ocispec))
+}
+
+func TestParseCPUList(t *testing.T) {
+	assert := assert.New(t)
+
+	for list, expected := range map[string][]int{
+		"0":         {0},
+		"7":         {7},
+		"0-3":       {0, 1, 2, 3},
+		"0-3,7":     {0, 1, 2, 3, 7},
+		"7, 2-3, 2": {2, 3, 7},
+		"4-4":       {4},
+	} {
+		cpus, err := parseCPUList(list)
+		assert.NoError(err, "list %q", list)
+		assert.Equal(expected, cpus, "list %q", list)
+	}
+
+	for _, list := range []string{"", "5-2", "a", "1-b", "0,,1", "-1", "1-", "0-3-5"} {
+		_, err := parseCPUList(list)
+		assert.Error(err, "list %q", list)
+	}
+}
+
+func TestContainerConfigSeccomp(t *testing.T) {
+	assert := assert.New(t)
+
+	seccomp := &specs.LinuxSeccomp{
+		DefaultAction: specs.ActErrno,
+		Architectures: []specs.Arch{specs.ArchX86_64},
+		Syscalls: []specs.LinuxSyscall{
+			{
+				Names:  []string{"read", "write"},
+				Action: specs.ActAllow,
+			},
+			{
+				Names:  []string{"personality"},
+				Action: specs.ActAllow,
+				Args: []specs.LinuxSeccompArg{
+					{Index: 0, Value: 0, Op: specs.OpEqualTo},
+				},
+			},
+		},
+	}
+
+	ocispec := specs.Spec{
+		Root:    &specs.Root{Path: "rootfs"},
+		Process: &specs.Process{Args: []string{"sh"}},
+		Linux: &specs.Linux{
+			Resources: &specs.LinuxResources{},
+			Seccomp:   seccomp,
+		},
+	}
+
+	containerConfig, err := ContainerConfig(ocispec, tempBundlePath, containerID, consolePath, false)
+	assert.NoError(err)
+	assert.Equal(seccomp, containerConfig.Spec.Linux.Seccomp)
+	assert.Len(containerConfig.Spec.Linux.Seccomp.Syscalls, 2)
+
+	// no seccomp block, unconfined
+	ocispec.Linux.Seccomp = nil
+	containerConfig, err = ContainerConfig(ocispec, tempBundlePath, containerID, consolePath, false)
+	assert.NoError(err)
+	assert.Nil(containerConfig.Spec.Linux.Seccomp)
+}
+
+func TestContainerConfigRootfsReadonly(t *testing.T) {
+	assert := assert.New(t)
+
+	ocispec := specs.Spec{
+		Root:        &specs.Root{Path: "rootfs", Readonly: true},
+		Process:     &specs.Process{Args: []string{"sh"}},
+		Linux:       &specs.Linux{Resources: &specs.LinuxResources{}},
+		Annotations: map[string]string{},
+	}
+
+	// no annotation, the spec decides
+	containerConfig, err := ContainerConfig(ocispec, tempBundlePath, containerID, consolePath, false)
+	assert.NoError(err)
+	assert.True(containerConfig.ReadonlyRootfs)
+
+	// override to writable
+	ocispec.Annotations[vcAnnotations.RootfsReadonly] = "false"
+	containerConfig, err = ContainerConfig(ocispec, tempBundlePath, containerID, consolePath, false)
+	assert.NoError(err)
+	assert.False(containerConfig.ReadonlyRootfs)
+
+	// override to readonly
+	ocispec.Root.Readonly = false
+	ocispec.Annotations[vcAnnotations.RootfsReadonly] = "true"
+	containerConfig, err = ContainerConfig(ocispec, tempBundlePath, containerID, consolePath, false)
+	assert.NoError(err)
+	assert.True(containerConfig.ReadonlyRootfs)
+
+	for _, value := range []string{"", "no", "1", "False"} {
+		ocispec.Annotations[vcAnnotations.RootfsReadonly] = value
+		_, err = ContainerConfig(ocispec, tempBundlePath, containerID, consolePath, false)
+		assert.Error(err, "value %q", value)
+	}
+}
+
+func TestContainerConfigCPUAffinity(t *testing.T) {
+	assert := assert.New(t)
+
+	ocispec := specs.Spec{
+		Root:    &specs.Root{Path: "rootfs"},
+		Process: &specs.Process{Args: []string{"sh"}},
+		Linux:   &specs.Linux{Resources: &specs.LinuxResources{}},
+		Annotations: map[string]string{
+			vcAnnotations.CPUAffinity: "0-3,7",
+		},
+	}
+
+	containerConfig, err := ContainerConfig(ocispec, tempBundlePath, containerID, consolePath, false)
+	assert.NoError(err)
+	assert.Equal([]int{0, 1, 2, 3, 7}, containerConfig.CPUAffinity)
+
+	ocispec.Annotations[vcAnnotations.CPUAffinity] = "5-2"
+	_, err = ContainerConfig(ocispec, tempBundlePath, containerID, consolePath, false)
+	assert.Error(err)
+	assert.Contains(err.Error(), vcAnnotations.CPUAffinity)
+
+	delete(ocispec.Annotations, vcAnnotations.CPUAffinity)
+	containerConfig, err = ContainerConfig(ocispec, tempBundlePath, containerID, consolePath, false)
+	assert.NoError(err)
+	assert.Empty(containerConfig.CPUAffinity)
+}
+
+func TestContainerConfigRlimits(t *testing.T) {
+	assert := assert.New(t)
+
+	ocispec := specs.Spec{
+		Root: &specs.Root{Path: "rootfs"},
+		Process: &specs.Process{
+			Args: []string{"sh"},
+			Rlimits: []specs.POSIXRlimit{
+				{Type: "RLIMIT_NOFILE", Soft: 65536, Hard: 1048576},
+				{Type: "RLIMIT_FOO", Soft: 1, Hard: 2},
+				{Type: "RLIMIT_CORE", Soft: 0, Hard: 0},
+			},
+		},
+		Linux: &specs.Linux{Resources: &specs.LinuxResources{}},
+	}
+
+	containerConfig, err := ContainerConfig(ocispec, tempBundlePath, containerID, consolePath, false)
+	assert.NoError(err)
+	assert.Equal([]types.Rlimit{
+		{Type: "RLIMIT_NOFILE", Soft: 65536, Hard: 1048576},
+		{Type: "RLIMIT_CORE", Soft: 0, Hard: 0},
+	}, containerConfig.Cmd.Rlimits)
+}
@@ -170,11 +170,19 @@ func (q *qemu) kernelParameters() string {
 	// a serial or vsock channel
 	params = append(params, Param{vsockKernelOption, strconv.FormatBool(q.config.UseVSock)})
 
+	// the watchdog timeout is the heartbeat of the guest driver
+	if q.config.EnableWatchdog && q.config.WatchdogTimeout > 0 {
+		params = append(params, Param{"i6300esb.heartbeat", fmt.Sprintf("%d", q.config.WatchdogTimeout)})
+	}
+
 	// add the params specified by the provided config. As the kernel
 	// honours the last parameter value set and since the config-provided
 	// params are added here, they will take priority over the defaults.
 	params = append(params, q.config.KernelParams...)
 
+	// the init arguments must come last
+	params = appendInitArgs(params, q.config.InitArgs)
+
 	paramsStr := SerializeParams(params, "=")
 
 	return strings.Join(paramsStr, " ")
@@ -419,6 +427,32 @@ func (q *qemu) buildDevices(initrdPath string) ([]govmmQemu.Device, *govmmQemu.I
 		}
 	}
 
+	if q.config.EnableWatchdog {
+		devices, err = q.arch.appendWatchdog(devices)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if q.config.BlockDeviceCacheMode == "writethrough" {
+		writeCacheOff := globalProperty{
+			driver:   writeCacheDriver(q.config.BlockDeviceDriver),
+			property: "write-cache",
+			value:    "off",
+		}
+		if writeCacheOff.Valid() {
+			devices = append(devices, writeCacheOff)
+		}
+	}
+
+	if q.config.SharedFS == config.VirtioFS && q.config.VirtioFSQueueSize > 0 {
+		devices = append(devices, globalProperty{
+			driver:   "vhost-user-fs-device",
+			property: "queue-size",
+			value:    strconv.FormatUint(uint64(q.config.VirtioFSQueueSize), 10),
+		})
+	}
+
 	var ioThread *govmmQemu.IOThread
 	if q.config.BlockDeviceDriver == config.VirtioSCSI {
 		return q.arch.appendSCSIController(devices, q.config.EnableIOThreads)
@@ -428,6 +462,51 @@ func (q *qemu) buildDevices(initrdPath string) ([]govmmQemu.Device, *govmmQemu.I
 
 }
 
+// watchdogDevice is an Intel 6300ESB watchdog, resetting the guest when
+// the guest driver stops feeding it.
+type watchdogDevice struct{}
+
+// Valid returns true as the watchdog device has no configuration.
+func (w watchdogDevice) Valid() bool {
+	return true
+}
+
+// QemuParams returns the qemu parameters built out of the watchdog device.
+func (w watchdogDevice) QemuParams(_ *govmmQemu.Config) []string {
+	return []string{"-device", "i6300esb", "-watchdog-action", "reset"}
+}
+
+// globalProperty sets the default value of a property for every device
+// created with driver, including the hotplugged ones.
+type globalProperty struct {
+	driver   string
+	property string
+	value    string
+}
+
+// Valid returns true if the driver and the property are known.
+func (g globalProperty) Valid() bool {
+	return g.driver != "" && g.property != ""
+}
+
+// QemuParams returns the qemu parameters built out of the global property.
+func (g globalProperty) QemuParams(_ *govmmQemu.Config) []string {
+	return []string{"-global", fmt.Sprintf("%s.%s=%s", g.driver, g.property, g.value)}
+}
+
+// writeCacheDriver returns the qemu driver holding the write cache property
+// of the block devices hotplugged with blockDeviceDriver.
+func writeCacheDriver(blockDeviceDriver string) string {
+	switch blockDeviceDriver {
+	case config.VirtioBlock, config.VirtioBlockCCW:
+		return "virtio-blk-device"
+	case config.VirtioSCSI:
+		return "scsi-hd"
+	default:
+		return ""
+	}
+}
+
 func (q *qemu) setupTemplate(knobs *govmmQemu.Knobs, memory *govmmQemu.Memory) govmmQemu.Incoming {
 	incoming := govmmQemu.Incoming{}
 
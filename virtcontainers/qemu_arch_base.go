@@ -97,6 +97,9 @@ type qemuArch interface {
 	// appendRNGDevice appends a RNG device to devices
 	appendRNGDevice(devices []govmmQemu.Device, rngDevice config.RNGDev) ([]govmmQemu.Device, error)
 
+	// appendWatchdog appends a watchdog device to devices
+	appendWatchdog(devices []govmmQemu.Device) ([]govmmQemu.Device, error)
+
 	// addDeviceToBridge adds devices to the bus
 	addDeviceToBridge(ID string, t types.Type) (string, types.Bridge, error)
 
@@ -613,6 +616,10 @@ func (q *qemuArchBase) appendRNGDevice(devices []govmmQemu.Device, rngDev config
 	return devices, nil
 }
 
+func (q *qemuArchBase) appendWatchdog(devices []govmmQemu.Device) ([]govmmQemu.Device, error) {
+	return append(devices, watchdogDevice{}), nil
+}
+
 func (q *qemuArchBase) handleImagePath(config HypervisorConfig) {
 	if config.ImagePath != "" {
 		q.kernelParams = append(q.kernelParams, kernelRootParams...)
@@ -7,6 +7,7 @@ package virtcontainers
 
 import (
 	"context"
+	"fmt"
 	"io/ioutil"
 	"os"
 	"runtime"
@@ -196,6 +197,12 @@ func (q *qemuArm64) appendImage(devices []govmmQemu.Device, path string) ([]govm
 	return devices, nil
 }
 
+// appendWatchdog throws an error as the i6300esb watchdog is not generally
+// available on the virt machine.
+func (q *qemuArm64) appendWatchdog(devices []govmmQemu.Device) ([]govmmQemu.Device, error) {
+	return nil, fmt.Errorf("No watchdog device supported on arm64")
+}
+
 func (q *qemuArm64) setIgnoreSharedMemoryMigrationCaps(_ context.Context, _ *govmmQemu.QMP) error {
 	// x-ignore-shared not support in arm64 for now
 	return nil
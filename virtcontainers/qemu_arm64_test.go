@@ -156,3 +156,11 @@ func TestQemuArm64AppendImage(t *testing.T) {
 
 	assert.Equal(expectedOut, devices)
 }
+
+func TestQemuArm64AppendWatchdog(t *testing.T) {
+	assert := assert.New(t)
+	arm64 := newTestQemu(QemuVirt)
+
+	_, err := arm64.appendWatchdog(nil)
+	assert.Error(err)
+}
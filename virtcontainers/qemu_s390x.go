@@ -162,6 +162,12 @@ func (q *qemuS390x) appendVhostUserDevice(devices []govmmQemu.Device, attr confi
 	return nil, fmt.Errorf("No vhost-user devices supported on s390x")
 }
 
+// appendWatchdog throws an error as the i6300esb watchdog is a PCI device,
+// which the ccw machine doesn't provide.
+func (q *qemuS390x) appendWatchdog(devices []govmmQemu.Device) ([]govmmQemu.Device, error) {
+	return nil, fmt.Errorf("No watchdog device supported on s390x")
+}
+
 // supportGuestMemoryHotplug return false for s390x architecture. The pc-dimm backend device for s390x
 // is not support. PC-DIMM is not listed in the devices supported by qemu-system-s390x -device help
 func (q *qemuS390x) supportGuestMemoryHotplug() bool {
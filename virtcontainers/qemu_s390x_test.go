@@ -65,3 +65,11 @@ func TestQemuS390xAppendVhostUserDevice(t *testing.T) {
 	_, err := qemu.appendVhostUserDevice(nil, vhostUserDevice)
 	assert.Error(err)
 }
+
+func TestQemuS390xAppendWatchdog(t *testing.T) {
+	qemu := qemuS390x{}
+	assert := assert.New(t)
+
+	_, err := qemu.appendWatchdog(nil)
+	assert.Error(err)
+}
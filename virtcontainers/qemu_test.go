@@ -73,6 +73,22 @@ func TestQemuKernelParameters(t *testing.T) {
 	testQemuKernelParameters(t, params, expectedOut, false)
 }
 
+func TestQemuKernelParametersInitArgs(t *testing.T) {
+	assert := assert.New(t)
+
+	qemuConfig := newQemuConfig()
+	qemuConfig.KernelParams = []Param{{Key: "foo", Value: "foo"}}
+	qemuConfig.InitArgs = []string{"--log-level", "debug"}
+
+	q := &qemu{
+		config: qemuConfig,
+		arch:   &qemuArchBase{},
+	}
+
+	expected := fmt.Sprintf("panic=1 nr_cpus=%d agent.use_vsock=false foo=foo -- --log-level debug", MaxQemuVCPUs())
+	assert.Equal(expected, q.kernelParameters())
+}
+
 func TestQemuCreateSandbox(t *testing.T) {
 	qemuConfig := newQemuConfig()
 	q := &qemu{}
@@ -567,3 +583,87 @@ func TestQemuGetpids(t *testing.T) {
 	assert.True(pids[0] == 100)
 	assert.True(pids[1] == 200)
 }
+
+func TestQemuBuildDevicesWatchdog(t *testing.T) {
+	assert := assert.New(t)
+
+	q := &qemu{
+		ctx:    context.Background(),
+		id:     "testSandboxID",
+		config: newQemuConfig(),
+		arch:   &qemuArchBase{},
+	}
+
+	devices, _, err := q.buildDevices(testQemuInitrdPath)
+	assert.NoError(err)
+	assert.NotContains(devices, watchdogDevice{})
+
+	q.config.EnableWatchdog = true
+	devices, _, err = q.buildDevices(testQemuInitrdPath)
+	assert.NoError(err)
+	assert.Contains(devices, watchdogDevice{})
+
+	assert.Equal([]string{"-device", "i6300esb", "-watchdog-action", "reset"}, watchdogDevice{}.QemuParams(nil))
+
+	q.config.WatchdogTimeout = 60
+	assert.Contains(q.kernelParameters(), "i6300esb.heartbeat=60")
+}
+
+func TestQemuBuildDevicesBlockDeviceCacheMode(t *testing.T) {
+	assert := assert.New(t)
+
+	q := &qemu{
+		ctx:    context.Background(),
+		id:     "testSandboxID",
+		config: newQemuConfig(),
+		arch:   &qemuArchBase{},
+	}
+	q.config.BlockDeviceDriver = config.VirtioBlock
+	writeCacheOff := globalProperty{
+		driver:   "virtio-blk-device",
+		property: "write-cache",
+		value:    "off",
+	}
+
+	q.config.BlockDeviceCacheMode = "writeback"
+	devices, _, err := q.buildDevices(testQemuInitrdPath)
+	assert.NoError(err)
+	assert.NotContains(devices, writeCacheOff)
+
+	q.config.BlockDeviceCacheMode = "writethrough"
+	devices, _, err = q.buildDevices(testQemuInitrdPath)
+	assert.NoError(err)
+	assert.Contains(devices, writeCacheOff)
+
+	assert.Equal([]string{"-global", "virtio-blk-device.write-cache=off"},
+		writeCacheOff.QemuParams(nil))
+	assert.Equal("scsi-hd", writeCacheDriver(config.VirtioSCSI))
+	assert.Empty(writeCacheDriver(config.Nvdimm))
+}
+
+func TestQemuBuildDevicesVirtioFSQueueSize(t *testing.T) {
+	assert := assert.New(t)
+
+	q := &qemu{
+		ctx:    context.Background(),
+		id:     "testSandboxID",
+		config: newQemuConfig(),
+		arch:   &qemuArchBase{},
+	}
+	q.config.SharedFS = config.VirtioFS
+	queueSize := globalProperty{
+		driver:   "vhost-user-fs-device",
+		property: "queue-size",
+		value:    "512",
+	}
+
+	devices, _, err := q.buildDevices(testQemuInitrdPath)
+	assert.NoError(err)
+	assert.NotContains(devices, queueSize)
+
+	q.config.VirtioFSQueueSize = 512
+	devices, _, err = q.buildDevices(testQemuInitrdPath)
+	assert.NoError(err)
+	assert.Contains(devices, queueSize)
+	assert.Equal([]string{"-global", "vhost-user-fs-device.queue-size=512"}, queueSize.QemuParams(nil))
+}
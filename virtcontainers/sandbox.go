@@ -13,12 +13,16 @@ import (
 	"net"
 	"os"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"syscall"
+	"time"
 
 	"github.com/containerd/cgroups"
 	"github.com/containernetworking/plugins/pkg/ns"
+	merr "github.com/hashicorp/go-multierror"
 	specs "github.com/opencontainers/runtime-spec/specs-go"
 	opentracing "github.com/opentracing/opentracing-go"
 	"github.com/pkg/errors"
@@ -112,6 +116,18 @@ type SandboxConfig struct {
 
 	DisableGuestSeccomp bool
 
+	// TransparentHugepage is the guest transparent hugepage policy, one of
+	// "always", "madvise" or "never". It is passed to the guest kernel
+	// through the transparent_hugepage parameter. The guest kernel default
+	// is kept when empty.
+	TransparentHugepage string
+
+	// GuestSysctls are the guest wide kernel parameters, indexed by their
+	// sysctl name (e.g. "vm.panic_on_oom"). They are passed on the guest
+	// kernel command line as "sysctl." parameters, so that they are set
+	// for the whole VM before the agent starts.
+	GuestSysctls map[string]string
+
 	// Experimental features enabled
 	Experimental []exp.Feature
 }
@@ -159,6 +175,692 @@ func (sandboxConfig *SandboxConfig) valid() bool {
 	return true
 }
 
+// validateHostname checks that a hostname only contains valid characters.
+// Hostnames longer than maxHostnameLen are truncated by the agent, and are
+// therefore not rejected here.
+func validateHostname(hostname string) error {
+	for _, r := range hostname {
+		if !(r >= 'a' && r <= 'z') && !(r >= 'A' && r <= 'Z') && !(r >= '0' && r <= '9') && r != '-' && r != '.' {
+			return fmt.Errorf("hostname %q contains invalid character %q", hostname, r)
+		}
+	}
+
+	return nil
+}
+
+// validateContainerIDs checks that no two containers of the sandbox share
+// the same ID, as the containers state is tracked by ID.
+func (sandboxConfig SandboxConfig) validateContainerIDs() error {
+	ids := make(map[string]bool)
+	for _, c := range sandboxConfig.Containers {
+		if ids[c.ID] {
+			return fmt.Errorf("duplicate container ID %q", c.ID)
+		}
+		ids[c.ID] = true
+	}
+
+	return nil
+}
+
+// ValidateAssetPaths checks that every asset path of the sandbox
+// configuration, whether set through an annotation or through the hypervisor
+// configuration, is absolute and exists on the host. All the invalid paths
+// are reported in a single error.
+func ValidateAssetPaths(config SandboxConfig) error {
+	paths := []struct {
+		name string
+		path string
+	}{
+		{"kernel", config.HypervisorConfig.KernelPath},
+		{"image", config.HypervisorConfig.ImagePath},
+		{"initrd", config.HypervisorConfig.InitrdPath},
+		{"firmware", config.HypervisorConfig.FirmwarePath},
+		{annotations.KernelPath, config.Annotations[annotations.KernelPath]},
+		{annotations.ImagePath, config.Annotations[annotations.ImagePath]},
+		{annotations.InitrdPath, config.Annotations[annotations.InitrdPath]},
+	}
+
+	var result *merr.Error
+	for _, p := range paths {
+		if p.path == "" {
+			continue
+		}
+
+		if !filepath.IsAbs(p.path) {
+			result = merr.Append(result, fmt.Errorf("%s path %q is not absolute", p.name, p.path))
+			continue
+		}
+
+		if _, err := os.Stat(p.path); err != nil {
+			result = merr.Append(result, fmt.Errorf("%s path %q is not accessible: %v", p.name, p.path, err))
+		}
+	}
+
+	return result.ErrorOrNil()
+}
+
+// appendValidationErrors appends to errs each of the errors aggregated by a
+// validator, prefixed by the name of the configuration they relate to.
+func appendValidationErrors(errs []error, name string, err error) []error {
+	if err == nil {
+		return errs
+	}
+
+	if result, ok := err.(*merr.Error); ok {
+		for _, e := range result.Errors {
+			errs = append(errs, fmt.Errorf("%s: %v", name, e))
+		}
+		return errs
+	}
+
+	return append(errs, fmt.Errorf("%s: %v", name, err))
+}
+
+// ValidateAll runs every validation of the sandbox configuration, and of
+// each of its containers, and returns all the problems found rather than
+// stopping at the first one. This provides a complete pre-flight report.
+func (sandboxConfig SandboxConfig) ValidateAll() []error {
+	var errs []error
+
+	if sandboxConfig.ID == "" {
+		errs = append(errs, fmt.Errorf("sandbox: ID cannot be empty"))
+	}
+
+	if err := validateHostname(sandboxConfig.Hostname); err != nil {
+		errs = append(errs, fmt.Errorf("sandbox: %v", err))
+	}
+
+	for i, p := range sandboxConfig.HypervisorConfig.KernelParams {
+		if p.Key == "" {
+			errs = append(errs, fmt.Errorf("sandbox: kernel parameter %d: empty key for value %q", i, p.Value))
+		}
+	}
+
+	if err := sandboxConfig.validateContainerIDs(); err != nil {
+		errs = append(errs, fmt.Errorf("sandbox: %v", err))
+	}
+
+	errs = appendValidationErrors(errs, "sandbox", ValidateAssetPaths(sandboxConfig))
+
+	for _, c := range sandboxConfig.Containers {
+		errs = append(errs, c.validationErrors()...)
+	}
+
+	return errs
+}
+
+// tmpfsSize returns the size in bytes requested by the "size=" option of a
+// tmpfs mount, or 0 when the size is not set or cannot be determined.
+func tmpfsSize(m Mount) uint64 {
+	if m.Type != "tmpfs" {
+		return 0
+	}
+
+	if m.SizeBytes > 0 {
+		return m.SizeBytes
+	}
+
+	for _, o := range m.Options {
+		if !strings.HasPrefix(o, "size=") {
+			continue
+		}
+
+		size, err := utils.ParseMemorySize(strings.TrimPrefix(o, "size="))
+		if err != nil {
+			// Percentages of the memory are not accounted for.
+			return 0
+		}
+
+		return size
+	}
+
+	return 0
+}
+
+// EstimatedScratchMiB returns a lower bound of the writable scratch space in
+// MiB that the sandbox workloads might need. It is computed from the sizes of
+// the tmpfs mounts of every container and the shm size, and saturates at
+// math.MaxUint32.
+func (sandboxConfig SandboxConfig) EstimatedScratchMiB() uint32 {
+	total := sandboxConfig.ShmSize
+
+	for _, c := range sandboxConfig.Containers {
+		for _, m := range c.Mounts {
+			// The shm size is already accounted for.
+			if m.Destination == "/dev/shm" {
+				continue
+			}
+
+			size := tmpfsSize(m)
+			if total > math.MaxUint64-size {
+				return math.MaxUint32
+			}
+			total += size
+		}
+	}
+
+	// Round up to the next MiB.
+	mib := total >> utils.MibToBytesShift
+	if total&(1<<utils.MibToBytesShift-1) != 0 {
+		mib++
+	}
+
+	if mib > math.MaxUint32 {
+		return math.MaxUint32
+	}
+
+	return uint32(mib)
+}
+
+// MemoryOvercommitRatio returns the sum of the containers memory limits
+// divided by the guest memory. A ratio greater than 1 means the containers
+// could be OOM killed before reaching their limits. Unset and unlimited
+// memory limits count as zero.
+func (sandboxConfig SandboxConfig) MemoryOvercommitRatio() float64 {
+	var limits uint64
+
+	for _, c := range sandboxConfig.Containers {
+		if c.Resources.Memory == nil || c.Resources.Memory.Limit == nil || *c.Resources.Memory.Limit <= 0 {
+			continue
+		}
+
+		limits += uint64(*c.Resources.Memory.Limit)
+	}
+
+	if limits == 0 {
+		return 0
+	}
+
+	guestMemory := uint64(sandboxConfig.HypervisorConfig.MemorySize) << utils.MibToBytesShift
+	if guestMemory == 0 {
+		return math.Inf(1)
+	}
+
+	return float64(limits) / float64(guestMemory)
+}
+
+// hypervisorBootOverheadMiB is the approximate host memory used by every
+// hypervisor on top of the guest memory: hypervisor process, firmware and
+// device emulation.
+var hypervisorBootOverheadMiB = map[HypervisorType]uint32{
+	QemuHypervisor:        48,
+	FirecrackerHypervisor: 8,
+	AcrnHypervisor:        24,
+}
+
+// agentBootOverheadMiB is the approximate guest memory used by the agent.
+const agentBootOverheadMiB = 16
+
+// BootOverheadMiB returns an estimation of the memory, on top of the
+// containers requests, needed to boot the sandbox VM: the hypervisor and
+// agent fixed overheads, plus the virtio-fs DAX window when enabled.
+func (sandboxConfig SandboxConfig) BootOverheadMiB() uint32 {
+	overhead := hypervisorBootOverheadMiB[sandboxConfig.HypervisorType] + agentBootOverheadMiB
+
+	hConfig := sandboxConfig.HypervisorConfig
+	if hConfig.SharedFS == config.VirtioFS && hConfig.VirtioFSCacheSize > 0 {
+		overhead += hConfig.VirtioFSCacheSize
+	}
+
+	return overhead
+}
+
+const (
+	// bootTimeoutPerDevice is the extra boot time allowed for every device
+	// of the containers.
+	bootTimeoutPerDevice = 500 * time.Millisecond
+
+	// bootTimeoutPerGiB is the extra boot time allowed for every GiB of
+	// guest memory, which may have to be pre-allocated.
+	bootTimeoutPerGiB = 1 * time.Second
+
+	// bootTimeoutVirtioFS is the extra boot time allowed for virtiofsd to
+	// start before the VM.
+	bootTimeoutVirtioFS = 2 * time.Second
+)
+
+// EstimatedBootTimeout returns a timeout for the VM boot suited to the
+// sandbox configuration. The base vmStartTimeout is increased for every
+// device of the containers, every GiB of guest memory, and for the
+// virtio-fs daemon.
+func (sandboxConfig SandboxConfig) EstimatedBootTimeout() time.Duration {
+	timeout := vmStartTimeout * time.Second
+
+	for _, c := range sandboxConfig.Containers {
+		timeout += time.Duration(len(c.DeviceInfos)) * bootTimeoutPerDevice
+	}
+
+	// Round the memory up to the next GiB.
+	memoryGiB := (sandboxConfig.HypervisorConfig.MemorySize + 1023) / 1024
+	timeout += time.Duration(memoryGiB) * bootTimeoutPerGiB
+
+	if sandboxConfig.HypervisorConfig.SharedFS == config.VirtioFS {
+		timeout += bootTimeoutVirtioFS
+	}
+
+	return timeout
+}
+
+// TemplateCompatible returns true if both sandbox configurations can boot
+// from the same VM template, that is if the VM factory accepts the VMs
+// created for one of them for the other. The containers and the settings
+// which are not part of the VM configuration are ignored.
+func (sandboxConfig SandboxConfig) TemplateCompatible(other SandboxConfig) bool {
+	return sandboxConfig.vmConfig().CheckCompatible(other.vmConfig()) == nil
+}
+
+// vmConfig returns the configuration of the sandbox VM.
+func (sandboxConfig SandboxConfig) vmConfig() VMConfig {
+	return VMConfig{
+		HypervisorType:   sandboxConfig.HypervisorType,
+		HypervisorConfig: sandboxConfig.HypervisorConfig,
+		AgentType:        sandboxConfig.AgentType,
+		AgentConfig:      sandboxConfig.AgentConfig,
+		ProxyType:        sandboxConfig.ProxyType,
+		ProxyConfig:      sandboxConfig.ProxyConfig,
+	}
+}
+
+// hugepageSizeMiB is the size of the default huge pages backing the guest
+// memory.
+const hugepageSizeMiB = 2
+
+// HugepageMemoryMiB returns the guest memory in MiB backed by huge pages,
+// which the host must reserve before starting the sandbox. It is the guest
+// memory rounded up to the huge page size when huge pages are enabled, zero
+// otherwise.
+func (sandboxConfig SandboxConfig) HugepageMemoryMiB() uint32 {
+	if !sandboxConfig.HypervisorConfig.HugePages {
+		return 0
+	}
+
+	memory := sandboxConfig.HypervisorConfig.MemorySize
+	return (memory + hugepageSizeMiB - 1) / hugepageSizeMiB * hugepageSizeMiB
+}
+
+// NeedsVhostNet returns true if the host vhost-net module is needed by the
+// sandbox network. Only QEMU uses vhost-net, for every queue of the
+// interfaces cross connected with a tap based interworking model, a queue
+// per vCPU being created with multi-queue. No interface is set up when the
+// network namespace creation or vhost-net is disabled.
+func (sandboxConfig SandboxConfig) NeedsVhostNet() bool {
+	if sandboxConfig.HypervisorType != QemuHypervisor || sandboxConfig.HypervisorConfig.DisableVhostNet {
+		return false
+	}
+
+	if sandboxConfig.NetworkConfig.DisableNewNetNs {
+		return false
+	}
+
+	switch sandboxConfig.NetworkConfig.InterworkingModel {
+	case NetXConnectNoneModel, NetXConnectInvalidModel:
+		return false
+	}
+
+	return true
+}
+
+// UnionCapabilities returns the sorted union of the effective capabilities
+// of every container of the sandbox, telling which capabilities the sandbox
+// as a whole relies on.
+func (sandboxConfig SandboxConfig) UnionCapabilities() []string {
+	union := make(map[string]bool)
+	for _, c := range sandboxConfig.Containers {
+		for _, capability := range c.Cmd.EffectiveCapabilities() {
+			union[capability] = true
+		}
+	}
+
+	capabilities := make([]string, 0, len(union))
+	for capability := range union {
+		capabilities = append(capabilities, capability)
+	}
+	sort.Strings(capabilities)
+
+	return capabilities
+}
+
+// RunAsIDs returns the sorted and distinct UIDs and GIDs the processes of
+// the sandbox containers run as, from the user, primary group and
+// supplementary groups of their commands. Users and groups given by name
+// cannot be resolved on the host and are skipped.
+func (sandboxConfig SandboxConfig) RunAsIDs() (uids, gids []uint32) {
+	uidSet := make(map[uint32]bool)
+	gidSet := make(map[uint32]bool)
+
+	addID := func(set map[uint32]bool, id string) {
+		if n, err := strconv.ParseUint(id, 10, 32); err == nil {
+			set[uint32(n)] = true
+		}
+	}
+
+	for _, c := range sandboxConfig.Containers {
+		addID(uidSet, c.Cmd.User)
+		addID(gidSet, c.Cmd.PrimaryGroup)
+		for _, g := range c.Cmd.SupplementaryGroups {
+			addID(gidSet, g)
+		}
+	}
+
+	sortedIDs := func(set map[uint32]bool) []uint32 {
+		ids := make([]uint32, 0, len(set))
+		for id := range set {
+			ids = append(ids, id)
+		}
+		sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+		return ids
+	}
+
+	return sortedIDs(uidSet), sortedIDs(gidSet)
+}
+
+// AllShareNetwork returns true if every container of the sandbox joins the
+// sandbox network namespace, which is the expected topology. A container
+// asks for its own network namespace when its spec has a network namespace
+// without path, which only the sandbox container is expected to do, or a
+// path other than the sandbox one. Containers without spec share the sandbox
+// network.
+func (sandboxConfig SandboxConfig) AllShareNetwork() bool {
+	for _, c := range sandboxConfig.Containers {
+		if c.Spec == nil || c.Spec.Linux == nil {
+			continue
+		}
+
+		for _, ns := range c.Spec.Linux.Namespaces {
+			if ns.Type != specs.NetworkNamespace {
+				continue
+			}
+
+			if ns.Path == "" {
+				if c.Annotations[annotations.ContainerTypeKey] != string(PodSandbox) {
+					return false
+				}
+			} else if ns.Path != sandboxConfig.NetworkConfig.NetNSPath {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+// vmNameMaxLen is the maximum length of a VM name: the "kata-" prefix
+// followed by a 12 characters short sandbox ID.
+const vmNameMaxLen = 17
+
+// VMName returns a deterministic name for the VM of the sandbox, made of the
+// "kata-" prefix and the short sandbox ID. Characters of the ID which are not
+// alphanumeric, '-' or '_' are replaced by '-'.
+func (sandboxConfig SandboxConfig) VMName() string {
+	id := strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '-' || r == '_' {
+			return r
+		}
+		return '-'
+	}, sandboxConfig.ID)
+
+	return utils.MakeNameID("kata", id, vmNameMaxLen)
+}
+
+// mountTypeGuestModules maps the filesystem types which are usually built as
+// modules to the guest kernel modules they require.
+var mountTypeGuestModules = map[string]string{
+	"overlay":  "overlay",
+	"nfs":      "nfs",
+	"nfs4":     "nfsv4",
+	"cifs":     "cifs",
+	"fuse":     "fuse",
+	"squashfs": "squashfs",
+}
+
+// MountRequiredGuestModules returns the sorted list of guest kernel modules
+// required by the mounts of the sandbox containers, so that they can be
+// loaded before mounting.
+func (sandboxConfig SandboxConfig) MountRequiredGuestModules() []string {
+	var modules []string
+	seen := make(map[string]bool)
+
+	for _, c := range sandboxConfig.Containers {
+		for _, m := range c.Mounts {
+			module, ok := mountTypeGuestModules[m.Type]
+			if !ok || seen[module] {
+				continue
+			}
+
+			seen[module] = true
+			modules = append(modules, module)
+		}
+	}
+
+	sort.Strings(modules)
+
+	return modules
+}
+
+// IsConfidential returns true if the sandbox guest is protected by a
+// confidential computing technology. The signals are:
+//   - the ConfidentialGuest flag of the hypervisor configuration, set for
+//     Intel TDX or AMD SEV guests,
+//   - the "mem_encrypt=on" guest kernel parameter, enabling the AMD SEV
+//     memory encryption.
+func (sandboxConfig SandboxConfig) IsConfidential() bool {
+	if sandboxConfig.HypervisorConfig.ConfidentialGuest {
+		return true
+	}
+
+	for _, p := range sandboxConfig.HypervisorConfig.KernelParams {
+		if p.Key == "mem_encrypt" && p.Value == "on" {
+			return true
+		}
+	}
+
+	return false
+}
+
+// HostCgroupPaths returns the host cgroup paths, relative to the cgroup
+// hierarchies mount point, used by the sandbox:
+//   - the sandbox cgroup, next to the cgroup of the sandbox container,
+//   - the cgroup without constraints of the hypervisor, unless
+//     SandboxCgroupOnly is set,
+//   - the cgroup of every container,
+//   - with systemd cgroups, the scope of every container, the
+//     "slice:prefix:name" cgroups path being expanded into the matching
+//     systemd hierarchy.
+//
+// The runtime cgroups paths are derived as when the cgroups are created. A
+// path shared by several cgroups is only listed once.
+func (sandboxConfig SandboxConfig) HostCgroupPaths() []string {
+	var paths []string
+	seen := make(map[string]bool)
+
+	addPath := func(path string) {
+		if !seen[path] {
+			seen[path] = true
+			paths = append(paths, path)
+		}
+	}
+
+	for _, c := range sandboxConfig.Containers {
+		if c.Spec == nil || c.Spec.Linux == nil || c.Annotations[annotations.ContainerTypeKey] != string(PodSandbox) {
+			continue
+		}
+
+		path := sandboxCgroupPath(c.Spec.Linux.CgroupsPath, sandboxConfig.ID)
+		addPath(path)
+		if !sandboxConfig.SandboxCgroupOnly {
+			addPath(cgroupNoConstraintsPath(path))
+		}
+		break
+	}
+
+	for _, c := range sandboxConfig.Containers {
+		if c.Spec == nil || c.Spec.Linux == nil {
+			continue
+		}
+
+		path, err := containerCgroupPath(c.Spec.Linux.CgroupsPath)
+		if err != nil {
+			continue
+		}
+		addPath(path)
+	}
+
+	if sandboxConfig.SystemdCgroup {
+		for _, c := range sandboxConfig.Containers {
+			if c.Spec == nil || c.Spec.Linux == nil {
+				continue
+			}
+
+			if path, ok := systemdScopePath(c.Spec.Linux.CgroupsPath); ok {
+				addPath(path)
+			}
+		}
+	}
+
+	return paths
+}
+
+// systemdScopePath expands a "slice:prefix:name" systemd cgroups path into
+// the path of the scope in the systemd hierarchy. The slice defaults to
+// "system.slice".
+func systemdScopePath(cgroupsPath string) (string, bool) {
+	fields := strings.Split(cgroupsPath, ":")
+	if len(fields) != 3 || fields[1] == "" || fields[2] == "" {
+		return "", false
+	}
+
+	slice := fields[0]
+	if slice == "" {
+		slice = "system.slice"
+	}
+
+	return filepath.Join(expandSystemdSlice(slice), fields[1]+"-"+fields[2]+".scope"), true
+}
+
+// expandSystemdSlice expands a systemd slice name into its path, each dash
+// of the name denoting a parent slice, e.g. "a-b.slice" is "/a.slice/a-b.slice".
+func expandSystemdSlice(slice string) string {
+	name := strings.TrimSuffix(slice, ".slice")
+	if name == "-" || name == "" {
+		return "/"
+	}
+
+	path := "/"
+	prefix := ""
+	for _, component := range strings.Split(name, "-") {
+		prefix += component
+		path = filepath.Join(path, prefix+".slice")
+		prefix += "-"
+	}
+
+	return path
+}
+
+// ToOCISpec rebuilds an approximate OCI specification from the sandbox
+// container configuration, for debugging and export purposes. The
+// conversion is lossy: only the process, root, mounts, devices, resources,
+// annotations and hostname are restored, and the mount options are the ones
+// translated for the agent.
+func (sandboxConfig SandboxConfig) ToOCISpec() (specs.Spec, error) {
+	var c *ContainerConfig
+	for i := range sandboxConfig.Containers {
+		if sandboxConfig.Containers[i].Annotations[annotations.ContainerTypeKey] == string(PodSandbox) {
+			c = &sandboxConfig.Containers[i]
+			break
+		}
+	}
+
+	if c == nil {
+		return specs.Spec{}, fmt.Errorf("No sandbox container found in sandbox %s", sandboxConfig.ID)
+	}
+
+	uid, err := strconv.ParseUint(c.Cmd.User, 10, 32)
+	if err != nil {
+		return specs.Spec{}, fmt.Errorf("Invalid user %q: %v", c.Cmd.User, err)
+	}
+
+	gid, err := strconv.ParseUint(c.Cmd.PrimaryGroup, 10, 32)
+	if err != nil {
+		return specs.Spec{}, fmt.Errorf("Invalid primary group %q: %v", c.Cmd.PrimaryGroup, err)
+	}
+
+	process := &specs.Process{
+		Terminal: c.Cmd.Interactive,
+		User: specs.User{
+			UID: uint32(uid),
+			GID: uint32(gid),
+		},
+		Args:            c.Cmd.Args,
+		Cwd:             c.Cmd.WorkDir,
+		Capabilities:    c.Cmd.Capabilities,
+		NoNewPrivileges: c.Cmd.NoNewPrivileges,
+	}
+
+	for _, g := range c.Cmd.SupplementaryGroups {
+		additionalGid, err := strconv.ParseUint(g, 10, 32)
+		if err != nil {
+			return specs.Spec{}, fmt.Errorf("Invalid supplementary group %q: %v", g, err)
+		}
+		process.User.AdditionalGids = append(process.User.AdditionalGids, uint32(additionalGid))
+	}
+
+	for _, e := range c.Cmd.Envs {
+		process.Env = append(process.Env, e.Var+"="+e.Value)
+	}
+
+	var mounts []specs.Mount
+	for _, m := range c.Mounts {
+		mounts = append(mounts, specs.Mount{
+			Destination: m.Destination,
+			Type:        m.Type,
+			Source:      m.Source,
+			Options:     m.Options,
+		})
+	}
+
+	resources := c.Resources
+	linux := &specs.Linux{
+		Resources: &resources,
+	}
+
+	for _, d := range c.DeviceInfos {
+		fileMode := d.FileMode
+		uid := d.UID
+		gid := d.GID
+
+		linux.Devices = append(linux.Devices, specs.LinuxDevice{
+			Path:     d.ContainerPath,
+			Type:     d.DevType,
+			Major:    d.Major,
+			Minor:    d.Minor,
+			FileMode: &fileMode,
+			UID:      &uid,
+			GID:      &gid,
+		})
+	}
+
+	specAnnotations := make(map[string]string)
+	for k, v := range sandboxConfig.Annotations {
+		specAnnotations[k] = v
+	}
+	for k, v := range c.Annotations {
+		specAnnotations[k] = v
+	}
+
+	return specs.Spec{
+		Version: specs.Version,
+		Process: process,
+		Root: &specs.Root{
+			Path:     c.RootFs.Target,
+			Readonly: c.ReadonlyRootfs,
+		},
+		Hostname:    sandboxConfig.Hostname,
+		Mounts:      mounts,
+		Annotations: specAnnotations,
+		Linux:       linux,
+	}, nil
+}
+
 // Sandbox is composed of a set of containers and a runtime environment.
 // A Sandbox can be created, deleted, started, paused, stopped, listed, entered, and restored.
 type Sandbox struct {
@@ -310,6 +1012,7 @@ func (s *Sandbox) Status() SandboxStatus {
 			StartTime:   c.process.StartTime,
 			RootFs:      rootfs,
 			Annotations: c.config.Annotations,
+			ExitCode:    c.state.ExitCode,
 		})
 	}
 
@@ -518,6 +1221,36 @@ func createSandbox(ctx context.Context, sandboxConfig SandboxConfig, factory Fac
 	return s, nil
 }
 
+// guestKernelParams returns the kernel parameters of the sandbox hypervisor
+// configuration, extended with the ones setting the guest wide policies and
+// sysctls.
+func guestKernelParams(sandboxConfig *SandboxConfig) []Param {
+	params := append([]Param{}, sandboxConfig.HypervisorConfig.KernelParams...)
+
+	if sandboxConfig.TransparentHugepage != "" {
+		params = append(params, Param{"transparent_hugepage", sandboxConfig.TransparentHugepage})
+	}
+
+	// Sorted for a stable kernel command line.
+	var sysctls []string
+	for key := range sandboxConfig.GuestSysctls {
+		sysctls = append(sysctls, key)
+	}
+	sort.Strings(sysctls)
+
+	for _, key := range sysctls {
+		value := sandboxConfig.GuestSysctls[key]
+		// Values holding several fields, like the TCP buffer sizes,
+		// have to be quoted on the kernel command line.
+		if strings.ContainsAny(value, " \t") {
+			value = fmt.Sprintf("\"%s\"", value)
+		}
+		params = append(params, Param{"sysctl." + key, value})
+	}
+
+	return params
+}
+
 func newSandbox(ctx context.Context, sandboxConfig SandboxConfig, factory Factory) (*Sandbox, error) {
 	span, ctx := trace(ctx, "newSandbox")
 	defer span.Finish()
@@ -526,6 +1259,10 @@ func newSandbox(ctx context.Context, sandboxConfig SandboxConfig, factory Factor
 		return nil, fmt.Errorf("Invalid sandbox configuration")
 	}
 
+	if err := sandboxConfig.validateContainerIDs(); err != nil {
+		return nil, err
+	}
+
 	agent := newAgent(sandboxConfig.AgentType)
 
 	hypervisor, err := newHypervisor(sandboxConfig.HypervisorType)
@@ -576,6 +1313,12 @@ func newSandbox(ctx context.Context, sandboxConfig SandboxConfig, factory Factor
 		}
 	}()
 
+	if c, ok := sandboxConfig.AgentConfig.(KataAgentConfig); ok && len(c.InitArgs) > 0 {
+		sandboxConfig.HypervisorConfig.InitArgs = c.InitArgs
+	}
+
+	sandboxConfig.HypervisorConfig.KernelParams = guestKernelParams(&sandboxConfig)
+
 	if s.supportNewStore() {
 		s.devManager = deviceManager.NewDeviceManager(sandboxConfig.HypervisorConfig.BlockDeviceDriver, nil)
 
@@ -991,14 +1734,7 @@ func (s *Sandbox) startVM() (err error) {
 
 	if err := s.network.Run(s.networkNS.NetNsPath, func() error {
 		if s.factory != nil {
-			vm, err := s.factory.GetVM(ctx, VMConfig{
-				HypervisorType:   s.config.HypervisorType,
-				HypervisorConfig: s.config.HypervisorConfig,
-				AgentType:        s.config.AgentType,
-				AgentConfig:      s.config.AgentConfig,
-				ProxyType:        s.config.ProxyType,
-				ProxyConfig:      s.config.ProxyConfig,
-			})
+			vm, err := s.factory.GetVM(ctx, s.config.vmConfig())
 			if err != nil {
 				return err
 			}
@@ -1301,6 +2037,7 @@ func (s *Sandbox) StatusContainer(containerID string) (ContainerStatus, error) {
 				StartTime:   c.process.StartTime,
 				RootFs:      rootfs,
 				Annotations: c.config.Annotations,
+				ExitCode:    c.state.ExitCode,
 			}, nil
 		}
 	}
@@ -1905,14 +2642,22 @@ func (s *Sandbox) cgroupsUpdate() error {
 		return err
 	}
 
+	var resources specs.LinuxResources
 	if len(s.containers) <= 1 {
-		// nothing to update
-		return nil
-	}
+		// nothing to update but the pinning of the vCPUs
+		cpus := s.cpuAffinity()
+		if cpus == "" {
+			return nil
+		}
 
-	resources, err := s.resources()
-	if err != nil {
-		return err
+		resources.CPU = &specs.LinuxCPU{
+			Cpus: cpus,
+		}
+	} else {
+		resources, err = s.resources()
+		if err != nil {
+			return err
+		}
 	}
 
 	if err := cgroup.Update(&resources); err != nil {
@@ -2092,11 +2837,26 @@ func (s *Sandbox) cpuResources() *specs.LinuxCPU {
 		}
 	}
 
+	cpu.Cpus += s.cpuAffinity()
 	cpu.Cpus = strings.Trim(cpu.Cpus, " \n\t,")
 
 	return validCPUResources(cpu)
 }
 
+// cpuAffinity returns the host CPUs the containers of the sandbox are pinned
+// to, as a cpuset list, or an empty string when none is. The vCPUs are shared
+// by all the containers, so they are pinned to the union of the CPUs.
+func (s *Sandbox) cpuAffinity() string {
+	var cpus []string
+	for _, c := range s.containers {
+		for _, cpu := range c.config.CPUAffinity {
+			cpus = append(cpus, strconv.Itoa(cpu))
+		}
+	}
+
+	return strings.Join(cpus, ",")
+}
+
 // setupSandboxCgroup creates and joins sandbox cgroups for the sandbox config
 func (s *Sandbox) setupSandboxCgroup() error {
 	spec := s.GetOCISpec()
@@ -2110,11 +2870,21 @@ func (s *Sandbox) setupSandboxCgroup() error {
 		s.Logger().WithField("sandboxid", s.id).Warning("no cgroup path provided for pod sandbox, not creating sandbox cgroup")
 		return nil
 	}
-	validContainerCgroup := utils.ValidCgroupPath(spec.Linux.CgroupsPath)
+
+	resources := specs.LinuxResources{}
+
+	// With SandboxCgroupOnly, which the annotation requires, the hypervisor
+	// runs in the sandbox cgroup: restricting its memory nodes makes the
+	// huge pages come from the requested host NUMA node.
+	if s.config.HypervisorConfig.HugePages && s.config.HypervisorConfig.HugepageNUMANode != nil {
+		resources.CPU = &specs.LinuxCPU{
+			Mems: strconv.FormatUint(uint64(*s.config.HypervisorConfig.HugepageNUMANode), 10),
+		}
+	}
 
 	// Create a Kata sandbox cgroup with the cgroup of the sandbox container as the parent
-	s.state.CgroupPath = filepath.Join(filepath.Dir(validContainerCgroup), cgroupKataPrefix+"_"+s.id)
-	cgroup, err := cgroupsNewFunc(cgroups.V1, cgroups.StaticPath(s.state.CgroupPath), &specs.LinuxResources{})
+	s.state.CgroupPath = sandboxCgroupPath(spec.Linux.CgroupsPath, s.id)
+	cgroup, err := cgroupsNewFunc(cgroups.V1, cgroups.StaticPath(s.state.CgroupPath), &resources)
 	if err != nil {
 		return fmt.Errorf("Could not create sandbox cgroup in %v: %v", s.state.CgroupPath, err)
 
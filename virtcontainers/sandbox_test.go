@@ -10,6 +10,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"math"
 	"os"
 	"os/exec"
 	"path"
@@ -17,7 +18,9 @@ import (
 	"sync"
 	"syscall"
 	"testing"
+	"time"
 
+	"github.com/containerd/cgroups"
 	"github.com/kata-containers/runtime/virtcontainers/device/config"
 	"github.com/kata-containers/runtime/virtcontainers/device/drivers"
 	"github.com/kata-containers/runtime/virtcontainers/device/manager"
@@ -25,6 +28,7 @@ import (
 	"github.com/kata-containers/runtime/virtcontainers/pkg/annotations"
 	"github.com/kata-containers/runtime/virtcontainers/store"
 	"github.com/kata-containers/runtime/virtcontainers/types"
+	"github.com/kata-containers/runtime/virtcontainers/utils"
 	specs "github.com/opencontainers/runtime-spec/specs-go"
 	"github.com/stretchr/testify/assert"
 	"golang.org/x/sys/unix"
@@ -1499,6 +1503,29 @@ func TestSandboxUpdateResources(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestSandboxCPUAffinity(t *testing.T) {
+	assert := assert.New(t)
+
+	s := &Sandbox{
+		containers: map[string]*Container{
+			"foo": {
+				config: &ContainerConfig{
+					Annotations: map[string]string{},
+				},
+			},
+		},
+	}
+	assert.Empty(s.cpuAffinity())
+	assert.Empty(s.cpuResources().Cpus)
+
+	s.containers["foo"].config.CPUAffinity = []int{0, 1, 2, 3}
+	s.containers["foo"].config.Resources.CPU = &specs.LinuxCPU{
+		Cpus: "4",
+	}
+	assert.Equal("0,1,2,3", s.cpuAffinity())
+	assert.Equal("4,0,1,2,3", s.cpuResources().Cpus)
+}
+
 func TestSandboxExperimentalFeature(t *testing.T) {
 	testFeature := exp.Feature{
 		Name:        "mock",
@@ -1593,3 +1620,681 @@ func TestSandbox_SetupSandboxCgroup(t *testing.T) {
 		})
 	}
 }
+
+func TestSandboxSetupSandboxCgroupHugepageNUMANode(t *testing.T) {
+	assert := assert.New(t)
+
+	var resources *specs.LinuxResources
+	oldCgroupsNew := cgroupsNewFunc
+	cgroupsNewFunc = func(hierarchy cgroups.Hierarchy, path cgroups.Path, r *specs.LinuxResources, opts ...cgroups.InitOpts) (cgroups.Cgroup, error) {
+		resources = r
+		return &mockCgroup{}, nil
+	}
+	defer func() {
+		cgroupsNewFunc = oldCgroupsNew
+	}()
+
+	sandboxContainer := ContainerConfig{
+		Spec: newEmptySpec(),
+		Annotations: map[string]string{
+			annotations.ContainerTypeKey: string(PodSandbox),
+		},
+	}
+
+	numaNode := uint32(1)
+	s := &Sandbox{
+		config: &SandboxConfig{
+			HypervisorConfig: HypervisorConfig{
+				HugePages:        true,
+				HugepageNUMANode: &numaNode,
+			},
+			Containers: []ContainerConfig{sandboxContainer},
+		},
+	}
+
+	assert.NoError(s.setupSandboxCgroup())
+	assert.NotNil(resources.CPU)
+	assert.Equal("1", resources.CPU.Mems)
+
+	s.config.HypervisorConfig.HugepageNUMANode = nil
+	assert.NoError(s.setupSandboxCgroup())
+	assert.Nil(resources.CPU)
+}
+
+func TestGuestKernelParams(t *testing.T) {
+	assert := assert.New(t)
+
+	sandboxConfig := &SandboxConfig{
+		HypervisorConfig: HypervisorConfig{
+			KernelParams: []Param{{"quiet", ""}},
+		},
+	}
+	assert.Equal([]Param{{"quiet", ""}}, guestKernelParams(sandboxConfig))
+
+	sandboxConfig.TransparentHugepage = "madvise"
+	assert.Equal([]Param{{"quiet", ""}, {"transparent_hugepage", "madvise"}}, guestKernelParams(sandboxConfig))
+	assert.Len(sandboxConfig.HypervisorConfig.KernelParams, 1)
+
+	sandboxConfig.TransparentHugepage = ""
+	sandboxConfig.GuestSysctls = map[string]string{
+		"vm.swappiness":     "10",
+		"net.ipv4.tcp_rmem": "4096 87380 6291456",
+		"kernel.pid_max":    "65536",
+	}
+	assert.Equal([]Param{
+		{"quiet", ""},
+		{"sysctl.kernel.pid_max", "65536"},
+		{"sysctl.net.ipv4.tcp_rmem", `"4096 87380 6291456"`},
+		{"sysctl.vm.swappiness", "10"},
+	}, guestKernelParams(sandboxConfig))
+}
+
+func TestSandboxConfigEstimatedScratchMiB(t *testing.T) {
+	assert := assert.New(t)
+
+	config := SandboxConfig{
+		ShmSize: DefaultShmSize,
+		Containers: []ContainerConfig{
+			{
+				Mounts: []Mount{
+					{
+						Source:      "tmpfs",
+						Destination: "/tmp",
+						Type:        "tmpfs",
+						Options:     []string{"nosuid", "size=100m"},
+					},
+					{
+						Source:      "shm",
+						Destination: "/dev/shm",
+						Type:        "tmpfs",
+						Options:     []string{"size=65536k"},
+					},
+					{
+						Source:      "/host/data",
+						Destination: "/data",
+						Type:        "bind",
+					},
+				},
+			},
+			{
+				Mounts: []Mount{
+					{
+						Source:      "tmpfs",
+						Destination: "/run",
+						Type:        "tmpfs",
+						Options:     []string{"size=1048577"},
+					},
+					{
+						Source:      "tmpfs",
+						Destination: "/var/tmp",
+						Type:        "tmpfs",
+						Options:     []string{"size=50%"},
+					},
+				},
+			},
+		},
+	}
+
+	// 64 MiB of shm, 100 MiB of /tmp and 1 MiB + 1 byte of /run
+	assert.Equal(uint32(166), config.EstimatedScratchMiB())
+
+	assert.Equal(uint32(0), SandboxConfig{}.EstimatedScratchMiB())
+
+	// more MiB than a uint32 holds
+	config.ShmSize = (uint64(math.MaxUint32) + 1) << utils.MibToBytesShift
+	assert.Equal(uint32(math.MaxUint32), config.EstimatedScratchMiB())
+
+	// the sizes sum overflows
+	config.ShmSize = math.MaxUint64
+	assert.Equal(uint32(math.MaxUint32), config.EstimatedScratchMiB())
+}
+
+func TestSandboxConfigValidateAll(t *testing.T) {
+	assert := assert.New(t)
+
+	limit := int64(-5)
+	quota := int64(50000)
+
+	sandboxConfig := SandboxConfig{
+		ID:       testSandboxID,
+		Hostname: "valid-host.example",
+		HypervisorConfig: HypervisorConfig{
+			KernelParams: []Param{{Key: "foo", Value: "bar"}},
+		},
+		Containers: []ContainerConfig{
+			{
+				ID: "foo",
+				Mounts: []Mount{
+					{Source: "/host", Destination: "/data", Type: "bind"},
+				},
+				DeviceInfos: []config.DeviceInfo{
+					{ContainerPath: "/dev/vfio/17", DevType: "c"},
+				},
+				Cmd: types.Cmd{
+					Capabilities: &specs.LinuxCapabilities{
+						Bounding: []string{"CAP_KILL", "net_raw"},
+					},
+				},
+			},
+		},
+	}
+
+	assert.Empty(sandboxConfig.ValidateAll())
+
+	sandboxConfig.Hostname = "invalid_host"
+	sandboxConfig.HypervisorConfig.KernelParams = append(sandboxConfig.HypervisorConfig.KernelParams, Param{Value: "bar"})
+	sandboxConfig.Containers = append(sandboxConfig.Containers, ContainerConfig{
+		ID: "bar",
+		Mounts: []Mount{
+			{Source: "/host", Destination: "relative/data", Type: "bind"},
+		},
+		DeviceInfos: []config.DeviceInfo{
+			{ContainerPath: "/dev/foo", DevType: "x"},
+		},
+		Cmd: types.Cmd{
+			Capabilities: &specs.LinuxCapabilities{
+				Effective: []string{"CAP_NET_RWA"},
+			},
+		},
+		Resources: specs.LinuxResources{
+			Memory: &specs.LinuxMemory{Limit: &limit},
+			CPU:    &specs.LinuxCPU{Quota: &quota},
+		},
+	})
+
+	sandboxConfig.HypervisorConfig.KernelPath = "vmlinux"
+	sandboxConfig.HypervisorConfig.ImagePath = "/nonexistent/kata/image"
+
+	errs := sandboxConfig.ValidateAll()
+	assert.Len(errs, 9)
+
+	var msgs []string
+	for _, err := range errs {
+		msgs = append(msgs, err.Error())
+	}
+
+	assert.Contains(msgs[0], "hostname")
+	assert.Contains(msgs[1], "kernel parameter 1")
+	assert.Contains(msgs[2], `kernel path "vmlinux" is not absolute`)
+	assert.Contains(msgs[3], "image path")
+	for _, msg := range msgs[4:] {
+		assert.Contains(msg, `container "bar"`)
+	}
+	assert.Contains(msgs[4], "relative/data")
+	assert.Contains(msgs[5], "device 0")
+	assert.Contains(msgs[6], "CAP_NET_RWA")
+	assert.Contains(msgs[7], "memory limit")
+	assert.Contains(msgs[8], "CPU quota")
+}
+
+func TestSandboxConfigMemoryOvercommitRatio(t *testing.T) {
+	assert := assert.New(t)
+
+	limit := int64(1536 << 20)
+	unlimited := int64(-1)
+
+	sandboxConfig := SandboxConfig{
+		HypervisorConfig: HypervisorConfig{
+			MemorySize: 2048,
+		},
+		Containers: []ContainerConfig{
+			{
+				Resources: specs.LinuxResources{
+					Memory: &specs.LinuxMemory{Limit: &limit},
+				},
+			},
+			{
+				Resources: specs.LinuxResources{
+					Memory: &specs.LinuxMemory{Limit: &unlimited},
+				},
+			},
+			{},
+		},
+	}
+
+	assert.Equal(0.75, sandboxConfig.MemoryOvercommitRatio())
+
+	sandboxConfig.Containers = append(sandboxConfig.Containers, sandboxConfig.Containers[0])
+	assert.Equal(1.5, sandboxConfig.MemoryOvercommitRatio())
+
+	assert.Equal(float64(0), SandboxConfig{}.MemoryOvercommitRatio())
+}
+
+func TestSandboxConfigVMName(t *testing.T) {
+	assert := assert.New(t)
+
+	sandboxConfig := SandboxConfig{
+		ID: "4a0b2c3d4e5f60718293a4b5c6d7e8f9",
+	}
+	assert.Equal("kata-4a0b2c3d4e5f", sandboxConfig.VMName())
+	assert.Equal(sandboxConfig.VMName(), sandboxConfig.VMName())
+
+	sandboxConfig.ID = "my pod/1"
+	assert.Equal("kata-my-pod-1", sandboxConfig.VMName())
+
+	sandboxConfig.ID = "sandbox.with:many*invalid"
+	name := sandboxConfig.VMName()
+	assert.Equal("kata-sandbox-with", name)
+	assert.Len(name, vmNameMaxLen)
+}
+
+func TestSandboxConfigMountRequiredGuestModules(t *testing.T) {
+	assert := assert.New(t)
+
+	sandboxConfig := SandboxConfig{
+		Containers: []ContainerConfig{
+			{
+				Mounts: []Mount{
+					{Source: "overlay", Destination: "/merged", Type: "overlay"},
+					{Source: "proc", Destination: "/proc", Type: "proc"},
+				},
+			},
+			{
+				Mounts: []Mount{
+					{Source: "server:/export", Destination: "/nfs", Type: "nfs"},
+					{Source: "overlay", Destination: "/other", Type: "overlay"},
+				},
+			},
+		},
+	}
+
+	assert.Equal([]string{"nfs", "overlay"}, sandboxConfig.MountRequiredGuestModules())
+	assert.Empty(SandboxConfig{}.MountRequiredGuestModules())
+}
+
+func TestSandboxConfigIsConfidential(t *testing.T) {
+	assert := assert.New(t)
+
+	sandboxConfig := SandboxConfig{
+		HypervisorConfig: HypervisorConfig{
+			KernelParams: []Param{{Key: "quiet"}},
+		},
+	}
+	assert.False(sandboxConfig.IsConfidential())
+
+	sandboxConfig.HypervisorConfig.ConfidentialGuest = true
+	assert.True(sandboxConfig.IsConfidential())
+
+	sandboxConfig.HypervisorConfig.ConfidentialGuest = false
+	sandboxConfig.HypervisorConfig.KernelParams = append(sandboxConfig.HypervisorConfig.KernelParams,
+		Param{Key: "mem_encrypt", Value: "on"})
+	assert.True(sandboxConfig.IsConfidential())
+}
+
+func TestSandboxConfigHostCgroupPaths(t *testing.T) {
+	assert := assert.New(t)
+
+	newContainer := func(id string, cType ContainerType, cgroupsPath string) ContainerConfig {
+		return ContainerConfig{
+			ID: id,
+			Annotations: map[string]string{
+				annotations.ContainerTypeKey: string(cType),
+			},
+			Spec: &specs.Spec{
+				Linux: &specs.Linux{CgroupsPath: cgroupsPath},
+			},
+		}
+	}
+
+	// cgroupfs
+	sandboxConfig := SandboxConfig{
+		ID: "sandbox",
+		Containers: []ContainerConfig{
+			newContainer("sandbox", PodSandbox, "/kubepods/besteffort/pod1/sandbox"),
+			newContainer("ctr", PodContainer, "/kubepods/besteffort/pod1/ctr"),
+		},
+	}
+
+	// the sandbox cgroup and the one of the sandbox container are the
+	// same one
+	assert.Equal([]string{
+		"/kubepods/besteffort/pod1/kata_sandbox",
+		"/kata/kubepods/besteffort/pod1/kata_sandbox",
+		"/kubepods/besteffort/pod1/kata_ctr",
+	}, sandboxConfig.HostCgroupPaths())
+
+	sandboxConfig.SandboxCgroupOnly = true
+	assert.Equal([]string{
+		"/kubepods/besteffort/pod1/kata_sandbox",
+		"/kubepods/besteffort/pod1/kata_ctr",
+	}, sandboxConfig.HostCgroupPaths())
+
+	// the runtime cgroups are created the same way with systemd, the
+	// cgroups path being relative to the default cgroup path, and the
+	// systemd scopes are listed too
+	sandboxConfig = SandboxConfig{
+		ID:                "sandbox",
+		SystemdCgroup:     true,
+		SandboxCgroupOnly: true,
+		Containers: []ContainerConfig{
+			newContainer("sandbox", PodSandbox, "kubepods-besteffort-pod1.slice:cri-containerd:sandbox"),
+			newContainer("ctr", PodContainer, ":docker:ctr"),
+		},
+	}
+
+	assert.Equal([]string{
+		"/vc/kata_sandbox",
+		"/vc/kata_kubepods-besteffort-pod1.slice:cri-containerd:sandbox",
+		"/vc/kata_:docker:ctr",
+		"/kubepods.slice/kubepods-besteffort.slice/kubepods-besteffort-pod1.slice/cri-containerd-sandbox.scope",
+		"/system.slice/docker-ctr.scope",
+	}, sandboxConfig.HostCgroupPaths())
+
+	assert.Empty(SandboxConfig{}.HostCgroupPaths())
+}
+
+func TestSandboxConfigBootOverheadMiB(t *testing.T) {
+	assert := assert.New(t)
+
+	sandboxConfig := SandboxConfig{
+		HypervisorType: QemuHypervisor,
+		HypervisorConfig: HypervisorConfig{
+			SharedFS:          config.VirtioFS,
+			VirtioFSCacheSize: 0,
+		},
+	}
+
+	overhead := sandboxConfig.BootOverheadMiB()
+	assert.Equal(hypervisorBootOverheadMiB[QemuHypervisor]+agentBootOverheadMiB, overhead)
+
+	// DAX enabled
+	sandboxConfig.HypervisorConfig.VirtioFSCacheSize = 1024
+	assert.Equal(overhead+1024, sandboxConfig.BootOverheadMiB())
+
+	// the DAX window only applies to virtio-fs
+	sandboxConfig.HypervisorConfig.SharedFS = config.Virtio9P
+	assert.Equal(overhead, sandboxConfig.BootOverheadMiB())
+
+	sandboxConfig.HypervisorType = FirecrackerHypervisor
+	assert.True(sandboxConfig.BootOverheadMiB() < overhead)
+}
+
+func TestSandboxConfigEstimatedBootTimeout(t *testing.T) {
+	assert := assert.New(t)
+
+	minimal := SandboxConfig{
+		HypervisorConfig: HypervisorConfig{
+			MemorySize: 256,
+			SharedFS:   config.Virtio9P,
+		},
+		Containers: []ContainerConfig{{ID: "1"}},
+	}
+	assert.Equal(vmStartTimeout*time.Second+bootTimeoutPerGiB, minimal.EstimatedBootTimeout())
+
+	heavy := SandboxConfig{
+		HypervisorConfig: HypervisorConfig{
+			MemorySize: 16384,
+			SharedFS:   config.VirtioFS,
+		},
+		Containers: []ContainerConfig{
+			{
+				ID:          "1",
+				DeviceInfos: []config.DeviceInfo{{ContainerPath: "/dev/vda"}, {ContainerPath: "/dev/vdb"}},
+			},
+			{
+				ID:          "2",
+				DeviceInfos: []config.DeviceInfo{{ContainerPath: "/dev/vfio/1"}},
+			},
+		},
+	}
+	expected := vmStartTimeout*time.Second + 3*bootTimeoutPerDevice + 16*bootTimeoutPerGiB + bootTimeoutVirtioFS
+	assert.Equal(expected, heavy.EstimatedBootTimeout())
+	assert.True(heavy.EstimatedBootTimeout() > minimal.EstimatedBootTimeout())
+}
+
+func TestSandboxConfigTemplateCompatible(t *testing.T) {
+	assert := assert.New(t)
+
+	newConfig := func(id string) SandboxConfig {
+		return SandboxConfig{
+			ID:             id,
+			HypervisorType: QemuHypervisor,
+			AgentType:      KataContainersAgent,
+			HypervisorConfig: HypervisorConfig{
+				KernelPath:   "/usr/share/kata/vmlinuz",
+				ImagePath:    "/usr/share/kata/kata.img",
+				KernelParams: []Param{{Key: "quiet"}},
+				MemorySize:   2048,
+				NumVCPUs:     1,
+				SharedFS:     config.VirtioFS,
+			},
+			Containers: []ContainerConfig{{ID: id}},
+		}
+	}
+
+	c1, c2 := newConfig("sandbox1"), newConfig("sandbox2")
+	c2.Hostname = "other"
+	assert.True(c1.TemplateCompatible(c2))
+	assert.True(c2.TemplateCompatible(c1))
+
+	// the memory is hotplugged once the VM is running
+	c2.HypervisorConfig.MemorySize = 4096
+	assert.True(c1.TemplateCompatible(c2))
+
+	c2 = newConfig("sandbox2")
+	c2.HypervisorConfig.KernelPath = "/usr/share/kata/vmlinux"
+	assert.False(c1.TemplateCompatible(c2))
+	assert.False(c2.TemplateCompatible(c1))
+
+	numaNode1, numaNode2 := uint32(1), uint32(1)
+	c1.HypervisorConfig.HugepageNUMANode = &numaNode1
+	c2 = newConfig("sandbox2")
+	c2.HypervisorConfig.HugepageNUMANode = &numaNode2
+	assert.True(c1.TemplateCompatible(c2))
+
+	numaNode2 = 0
+	assert.False(c1.TemplateCompatible(c2))
+	c1 = newConfig("sandbox1")
+
+	c2 = newConfig("sandbox2")
+	c2.HypervisorConfig.KernelParams = append(c2.HypervisorConfig.KernelParams, Param{Key: "debug"})
+	assert.False(c1.TemplateCompatible(c2))
+
+	c2 = newConfig("sandbox2")
+	c2.HypervisorConfig.SharedFS = config.Virtio9P
+	assert.False(c1.TemplateCompatible(c2))
+}
+
+func TestSandboxConfigHugepageMemoryMiB(t *testing.T) {
+	assert := assert.New(t)
+
+	sandboxConfig := SandboxConfig{
+		HypervisorConfig: HypervisorConfig{
+			MemorySize: 2048,
+		},
+	}
+	assert.Equal(uint32(0), sandboxConfig.HugepageMemoryMiB())
+
+	sandboxConfig.HypervisorConfig.HugePages = true
+	assert.Equal(uint32(2048), sandboxConfig.HugepageMemoryMiB())
+
+	// rounded up to the huge page size
+	sandboxConfig.HypervisorConfig.MemorySize = 2049
+	assert.Equal(uint32(2050), sandboxConfig.HugepageMemoryMiB())
+
+	sandboxConfig.HypervisorConfig.MemorySize = 0
+	assert.Equal(uint32(0), sandboxConfig.HugepageMemoryMiB())
+}
+
+func TestSandboxConfigValidateContainerIDs(t *testing.T) {
+	assert := assert.New(t)
+
+	sandboxConfig := SandboxConfig{
+		ID: testSandboxID,
+		Containers: []ContainerConfig{
+			{ID: "foo"},
+			{ID: "bar"},
+		},
+	}
+	assert.NoError(sandboxConfig.validateContainerIDs())
+
+	sandboxConfig.Containers = append(sandboxConfig.Containers, ContainerConfig{ID: "foo"})
+	err := sandboxConfig.validateContainerIDs()
+	assert.Error(err)
+	assert.Contains(err.Error(), `"foo"`)
+
+	_, err = newSandbox(context.Background(), sandboxConfig, nil)
+	assert.Error(err)
+}
+
+func TestSandboxConfigNeedsVhostNet(t *testing.T) {
+	assert := assert.New(t)
+
+	// minimal
+	assert.False(SandboxConfig{}.NeedsVhostNet())
+
+	// multi-queue
+	sandboxConfig := SandboxConfig{
+		HypervisorType: QemuHypervisor,
+		HypervisorConfig: HypervisorConfig{
+			NumVCPUs: 4,
+		},
+		NetworkConfig: NetworkConfig{
+			InterworkingModel: NetXConnectTCFilterModel,
+		},
+	}
+	assert.True(sandboxConfig.NeedsVhostNet())
+
+	sandboxConfig.NetworkConfig.InterworkingModel = NetXConnectNoneModel
+	assert.False(sandboxConfig.NeedsVhostNet())
+
+	sandboxConfig.NetworkConfig.InterworkingModel = NetXConnectMacVtapModel
+	sandboxConfig.HypervisorConfig.DisableVhostNet = true
+	assert.False(sandboxConfig.NeedsVhostNet())
+
+	sandboxConfig.HypervisorConfig.DisableVhostNet = false
+	sandboxConfig.NetworkConfig.DisableNewNetNs = true
+	assert.False(sandboxConfig.NeedsVhostNet())
+
+	sandboxConfig.NetworkConfig.DisableNewNetNs = false
+	sandboxConfig.HypervisorType = FirecrackerHypervisor
+	assert.False(sandboxConfig.NeedsVhostNet())
+}
+
+func TestSandboxConfigUnionCapabilities(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Empty(SandboxConfig{}.UnionCapabilities())
+
+	sandboxConfig := SandboxConfig{
+		Containers: []ContainerConfig{
+			{
+				ID: "foo",
+				Cmd: types.Cmd{
+					Capabilities: &specs.LinuxCapabilities{
+						Effective: []string{"CAP_CHOWN", "CAP_KILL"},
+						Permitted: []string{"CAP_CHOWN", "CAP_KILL"},
+					},
+				},
+			},
+			{
+				ID: "bar",
+				Cmd: types.Cmd{
+					Capabilities: &specs.LinuxCapabilities{
+						Effective: []string{"kill", "CAP_NET_ADMIN"},
+						Permitted: []string{"CAP_KILL", "CAP_NET_ADMIN"},
+					},
+				},
+			},
+			{
+				ID: "baz",
+			},
+		},
+	}
+
+	assert.Equal([]string{"CAP_CHOWN", "CAP_KILL", "CAP_NET_ADMIN"}, sandboxConfig.UnionCapabilities())
+}
+
+func TestSandboxConfigAllShareNetwork(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.True(SandboxConfig{}.AllShareNetwork())
+
+	netNSPath := "/var/run/netns/cni-1234"
+
+	newContainer := func(id string, containerType ContainerType, path string) ContainerConfig {
+		return ContainerConfig{
+			ID: id,
+			Annotations: map[string]string{
+				annotations.ContainerTypeKey: string(containerType),
+			},
+			Spec: &specs.Spec{
+				Linux: &specs.Linux{
+					Namespaces: []specs.LinuxNamespace{
+						{Type: specs.PIDNamespace},
+						{Type: specs.NetworkNamespace, Path: path},
+					},
+				},
+			},
+		}
+	}
+
+	// the pause container creates the network namespace joined by the
+	// other containers
+	sandboxConfig := SandboxConfig{
+		NetworkConfig: NetworkConfig{
+			NetNSPath: netNSPath,
+		},
+		Containers: []ContainerConfig{
+			newContainer("pause", PodSandbox, ""),
+			newContainer("foo", PodContainer, netNSPath),
+			{ID: "bar"},
+		},
+	}
+	assert.True(sandboxConfig.AllShareNetwork())
+
+	// a container with its own network namespace
+	sandboxConfig.Containers = append(sandboxConfig.Containers, newContainer("baz", PodContainer, ""))
+	assert.False(sandboxConfig.AllShareNetwork())
+
+	// a container joining another network namespace
+	sandboxConfig.Containers[3] = newContainer("baz", PodContainer, "/var/run/netns/other")
+	assert.False(sandboxConfig.AllShareNetwork())
+}
+
+func TestSandboxConfigRunAsIDs(t *testing.T) {
+	assert := assert.New(t)
+
+	uids, gids := SandboxConfig{}.RunAsIDs()
+	assert.Empty(uids)
+	assert.Empty(gids)
+
+	sandboxConfig := SandboxConfig{
+		Containers: []ContainerConfig{
+			{
+				ID: "pause",
+				Cmd: types.Cmd{
+					User:         "65535",
+					PrimaryGroup: "65535",
+				},
+			},
+			{
+				ID: "foo",
+				Cmd: types.Cmd{
+					User:                "0",
+					PrimaryGroup:        "0",
+					SupplementaryGroups: []string{"10", "0"},
+				},
+			},
+			{
+				ID: "bar",
+				Cmd: types.Cmd{
+					User:                "1000",
+					PrimaryGroup:        "1000",
+					SupplementaryGroups: []string{"10"},
+				},
+			},
+			{
+				ID: "baz",
+				Cmd: types.Cmd{
+					User:         "nobody",
+					PrimaryGroup: "nogroup",
+				},
+			},
+		},
+	}
+
+	uids, gids = sandboxConfig.RunAsIDs()
+	assert.Equal([]uint32{0, 1000, 65535}, uids)
+	assert.Equal([]uint32{0, 10, 1000, 65535}, gids)
+}
@@ -17,6 +17,10 @@ type ContainerState struct {
 	// CgroupPath is the cgroup hierarchy where sandbox's processes
 	// including the hypervisor are placed.
 	CgroupPath string `json:"cgroupPath,omitempty"`
+
+	// ExitCode is the exit code of the container process, nil until
+	// the process has been waited on.
+	ExitCode *int32 `json:"exitCode,omitempty"`
 }
 
 // Valid checks that the container state is valid.
@@ -7,6 +7,7 @@ package types
 
 import (
 	"fmt"
+	"sort"
 	"strings"
 
 	"github.com/opencontainers/runtime-spec/specs-go"
@@ -264,6 +265,102 @@ type Cmd struct {
 	Interactive     bool
 	Detach          bool
 	NoNewPrivileges bool
+
+	// Rlimits are the resource limits the agent applies to the process.
+	Rlimits []Rlimit
+
+	// OOMScoreAdj is the OOM score adjustment of the process, between
+	// -1000 and 1000. The default one is kept when nil.
+	OOMScoreAdj *int
+}
+
+// Rlimit is a resource limit of a process, as set by setrlimit(2).
+type Rlimit struct {
+	// Type is the resource name, e.g. "RLIMIT_NOFILE".
+	Type string
+
+	// Soft is the limit enforced for the resource.
+	Soft uint64
+
+	// Hard is the ceiling of the soft limit.
+	Hard uint64
+}
+
+// knownCapabilities lists the Linux capabilities, as named in the OCI spec.
+var knownCapabilities = []string{
+	"CAP_CHOWN", "CAP_DAC_OVERRIDE", "CAP_DAC_READ_SEARCH", "CAP_FOWNER",
+	"CAP_FSETID", "CAP_KILL", "CAP_SETGID", "CAP_SETUID", "CAP_SETPCAP",
+	"CAP_LINUX_IMMUTABLE", "CAP_NET_BIND_SERVICE", "CAP_NET_BROADCAST",
+	"CAP_NET_ADMIN", "CAP_NET_RAW", "CAP_IPC_LOCK", "CAP_IPC_OWNER",
+	"CAP_SYS_MODULE", "CAP_SYS_RAWIO", "CAP_SYS_CHROOT", "CAP_SYS_PTRACE",
+	"CAP_SYS_PACCT", "CAP_SYS_ADMIN", "CAP_SYS_BOOT", "CAP_SYS_NICE",
+	"CAP_SYS_RESOURCE", "CAP_SYS_TIME", "CAP_SYS_TTY_CONFIG", "CAP_MKNOD",
+	"CAP_LEASE", "CAP_AUDIT_WRITE", "CAP_AUDIT_CONTROL", "CAP_SETFCAP",
+	"CAP_MAC_OVERRIDE", "CAP_MAC_ADMIN", "CAP_SYSLOG", "CAP_WAKE_ALARM",
+	"CAP_BLOCK_SUSPEND", "CAP_AUDIT_READ", "CAP_PERFMON", "CAP_BPF",
+	"CAP_CHECKPOINT_RESTORE",
+}
+
+// NormalizeCapability returns the canonical CAP_ prefixed and upper case
+// form of a capability name, so that "net_raw" and "CAP_NET_RAW" are the
+// same capability.
+func NormalizeCapability(capability string) string {
+	capability = strings.ToUpper(strings.TrimSpace(capability))
+	if !strings.HasPrefix(capability, "CAP_") {
+		capability = "CAP_" + capability
+	}
+
+	return capability
+}
+
+// IsKnownCapability tells if the capability, once normalized by
+// NormalizeCapability, is a known Linux capability.
+func IsKnownCapability(capability string) bool {
+	capability = NormalizeCapability(capability)
+	for _, c := range knownCapabilities {
+		if c == capability {
+			return true
+		}
+	}
+
+	return false
+}
+
+// EffectiveCapabilities returns the sorted set of capabilities the command
+// process will actually have in its effective set. The ambient capabilities
+// are raised in the effective set unless no new privileges can be gained,
+// and the effective set is always limited to the permitted set. A command
+// without capabilities gets none.
+func (c Cmd) EffectiveCapabilities() []string {
+	if c.Capabilities == nil {
+		return []string{}
+	}
+
+	permitted := make(map[string]bool)
+	for _, capability := range c.Capabilities.Permitted {
+		permitted[NormalizeCapability(capability)] = true
+	}
+
+	requested := c.Capabilities.Effective
+	if !c.NoNewPrivileges {
+		requested = append(append([]string{}, requested...), c.Capabilities.Ambient...)
+	}
+
+	effective := make(map[string]bool)
+	for _, capability := range requested {
+		capability = NormalizeCapability(capability)
+		if permitted[capability] {
+			effective[capability] = true
+		}
+	}
+
+	capabilities := make([]string, 0, len(effective))
+	for capability := range effective {
+		capabilities = append(capabilities, capability)
+	}
+	sort.Strings(capabilities)
+
+	return capabilities
 }
 
 // Resources describes VM resources configuration.
@@ -0,0 +1,54 @@
+// Copyright (c) 2026 Kata Contributors
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+
+package types
+
+import (
+	"testing"
+
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCmdEffectiveCapabilities(t *testing.T) {
+	assert := assert.New(t)
+
+	var cmd Cmd
+	assert.Empty(cmd.EffectiveCapabilities())
+
+	// minimal config, with the same set for every capability kind
+	capList := []string{"CAP_AUDIT_WRITE", "CAP_KILL", "CAP_NET_BIND_SERVICE"}
+	cmd.Capabilities = &specs.LinuxCapabilities{
+		Bounding:    capList,
+		Effective:   capList,
+		Inheritable: capList,
+		Permitted:   capList,
+		Ambient:     capList,
+	}
+	assert.Equal(capList, cmd.EffectiveCapabilities())
+
+	cmd.Capabilities = &specs.LinuxCapabilities{
+		Effective: []string{"kill", "CAP_SYS_ADMIN", "CAP_KILL"},
+		Permitted: []string{"CAP_KILL", "CAP_NET_RAW", "net_bind_service"},
+		Ambient:   []string{"CAP_NET_RAW"},
+	}
+	assert.Equal([]string{"CAP_KILL", "CAP_NET_RAW"}, cmd.EffectiveCapabilities())
+
+	// ambient capabilities are not raised without new privileges
+	cmd.NoNewPrivileges = true
+	assert.Equal([]string{"CAP_KILL"}, cmd.EffectiveCapabilities())
+}
+
+func TestIsKnownCapability(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, capability := range []string{"CAP_NET_RAW", "net_raw", " Sys_Admin "} {
+		assert.True(IsKnownCapability(capability), "capability %q", capability)
+	}
+
+	for _, capability := range []string{"", "CAP_", "CAP_NET_RWA", "foo"} {
+		assert.False(IsKnownCapability(capability), "capability %q", capability)
+	}
+}
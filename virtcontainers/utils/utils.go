@@ -12,6 +12,8 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strconv"
+	"strings"
 )
 
 // DefaultCgroupPath runtime-determined location in the cgroups hierarchy.
@@ -139,6 +141,89 @@ func ConstraintsToVCPUs(quota int64, period uint64) uint {
 	return 0
 }
 
+// DefaultCPUWeight is the cgroup v2 CPU weight of a cgroup without any
+// CPU shares set.
+const DefaultCPUWeight = 100
+
+// CPUSharesToWeight converts cgroup v1 CPU shares, in the [2, 262144] range,
+// into the cgroup v2 CPU weight, in the [1, 10000] range, as runc does. A
+// zero value means unset, DefaultCPUWeight being returned then.
+func CPUSharesToWeight(shares uint64) uint64 {
+	if shares == 0 {
+		return DefaultCPUWeight
+	}
+
+	if shares < 2 {
+		shares = 2
+	} else if shares > 262144 {
+		shares = 262144
+	}
+
+	return 1 + ((shares-2)*9999)/262142
+}
+
+// CPUWeightToShares converts a cgroup v2 CPU weight, in the [1, 10000]
+// range, into cgroup v1 CPU shares, in the [2, 262144] range. It is the
+// inverse of CPUSharesToWeight, a zero value meaning unset and being
+// returned as is.
+func CPUWeightToShares(weight uint64) uint64 {
+	if weight == 0 {
+		return 0
+	}
+
+	if weight > 10000 {
+		weight = 10000
+	}
+
+	// Rounding up keeps the conversion back to the weight exact.
+	return 2 + ((weight-1)*262142+9998)/9999
+}
+
+// memorySizeSuffixes maps the accepted memory size suffixes to their shift.
+// Both the Kubernetes binary suffixes and the single letter suffixes used by
+// mount(8) options are binary multiples.
+var memorySizeSuffixes = []struct {
+	suffix string
+	shift  uint
+}{
+	{"Ki", 10}, {"Mi", 20}, {"Gi", 30},
+	{"k", 10}, {"m", 20}, {"g", 30},
+	{"K", 10}, {"M", 20}, {"G", 30},
+}
+
+// ParseMemorySize converts a memory size string into a number of bytes.
+// The following forms are accepted:
+//
+//   - raw bytes, e.g. "1048576"
+//   - Kubernetes binary suffixes "Ki", "Mi" and "Gi", e.g. "512Mi"
+//   - single letter suffixes "k", "m" and "g" (or their upper case variant),
+//     as used by mount options, e.g. "65536k"
+//
+// All suffixes are binary multiples of 1024.
+func ParseMemorySize(s string) (uint64, error) {
+	value := s
+	var shift uint
+
+	for _, m := range memorySizeSuffixes {
+		if strings.HasSuffix(s, m.suffix) {
+			value = strings.TrimSuffix(s, m.suffix)
+			shift = m.shift
+			break
+		}
+	}
+
+	size, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid memory size %q", s)
+	}
+
+	if size > (^uint64(0))>>shift {
+		return 0, fmt.Errorf("Memory size %q overflows", s)
+	}
+
+	return size << shift, nil
+}
+
 // GetVirtDriveName returns the disk name format for virtio-blk
 // Reference: https://github.com/torvalds/linux/blob/master/drivers/block/virtio_blk.c @c0aa3e0916d7e531e69b02e426f7162dfb1c6c0
 func GetVirtDriveName(index int) (string, error) {
@@ -135,6 +135,40 @@ func TestConstraintsToVCPUs(t *testing.T) {
 	assert.Equal(expectedVCPUs, vcpus)
 }
 
+func TestCPUSharesToWeight(t *testing.T) {
+	assert := assert.New(t)
+
+	for shares, weight := range map[uint64]uint64{
+		0:      DefaultCPUWeight,
+		1:      1,
+		2:      1,
+		1024:   39,
+		262144: 10000,
+		300000: 10000,
+	} {
+		assert.Equal(weight, CPUSharesToWeight(shares), "shares %d", shares)
+	}
+}
+
+func TestCPUWeightToShares(t *testing.T) {
+	assert := assert.New(t)
+
+	for weight, shares := range map[uint64]uint64{
+		0:     0,
+		1:     2,
+		100:   2598,
+		10000: 262144,
+		20000: 262144,
+	} {
+		assert.Equal(shares, CPUWeightToShares(weight), "weight %d", weight)
+	}
+
+	// the conversions round trip on the weight
+	for _, weight := range []uint64{1, 39, 100, 5000, 10000} {
+		assert.Equal(weight, CPUSharesToWeight(CPUWeightToShares(weight)), "weight %d", weight)
+	}
+}
+
 func TestGetVirtDriveNameInvalidIndex(t *testing.T) {
 	assert := assert.New(t)
 	_, err := GetVirtDriveName(-1)
@@ -287,3 +321,33 @@ func TestValidCgroupPath(t *testing.T) {
 	assert.Equal(DefaultCgroupPath, ValidCgroupPath("./../"))
 	assert.Equal(filepath.Join(DefaultCgroupPath, "o / g"), ValidCgroupPath("o / m /../ g"))
 }
+
+func TestParseMemorySize(t *testing.T) {
+	assert := assert.New(t)
+
+	data := []struct {
+		s        string
+		expected uint64
+	}{
+		{"0", 0},
+		{"1048576", 1048576},
+		{"4Ki", 4 << 10},
+		{"512Mi", 512 << 20},
+		{"2Gi", 2 << 30},
+		{"65536k", 65536 << 10},
+		{"100m", 100 << 20},
+		{"1g", 1 << 30},
+		{"256M", 256 << 20},
+	}
+
+	for _, d := range data {
+		size, err := ParseMemorySize(d.s)
+		assert.NoError(err, "%q", d.s)
+		assert.Equal(d.expected, size, "%q", d.s)
+	}
+
+	for _, s := range []string{"", "Mi", "-1", "1.5Gi", "10MB", "10 Mi", "abc", "17179869184Gi"} {
+		_, err := ParseMemorySize(s)
+		assert.Error(err, "%q", s)
+	}
+}
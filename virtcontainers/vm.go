@@ -16,6 +16,7 @@ import (
 	pb "github.com/kata-containers/runtime/protocols/cache"
 	"github.com/kata-containers/runtime/virtcontainers/pkg/uuid"
 	"github.com/kata-containers/runtime/virtcontainers/store"
+	"github.com/kata-containers/runtime/virtcontainers/utils"
 	"github.com/sirupsen/logrus"
 )
 
@@ -55,6 +56,49 @@ func (c *VMConfig) Valid() error {
 	return c.HypervisorConfig.valid()
 }
 
+func resetHypervisorConfig(config *VMConfig) {
+	config.HypervisorConfig.NumVCPUs = 0
+	config.HypervisorConfig.MemorySize = 0
+	config.HypervisorConfig.BootToBeTemplate = false
+	config.HypervisorConfig.BootFromTemplate = false
+	config.HypervisorConfig.MemoryPath = ""
+	config.HypervisorConfig.DevicesStatePath = ""
+	config.ProxyConfig = ProxyConfig{}
+}
+
+// CheckCompatible returns an error if a VM created with the config can't be
+// used in place of a VM created with other. The vCPUs and the memory, which
+// are hotplugged once the VM is running, and the template settings are
+// ignored.
+// It's important that both configs are passed by value!
+func (c VMConfig) CheckCompatible(other VMConfig) error {
+	if c.HypervisorType != other.HypervisorType {
+		return fmt.Errorf("hypervisor type does not match: %s vs. %s", c.HypervisorType, other.HypervisorType)
+	}
+
+	if c.AgentType != other.AgentType {
+		return fmt.Errorf("agent type does not match: %s vs. %s", c.AgentType, other.AgentType)
+	}
+
+	// the NUMA nodes are compared by value, not by address
+	n1, n2 := c.HypervisorConfig.HugepageNUMANode, other.HypervisorConfig.HugepageNUMANode
+	if (n1 == nil) != (n2 == nil) || (n1 != nil && *n1 != *n2) {
+		return fmt.Errorf("hugepage NUMA node does not match")
+	}
+	c.HypervisorConfig.HugepageNUMANode = nil
+	other.HypervisorConfig.HugepageNUMANode = nil
+
+	// check hypervisor config details
+	resetHypervisorConfig(&c)
+	resetHypervisorConfig(&other)
+
+	if !utils.DeepCompare(c, other) {
+		return fmt.Errorf("hypervisor config does not match, base: %+v. new: %+v", c, other)
+	}
+
+	return nil
+}
+
 // ToGrpc convert VMConfig struct to grpc format pb.GrpcVMConfig.
 func (c *VMConfig) ToGrpc() (*pb.GrpcVMConfig, error) {
 	data, err := json.Marshal(&c)
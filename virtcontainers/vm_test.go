@@ -118,7 +118,7 @@ func TestVMConfigGrpc(t *testing.T) {
 		HypervisorType:   QemuHypervisor,
 		HypervisorConfig: newQemuConfig(),
 		AgentType:        KataContainersAgent,
-		AgentConfig:      KataAgentConfig{false, true, false, false, "", "", []string{}},
+		AgentConfig:      KataAgentConfig{false, true, false, false, "", "", []string{}, nil},
 		ProxyType:        NoopProxyType,
 	}
 